@@ -17,7 +17,7 @@ const PUBLIC_ROUTES = [
 
 // Define protected route patterns
 const PROTECTED_PATTERNS = [
-  /^\/blog\/editor(?!\/login)/,  // All /blog/editor/* except /login
+  /^\/blog\/editor(?!\/(login|register|magic-link|verify-email|reset-password|accept-invite|sso))/,  // All /blog/editor/* except the sign-in pages
   /^\/admin/,                     // All admin routes
 ];
 
@@ -35,10 +35,14 @@ export const onRequest = defineMiddleware(async (context, next) => {
   
   // Get auth token
   const token = cookies.get('auth-token');
+
+  // The login page renews expired access tokens from the refresh token, then sends the
+  // user back here
+  const login = `/blog/editor/login?next=${encodeURIComponent(pathname + url.search)}`;
   
   // If no token, redirect to login
   if (!token) {
-    return redirect('/blog/editor/login');
+    return redirect(login);
   }
   
   // Verify token with backend
@@ -57,7 +61,7 @@ export const onRequest = defineMiddleware(async (context, next) => {
     if (!response.ok) {
       // Invalid token, clear it and redirect
       cookies.delete('auth-token', { path: '/' });
-      return redirect('/blog/editor/login');
+      return redirect(login);
     }
     
     // Token is valid, store user info in locals for pages to use
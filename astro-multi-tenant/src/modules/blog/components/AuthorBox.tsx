@@ -0,0 +1,104 @@
+// Public author profile as returned by the API in author_data and /api/authors/{slug}
+export interface Author {
+  id: string;
+  name: string;
+  slug?: string;
+  bio?: string;
+  website?: string;
+  avatar?: { url: string; sizes: Record<string, string> };
+  socialLinks?: { network: string; handle: string; url: string }[];
+}
+
+const networkNames: Record<string, string> = {
+  twitter: 'X',
+  github: 'GitHub',
+  linkedin: 'LinkedIn',
+  youtube: 'YouTube',
+  instagram: 'Instagram',
+  devto: 'DEV',
+  reddit: 'Reddit',
+  bluesky: 'Bluesky',
+  mastodon: 'Mastodon',
+};
+
+// In development the API serves uploads; in production nginx serves /uploads/ directly
+function uploadUrl(url: string) {
+  return import.meta.env.PUBLIC_API_URL && url.startsWith('/uploads/')
+    ? `${import.meta.env.PUBLIC_API_URL}${url}`
+    : url;
+}
+
+export function authorUrl(author: Author | null | undefined) {
+  return author?.slug ? `/blog/author/${author.slug}` : null;
+}
+
+interface AuthorAvatarProps {
+  author: Author | null | undefined;
+  size?: number;
+}
+
+export function AuthorAvatar({ author, size = 48 }: AuthorAvatarProps) {
+  const avatar = author?.avatar;
+  if (avatar) {
+    // Pick the smallest stored size that stays sharp on high density screens
+    const stored = Object.keys(avatar.sizes).map(Number).sort((a, b) => a - b);
+    const best = stored.find((s) => s >= size * 2) ?? stored[stored.length - 1];
+    return (
+      <img
+        src={uploadUrl(avatar.sizes[String(best)] || avatar.url)}
+        alt={author?.name || ''}
+        width={size}
+        height={size}
+        className="rounded-full object-cover"
+        style={{ width: size, height: size }}
+      />
+    );
+  }
+  return (
+    <div
+      className="rounded-full bg-blog-author-avatar-bg flex items-center justify-center text-blog-author-avatar-text font-medium"
+      style={{ width: size, height: size }}
+    >
+      {author?.name?.charAt(0).toUpperCase() || 'A'}
+    </div>
+  );
+}
+
+interface AuthorBoxProps {
+  author: Author | null | undefined;
+}
+
+// Author box shown under posts and at the top of author pages
+export default function AuthorBox({ author }: AuthorBoxProps) {
+  if (!author) return null;
+  const href = authorUrl(author);
+
+  return (
+    <section className="flex gap-4 items-start border-t border-blog-border pt-8 mt-12">
+      <AuthorAvatar author={author} size={64} />
+      <div className="flex-1">
+        <div className="text-sm blog-text-secondary uppercase tracking-wider mb-1">Written by</div>
+        <div className="text-lg font-medium blog-text-primary">
+          {href ? <a href={href} className="hover:underline">{author.name}</a> : author.name}
+        </div>
+        {author.bio && (
+          <p className="blog-text-secondary mt-2 whitespace-pre-line">{author.bio}</p>
+        )}
+        {(author.website || author.socialLinks?.length) ? (
+          <div className="flex flex-wrap gap-x-4 gap-y-1 mt-3 text-sm">
+            {author.website && (
+              <a href={author.website} className="blog-link" rel="me noopener" target="_blank">
+                Website
+              </a>
+            )}
+            {author.socialLinks?.map((link) => (
+              <a key={link.network} href={link.url} className="blog-link" rel="me noopener" target="_blank" title={link.handle}>
+                {networkNames[link.network] || link.network}
+              </a>
+            ))}
+          </div>
+        ) : null}
+      </div>
+    </section>
+  );
+}
@@ -8,6 +8,7 @@ import Youtube from '@tiptap/extension-youtube';
 import { common, createLowlight } from 'lowlight';
 import 'highlight.js/styles/github-dark.css';
 import './viewer-styles.css';
+import AuthorBox, { AuthorAvatar, authorUrl, type Author } from './AuthorBox';
 
 // Create lowlight instance with common languages
 const lowlight = createLowlight(common);
@@ -25,10 +26,7 @@ interface BlogPostViewerProps {
     content: string;
     category_data?: { name: string; slug: string } | null;
     coverImage?: string;
-    author_data: {
-      name: string;
-      email: string;
-    } | null;
+    author_data: Author | null;
     readingTime: number;
     createdAt: string;
   };
@@ -172,13 +170,15 @@ export default function BlogPostViewer({ post }: BlogPostViewerProps) {
             
             {/* Author info */}
             <div className="flex items-center gap-3 mb-8">
-              <div className="w-12 h-12 rounded-full bg-blog-author-avatar-bg flex items-center justify-center text-blog-author-avatar-text font-medium">
-                {post.author_data?.name?.charAt(0).toUpperCase() || 'A'}
-              </div>
+              <AuthorAvatar author={post.author_data} size={48} />
               <div className="flex-1">
                 <div className="flex items-center gap-2">
                   <span className="font-medium blog-text-primary text-base">
-                    {post.author_data?.name || 'Anonymous'}
+                    {authorUrl(post.author_data) ? (
+                      <a href={authorUrl(post.author_data)!} className="hover:underline">{post.author_data!.name}</a>
+                    ) : (
+                      post.author_data?.name || 'Anonymous'
+                    )}
                   </span>
                 </div>
                 <div className="flex items-center gap-2 text-sm blog-text-secondary">
@@ -205,6 +205,8 @@ export default function BlogPostViewer({ post }: BlogPostViewerProps) {
           <div className="blog-post-content-medium">
             <EditorContent editor={editor} />
           </div>
+
+          <AuthorBox author={post.author_data} />
         </article>
       </div>
       
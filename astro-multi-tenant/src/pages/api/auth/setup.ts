@@ -2,11 +2,29 @@ import { API_URL } from '../../../shared/lib/api-config';
 
 export const prerender = false;
 
+export async function GET({ request }: { request: Request }) {
+  const database = request.headers.get('X-Site-Database') || 'codersinflow';
+
+  const response = await fetch(`${API_URL}/api/auth/setup`, {
+    method: 'GET',
+    headers: {
+      'X-Site-Database': database
+    }
+  });
+
+  return new Response(await response.text(), {
+    status: response.status,
+    headers: {
+      'Content-Type': response.headers.get('Content-Type') || 'application/json'
+    }
+  });
+}
+
 export async function POST({ request }: { request: Request }) {
   const database = request.headers.get('X-Site-Database') || 'codersinflow';
   const body = await request.json();
-  
-  const response = await fetch(`${API_URL}/api/auth/create-admin`, {
+
+  const response = await fetch(`${API_URL}/api/auth/setup`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
@@ -14,11 +32,11 @@ export async function POST({ request }: { request: Request }) {
     },
     body: JSON.stringify(body)
   });
-  
+
   return new Response(await response.text(), {
     status: response.status,
     headers: {
       'Content-Type': response.headers.get('Content-Type') || 'application/json'
     }
   });
-}
\ No newline at end of file
+}
@@ -325,6 +325,7 @@ const DevModeOverlay: React.FC = () => {
         headers: {
           'Content-Type': 'application/json',
         },
+        credentials: 'include',
         body: JSON.stringify({
           site,
           component1: {
@@ -383,6 +384,7 @@ const DevModeOverlay: React.FC = () => {
         headers: {
           'Content-Type': 'application/json',
         },
+        credentials: 'include',
         body: JSON.stringify({
           path: component.dataPath,
           site: site,
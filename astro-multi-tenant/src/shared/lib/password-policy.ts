@@ -0,0 +1,52 @@
+// The site's password rules, as enforced by the backend, for password forms to show
+import { API_URL } from './api-config';
+
+export interface PasswordPolicy {
+  minLength: number;
+  requireUpper: boolean;
+  requireLower: boolean;
+  requireDigit: boolean;
+  requireSymbol: boolean;
+  checksBreached: boolean;
+}
+
+const fallback: PasswordPolicy = {
+  minLength: 8,
+  requireUpper: false,
+  requireLower: false,
+  requireDigit: false,
+  requireSymbol: false,
+  checksBreached: true,
+};
+
+// Fetch the site's policy; the backend still checks passwords if this fails
+export async function getPasswordPolicy(database: string): Promise<PasswordPolicy> {
+  try {
+    const response = await fetch(`${API_URL}/api/auth/password-policy`, {
+      headers: { 'X-Site-Database': database }
+    });
+    if (response.ok) {
+      return { ...fallback, ...(await response.json()) };
+    }
+  } catch (error) {
+    console.error('Failed to fetch password policy:', error);
+  }
+  return fallback;
+}
+
+// One-line description of the rules, e.g. "At least 12 characters, with a digit and a symbol"
+export function describePasswordPolicy(policy: PasswordPolicy): string {
+  const needs = [
+    policy.requireUpper && 'an uppercase letter',
+    policy.requireLower && 'a lowercase letter',
+    policy.requireDigit && 'a digit',
+    policy.requireSymbol && 'a symbol',
+  ].filter(Boolean) as string[];
+
+  let text = `At least ${policy.minLength} characters`;
+  if (needs.length > 0) {
+    const last = needs.pop();
+    text += `, with ${needs.length > 0 ? `${needs.join(', ')} and ${last}` : last}`;
+  }
+  return text + ". Avoid your name, the site's name and common passwords.";
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -10,10 +11,12 @@ import (
 
 	"github.com/coders-website/backend/internal/database"
 	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/passwords"
+	"github.com/coders-website/backend/internal/setup"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type SiteConfig struct {
@@ -24,7 +27,13 @@ type SiteConfig struct {
 	} `json:"admin"`
 }
 
+// siteDatabase is the site database to provision; empty means the default database
+var siteDatabase string
+
 func main() {
+	flag.StringVar(&siteDatabase, "database", os.Getenv("ADMIN_DATABASE"), "site database to create the admin in (default: the main database)")
+	flag.Parse()
+
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
@@ -102,12 +111,16 @@ func createOrUpdateAdmin(email, password, name string) error {
 	defer cancel()
 
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(password)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %v", err)
 	}
 
-	collection := database.GetCollection("users")
+	db := database.GetDB()
+	if siteDatabase != "" {
+		db = database.GetTenantDB(siteDatabase)
+	}
+	collection := db.Collection("users")
 
 	// Check if user exists
 	var existingUser models.User
@@ -116,28 +129,31 @@ func createOrUpdateAdmin(email, password, name string) error {
 	if err == mongo.ErrNoDocuments {
 		// Create new admin user
 		newUser := models.User{
-			Name:      name,
-			Email:     email,
-			Password:  string(hashedPassword),
-			Role:      "admin",
-			Approved:  true,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+			Name:          name,
+			Email:         email,
+			Password:      hashedPassword,
+			Role:          "admin",
+			Approved:      true,
+			EmailVerified: true,
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
 		}
 
-		_, err = collection.InsertOne(ctx, newUser)
+		result, err := collection.InsertOne(ctx, newUser)
 		if err != nil {
 			return fmt.Errorf("failed to create admin user: %v", err)
 		}
+		existingUser.ID = result.InsertedID.(primitive.ObjectID)
 		log.Println("New admin user created")
 	} else if err == nil {
 		// Update existing user
 		update := bson.M{
 			"$set": bson.M{
-				"password":  string(hashedPassword),
-				"role":      "admin",
-				"approved":  true,
-				"updatedAt": time.Now(),
+				"password":      hashedPassword,
+				"role":          "admin",
+				"approved":      true,
+				"emailVerified": true,
+				"updatedAt":     time.Now(),
 			},
 		}
 
@@ -150,5 +166,10 @@ func createOrUpdateAdmin(email, password, name string) error {
 		return fmt.Errorf("failed to check for existing user: %v", err)
 	}
 
+	// The site now has an admin, so its first-run setup page is no longer needed
+	if err := setup.MarkCompleted(ctx, db, existingUser.ID); err != nil {
+		return fmt.Errorf("failed to lock first-run setup: %v", err)
+	}
+
 	return nil
-}
\ No newline at end of file
+}
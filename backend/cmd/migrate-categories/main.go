@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// One-off migration: older posts store their category as a hex string while newer
+// ones use an ObjectID. This converts every string category to an ObjectID in all
+// tenant databases listed in sites-config.json.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "Report what would change without writing")
+	only := flag.String("databases", "", "Comma-separated list of databases to migrate (default: all tenants)")
+	flag.Parse()
+
+	if err := godotenv.Load("../.env"); err != nil {
+		if err := godotenv.Load(); err != nil {
+			log.Println("No .env file found")
+		}
+	}
+	middleware.LoadSitesConfig()
+
+	if err := database.Connect(); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Disconnect()
+
+	databases := middleware.GetAllTenantDatabases()
+	if *only != "" {
+		databases = strings.Split(*only, ",")
+	}
+
+	for _, dbName := range databases {
+		dbName = strings.TrimSpace(dbName)
+		if dbName == "" {
+			continue
+		}
+		if err := migrateDatabase(dbName, *dryRun); err != nil {
+			log.Printf("[%s] migration failed: %v", dbName, err)
+		}
+	}
+}
+
+func migrateDatabase(dbName string, dryRun bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	posts := database.GetTenantCollection(dbName, "posts")
+
+	validHex := bson.M{"category": bson.M{"$type": "string", "$regex": "^[0-9a-fA-F]{24}$"}}
+	invalid := bson.M{"category": bson.M{"$type": "string", "$not": bson.M{"$regex": "^[0-9a-fA-F]{24}$"}}}
+
+	convertible, err := posts.CountDocuments(ctx, validHex)
+	if err != nil {
+		return err
+	}
+
+	// Strings that aren't ObjectIDs can't be converted; list them for manual cleanup
+	cursor, err := posts.Find(ctx, invalid)
+	if err != nil {
+		return err
+	}
+	var broken []struct {
+		Slug     string `bson:"slug"`
+		Category string `bson:"category"`
+	}
+	if err := cursor.All(ctx, &broken); err != nil {
+		return err
+	}
+	for _, p := range broken {
+		log.Printf("[%s] post %q has unconvertible category %q, skipping", dbName, p.Slug, p.Category)
+	}
+
+	if dryRun {
+		log.Printf("[%s] %d posts would be converted", dbName, convertible)
+		return nil
+	}
+
+	result, err := posts.UpdateMany(ctx, validHex, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"category": bson.M{"$toObjectId": "$category"}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[%s] converted %d posts", dbName, result.ModifiedCount)
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"github.com/coders-website/backend/internal/database"
 	"github.com/coders-website/backend/internal/handlers"
 	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
@@ -23,6 +25,7 @@ func main() {
 			log.Println("No .env file found")
 		}
 	}
+	middleware.LoadSitesConfig()
 
 	// Connect to MongoDB
 	if err := database.Connect(); err != nil {
@@ -30,6 +33,12 @@ func main() {
 	}
 	defer database.Disconnect()
 
+	// Print setup tokens for sites that don't have an admin yet
+	handlers.PrepareSetup(context.Background())
+
+	// Expired sessions are deleted by MongoDB
+	handlers.EnsureSessionIndexes(context.Background())
+
 	// Initialize router
 	router := mux.NewRouter()
 	
@@ -39,6 +48,9 @@ func main() {
 	// Apply tenant middleware second
 	router.Use(middleware.TenantMiddleware)
 
+	// Cookie-authenticated writes must come from the tenant's own pages
+	router.Use(middleware.CSRFMiddleware)
+
 	// API routes
 	api := router.PathPrefix("/api").Subrouter()
 
@@ -47,17 +59,41 @@ func main() {
 	api.HandleFunc("/health", handlers.HealthCheck).Methods("GET", "OPTIONS")
 	api.HandleFunc("/auth/login", handlers.Login).Methods("POST", "OPTIONS")
 	api.HandleFunc("/auth/register", handlers.Register).Methods("POST", "OPTIONS")
-	api.HandleFunc("/auth/check-admin", handlers.CheckAdmin).Methods("GET", "OPTIONS")
-	api.HandleFunc("/auth/create-admin", handlers.CreateAdmin).Methods("POST", "OPTIONS")
-	api.HandleFunc("/posts", handlers.GetPosts).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/login/2fa", handlers.VerifyTwoFactorLogin).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/login/2fa/passkey/begin", handlers.BeginPasskeySecondFactor).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/login/2fa/passkey/finish", handlers.FinishPasskeySecondFactor).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/passkeys/login/begin", handlers.BeginPasskeyLogin).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/passkeys/login/finish", handlers.FinishPasskeyLogin).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/refresh", handlers.RefreshSession).Methods("POST", "OPTIONS")
+	// Logout works with an expired access token too, from the refresh token
+	api.Handle("/auth/logout", middleware.OptionalAuthMiddleware(http.HandlerFunc(handlers.Logout))).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/csrf", handlers.GetCSRFToken).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/password-policy", handlers.GetPasswordPolicy).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/forgot-password", handlers.ForgotPassword).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/reset-password", handlers.ResetPassword).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/verify-email", handlers.VerifyEmail).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/resend-verification", handlers.ResendVerification).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/magic-link", handlers.RequestMagicLink).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/magic-link/verify", handlers.VerifyMagicLink).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/sso", handlers.GetSSOTicket).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/sso", handlers.RedeemSSOTicket).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/oidc/providers", handlers.GetOIDCProviders).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/oidc/{provider}/start", handlers.StartOIDCLogin).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/oidc/{provider}/callback", handlers.OIDCCallback).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/setup", handlers.GetSetupStatus).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/setup", handlers.CompleteSetup).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/invitation", handlers.GetInvitationByToken).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/invitation/accept", handlers.AcceptInvitation).Methods("POST", "OPTIONS")
+	api.Handle("/posts", middleware.OptionalAuthMiddleware(http.HandlerFunc(handlers.GetPosts))).Methods("GET", "OPTIONS")
 	api.HandleFunc("/posts/{slug}", handlers.GetPostBySlug).Methods("GET", "OPTIONS")
+	api.HandleFunc("/posts/{slug}/og-image", handlers.GetPostOGImage).Methods("GET", "OPTIONS")
 	api.HandleFunc("/categories", handlers.GetCategories).Methods("GET", "OPTIONS")
+	api.Handle("/categories/tree", middleware.OptionalAuthMiddleware(http.HandlerFunc(handlers.GetCategoryTree))).Methods("GET", "OPTIONS")
+	api.HandleFunc("/authors/{slug}", handlers.GetAuthor).Methods("GET", "OPTIONS")
 	
-	// Component data routes (consider protecting these in production)
+	// Component data routes; changing it needs the settings permission, below
 	api.HandleFunc("/component-data", handlers.GetComponentData).Methods("GET", "OPTIONS")
-	api.HandleFunc("/component-data", handlers.UpdateComponentData).Methods("PUT", "OPTIONS")
 	api.HandleFunc("/components", handlers.ListComponentData).Methods("GET", "OPTIONS")
-	api.HandleFunc("/reorder-components", handlers.ReorderComponents).Methods("POST", "OPTIONS")
 	
 	// Temporarily public for testing
 	api.HandleFunc("/social/test", handlers.TestSocialConnectionSimple).Methods("POST", "OPTIONS")
@@ -67,37 +103,134 @@ func main() {
 	protected.Use(middleware.AuthMiddleware)
 
 	// Auth routes
-	protected.HandleFunc("/auth/logout", handlers.Logout).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/auth/me", handlers.GetMe).Methods("GET", "OPTIONS")
-	protected.HandleFunc("/auth/change-password", handlers.ChangePassword).Methods("POST", "OPTIONS")
-
-	// Admin routes
-	protected.HandleFunc("/posts", handlers.CreatePost).Methods("POST")
-	protected.HandleFunc("/posts/id/{id}", handlers.GetPostByID).Methods("GET")
-	protected.HandleFunc("/posts/{id}", handlers.UpdatePost).Methods("PUT")
-	protected.HandleFunc("/posts/{id}", handlers.DeletePost).Methods("DELETE")
-	protected.HandleFunc("/categories", handlers.CreateCategory).Methods("POST")
-	protected.HandleFunc("/categories/{id}", handlers.UpdateCategory).Methods("PUT")
-	protected.HandleFunc("/categories/{id}", handlers.DeleteCategory).Methods("DELETE")
-	protected.HandleFunc("/upload", handlers.UploadFile).Methods("POST")
-
-	// User management
-	protected.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT") // Users can update their own profile
-	
+
+	// Account routes need a signed-in session; personal access tokens can't use them
+	account := protected.PathPrefix("").Subrouter()
+	account.Use(middleware.RequireSession)
+	account.HandleFunc("/auth/impersonation/stop", handlers.StopImpersonation).Methods("POST", "OPTIONS")
+
+	// Credentials and security settings stay out of reach while an admin impersonates the user
+	credentials := account.PathPrefix("").Subrouter()
+	credentials.Use(middleware.BlockImpersonation)
+	credentials.HandleFunc("/auth/change-password", handlers.ChangePassword).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/me/export", handlers.ExportMyData).Methods("GET", "OPTIONS")
+	credentials.HandleFunc("/auth/me", handlers.DeleteMyAccount).Methods("DELETE", "OPTIONS")
+	credentials.HandleFunc("/auth/me/deletion", handlers.CancelMyDeletion).Methods("DELETE", "OPTIONS")
+	credentials.HandleFunc("/auth/sites", handlers.GetMySites).Methods("GET", "OPTIONS")
+	credentials.HandleFunc("/auth/switch", handlers.SwitchSite).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/sessions", handlers.GetSessions).Methods("GET", "OPTIONS")
+	credentials.HandleFunc("/auth/sessions/{id}", handlers.RevokeSession).Methods("DELETE", "OPTIONS")
+	credentials.HandleFunc("/auth/passkeys", handlers.GetPasskeys).Methods("GET", "OPTIONS")
+	credentials.HandleFunc("/auth/passkeys/register/begin", handlers.BeginPasskeyRegistration).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/passkeys/register/finish", handlers.FinishPasskeyRegistration).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/passkeys/{id}", handlers.DeletePasskey).Methods("DELETE", "OPTIONS")
+	credentials.HandleFunc("/auth/2fa", handlers.GetTwoFactorStatus).Methods("GET", "OPTIONS")
+	credentials.HandleFunc("/auth/2fa/setup", handlers.SetupTwoFactor).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/2fa/enable", handlers.EnableTwoFactor).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/2fa/disable", handlers.DisableTwoFactor).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/2fa/recovery-codes", handlers.RegenerateRecoveryCodes).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/tokens", handlers.GetAPITokens).Methods("GET", "OPTIONS")
+	credentials.HandleFunc("/auth/tokens", handlers.CreateAPIToken).Methods("POST", "OPTIONS")
+	credentials.HandleFunc("/auth/tokens/{id}", handlers.RevokeAPIToken).Methods("DELETE", "OPTIONS")
+	credentials.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT") // Users can update their own profile
+	credentials.HandleFunc("/users/{id}/avatar", handlers.UploadAvatar).Methods("POST")
+	credentials.HandleFunc("/users/{id}/avatar", handlers.DeleteAvatar).Methods("DELETE")
+
+	// Content routes. Each group needs a permission from the user's role; authors can
+	// only change their own posts and uploads, which the handlers check.
+	drafts := protected.PathPrefix("").Subrouter()
+	drafts.Use(middleware.RequirePermission(models.PermViewDrafts))
+	drafts.HandleFunc("/posts/id/{id}", handlers.GetPostByID).Methods("GET")
+
+	writing := protected.PathPrefix("").Subrouter()
+	writing.Use(middleware.RequirePermission(models.PermWritePosts))
+	writing.HandleFunc("/posts", handlers.CreatePost).Methods("POST")
+	writing.HandleFunc("/posts/{id}", handlers.UpdatePost).Methods("PUT")
+	writing.HandleFunc("/posts/{id}", handlers.DeletePost).Methods("DELETE")
+
+	uploads := protected.PathPrefix("").Subrouter()
+	uploads.Use(middleware.RequirePermission(models.PermUploadFiles))
+	uploads.HandleFunc("/upload", handlers.UploadFile).Methods("POST")
+	uploads.HandleFunc("/uploads", handlers.GetUploads).Methods("GET")
+	uploads.HandleFunc("/uploads/{id}", handlers.DeleteUpload).Methods("DELETE")
+
+	categories := protected.PathPrefix("").Subrouter()
+	categories.Use(middleware.RequirePermission(models.PermManageCategories))
+	categories.HandleFunc("/categories", handlers.CreateCategory).Methods("POST")
+	categories.HandleFunc("/categories/{id}", handlers.UpdateCategory).Methods("PUT")
+	categories.HandleFunc("/categories/merge", handlers.MergeCategories).Methods("POST")
+	categories.HandleFunc("/categories/{id}", handlers.DeleteCategory).Methods("DELETE")
+
 	// Admin only user management
-	admin := protected.PathPrefix("/admin").Subrouter()
-	admin.Use(middleware.AdminMiddleware)
-	admin.HandleFunc("/users", handlers.GetUsers).Methods("GET")
-	admin.HandleFunc("/users/{id}", handlers.GetUserByID).Methods("GET")
-	admin.HandleFunc("/users", handlers.CreateUser).Methods("POST")
-	admin.HandleFunc("/users/{id}/approve", handlers.ApproveUser).Methods("PUT")
-	admin.HandleFunc("/users/{id}/role", handlers.UpdateUserRole).Methods("PUT")
-	admin.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
+	adminUsers := protected.PathPrefix("/admin").Subrouter()
+	adminUsers.Use(middleware.RequirePermission(models.PermManageUsers))
+	adminUsers.HandleFunc("/users", handlers.GetUsers).Methods("GET")
+	adminUsers.HandleFunc("/users/pending-count", handlers.GetPendingUserCount).Methods("GET")
+	adminUsers.HandleFunc("/users/{id}", handlers.GetUserByID).Methods("GET")
+	adminUsers.HandleFunc("/users", handlers.CreateUser).Methods("POST")
+	adminUsers.HandleFunc("/users/{id}/approve", handlers.ApproveUser).Methods("PUT")
+	adminUsers.HandleFunc("/users/{id}/role", handlers.UpdateUserRole).Methods("PUT")
+	adminUsers.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
+	adminUsers.HandleFunc("/users/{id}/unlock", handlers.UnlockUser).Methods("POST")
+	adminUsers.HandleFunc("/users/{id}/impersonate", handlers.StartImpersonation).Methods("POST")
+	adminUsers.HandleFunc("/users/{id}/export", handlers.ExportUserData).Methods("GET")
+	adminUsers.HandleFunc("/users/{id}/deletion", handlers.ScheduleUserDeletion).Methods("POST")
+	adminUsers.HandleFunc("/users/{id}/deletion", handlers.CancelUserDeletion).Methods("DELETE")
+	adminUsers.HandleFunc("/security/events", handlers.GetSecurityEvents).Methods("GET")
+	adminUsers.HandleFunc("/invitations", handlers.GetInvitations).Methods("GET")
+	adminUsers.HandleFunc("/invitations", handlers.CreateInvitation).Methods("POST")
+	adminUsers.HandleFunc("/invitations/{id}/resend", handlers.ResendInvitation).Methods("POST")
+	adminUsers.HandleFunc("/invitations/{id}", handlers.RevokeInvitation).Methods("DELETE")
+
+	// Admin only site settings
+	adminSettings := protected.PathPrefix("/admin").Subrouter()
+	adminSettings.Use(middleware.RequirePermission(models.PermManageSettings))
+	adminSettings.HandleFunc("/link-report", handlers.GetLinkReport).Methods("GET")
+	adminSettings.HandleFunc("/link-report", handlers.RunLinkCheck).Methods("POST")
+	adminSettings.HandleFunc("/security/rotate-key", handlers.RotateSigningKey).Methods("POST")
+	adminSettings.HandleFunc("/audit", handlers.GetAuditLog).Methods("GET")
+
+	// Site content edited from the dev mode overlay
+	siteContent := protected.PathPrefix("").Subrouter()
+	siteContent.Use(middleware.RequirePermission(models.PermManageSettings))
+	siteContent.HandleFunc("/component-data", handlers.UpdateComponentData).Methods("PUT")
+	siteContent.HandleFunc("/reorder-components", handlers.ReorderComponents).Methods("POST")
 
 	// Social media routes (temporarily disabled for protected routes)
 	// protected.HandleFunc("/social/test", handlers.TestSocialConnection).Methods("POST")
-	protected.HandleFunc("/social/credentials", handlers.SaveSocialCredentials).Methods("POST")
-	protected.HandleFunc("/social/publish", handlers.PublishToSocialMedia).Methods("POST")
+	// They use the user's own third-party credentials, so not while impersonating them
+	social := protected.PathPrefix("").Subrouter()
+	social.Use(middleware.BlockImpersonation)
+	social.Use(middleware.RequirePermission(models.PermPublishSocial))
+	social.HandleFunc("/social/credentials", handlers.SaveSocialCredentials).Methods("POST")
+	social.HandleFunc("/social/publish", handlers.PublishToSocialMedia).Methods("POST")
+
+	// Check post links in the background (LINK_CHECK_INTERVAL=0 disables it)
+	linkCheckInterval := 24 * time.Hour
+	if value := os.Getenv("LINK_CHECK_INTERVAL"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			linkCheckInterval = parsed
+		} else {
+			log.Printf("Invalid LINK_CHECK_INTERVAL %q, using %s", value, linkCheckInterval)
+		}
+	}
+	if linkCheckInterval > 0 {
+		handlers.StartLinkCheckJob(linkCheckInterval)
+	}
+
+	// Erase accounts whose deletion grace period has ended (ACCOUNT_PURGE_INTERVAL=0 disables it)
+	purgeInterval := time.Hour
+	if value := os.Getenv("ACCOUNT_PURGE_INTERVAL"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			purgeInterval = parsed
+		} else {
+			log.Printf("Invalid ACCOUNT_PURGE_INTERVAL %q, using %s", value, purgeInterval)
+		}
+	}
+	if purgeInterval > 0 {
+		handlers.StartAccountPurgeJob(purgeInterval)
+	}
 
 	// Serve uploaded files
 	router.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads"))))
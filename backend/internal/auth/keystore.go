@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SigningKey is one HMAC secret used to sign a tenant's tokens. Retired keys stay
+// around long enough to verify tokens issued before a rotation.
+type SigningKey struct {
+	ID        string     `bson:"_id" json:"kid"`
+	TenantID  string     `bson:"tenantId" json:"tenantId"`
+	Secret    string     `bson:"secret" json:"-"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	RetiredAt *time.Time `bson:"retiredAt,omitempty" json:"retiredAt,omitempty"`
+}
+
+// KeyStore persists tenant signing keys
+type KeyStore interface {
+	// Keys returns every non-expired key for the tenant, newest first
+	Keys(ctx context.Context, tenantID string) ([]SigningKey, error)
+	// Add stores a new active key and retires the tenant's previous keys
+	Add(ctx context.Context, key SigningKey) error
+	// Prune deletes keys retired before the cutoff
+	Prune(ctx context.Context, cutoff time.Time) error
+}
+
+// MongoKeyStore keeps keys in the tenant_secrets collection of the main database,
+// so they survive restarts and are shared by every backend instance
+type MongoKeyStore struct{}
+
+func (MongoKeyStore) collection() (*mongo.Collection, error) {
+	db := database.GetDB()
+	if db == nil {
+		return nil, errors.New("database unavailable")
+	}
+	return db.Collection("tenant_secrets"), nil
+}
+
+func (s MongoKeyStore) Keys(ctx context.Context, tenantID string) ([]SigningKey, error) {
+	collection, err := s.collection()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, bson.M{"tenantId": tenantID})
+	if err != nil {
+		return nil, err
+	}
+	var keys []SigningKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	sortKeysNewestFirst(keys)
+	return keys, nil
+}
+
+func (s MongoKeyStore) Add(ctx context.Context, key SigningKey) error {
+	collection, err := s.collection()
+	if err != nil {
+		return err
+	}
+	if _, err := collection.InsertOne(ctx, key); err != nil {
+		return err
+	}
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"tenantId": key.TenantID, "_id": bson.M{"$ne": key.ID}, "retiredAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"retiredAt": key.CreatedAt}},
+	)
+	return err
+}
+
+func (s MongoKeyStore) Prune(ctx context.Context, cutoff time.Time) error {
+	collection, err := s.collection()
+	if err != nil {
+		return err
+	}
+	_, err = collection.DeleteMany(ctx, bson.M{"retiredAt": bson.M{"$lt": cutoff}})
+	return err
+}
+
+// MemoryKeyStore keeps keys in memory; intended for tests
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys []SigningKey
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{}
+}
+
+func (s *MemoryKeyStore) Keys(ctx context.Context, tenantID string) ([]SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []SigningKey
+	for _, k := range s.keys {
+		if k.TenantID == tenantID {
+			keys = append(keys, k)
+		}
+	}
+	sortKeysNewestFirst(keys)
+	return keys, nil
+}
+
+func (s *MemoryKeyStore) Add(ctx context.Context, key SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.keys {
+		if s.keys[i].TenantID == key.TenantID && s.keys[i].RetiredAt == nil {
+			retired := key.CreatedAt
+			s.keys[i].RetiredAt = &retired
+		}
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *MemoryKeyStore) Prune(ctx context.Context, cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.keys[:0]
+	for _, k := range s.keys {
+		if k.RetiredAt == nil || !k.RetiredAt.Before(cutoff) {
+			kept = append(kept, k)
+		}
+	}
+	s.keys = kept
+	return nil
+}
+
+func sortKeysNewestFirst(keys []SigningKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+}
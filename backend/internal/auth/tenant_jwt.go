@@ -1,80 +1,219 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// TenantSecrets manages per-tenant JWT secrets
-var tenantSecrets = make(map[string]string)
-
-// GetTenantSecret returns the JWT secret for a specific tenant
-func GetTenantSecret(tenantID string) string {
-	// Check if we have a cached secret
-	if secret, exists := tenantSecrets[tenantID]; exists {
-		return secret
-	}
-	
-	// Try to load from environment (TENANT_<ID>_JWT_SECRET)
-	envKey := "TENANT_" + tenantID + "_JWT_SECRET"
-	if secret := os.Getenv(envKey); secret != "" {
-		tenantSecrets[tenantID] = secret
-		return secret
-	}
-	
-	// Generate a new secret for this tenant
-	secret := generateSecret()
-	tenantSecrets[tenantID] = secret
-	
-	// TODO: Save to database or secure storage
-	return secret
+// RetiredKeyGrace is how long a rotated-out key still verifies tokens. It must be
+// longer than the access token lifetime.
+var RetiredKeyGrace = 24 * time.Hour
+
+// keyCacheTTL bounds how long other instances take to notice a rotation
+const keyCacheTTL = time.Minute
+
+// ErrEnvSigningKey is returned when rotating the key of a tenant that signs with a
+// secret from its environment, which rotation can't replace
+var ErrEnvSigningKey = errors.New("the tenant signs tokens with TENANT_<ID>_JWT_SECRET")
+
+var (
+	keyStore KeyStore = MongoKeyStore{}
+
+	keyCache   = make(map[string]cachedKeys)
+	keyCacheMu sync.Mutex
+)
+
+type cachedKeys struct {
+	keys     []SigningKey
+	loadedAt time.Time
+}
+
+// SetKeyStore replaces the signing key store, e.g. with a MemoryKeyStore in tests
+func SetKeyStore(store KeyStore) {
+	keyCacheMu.Lock()
+	defer keyCacheMu.Unlock()
+	keyStore = store
+	keyCache = make(map[string]cachedKeys)
+}
+
+// tenantKeys returns the tenant's usable keys, newest first. A tenant with
+// TENANT_<ID>_JWT_SECRET set always uses that secret; otherwise the first call
+// creates and persists a key.
+func tenantKeys(ctx context.Context, tenantID string, reload bool) ([]SigningKey, error) {
+	if secret := envSecret(tenantID); secret != "" {
+		return []SigningKey{{ID: "env", TenantID: tenantID, Secret: secret}}, nil
+	}
+
+	keyCacheMu.Lock()
+	defer keyCacheMu.Unlock()
+
+	if cached, ok := keyCache[tenantID]; ok && !reload && time.Since(cached.loadedAt) < keyCacheTTL {
+		return cached.keys, nil
+	}
+
+	keys, err := keyStore.Keys(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	usable := keys[:0]
+	for _, k := range keys {
+		if k.RetiredAt == nil || time.Since(*k.RetiredAt) < RetiredKeyGrace {
+			usable = append(usable, k)
+		}
+	}
+
+	if len(usable) == 0 || usable[0].RetiredAt != nil {
+		key, err := newSigningKey(tenantID)
+		if err != nil {
+			return nil, err
+		}
+		if err := keyStore.Add(ctx, key); err != nil {
+			return nil, fmt.Errorf("failed to store signing key: %v", err)
+		}
+		usable = append([]SigningKey{key}, usable...)
+	}
+
+	keyCache[tenantID] = cachedKeys{keys: usable, loadedAt: time.Now()}
+	return usable, nil
+}
+
+// envSecret returns the tenant's TENANT_<ID>_JWT_SECRET, if set
+func envSecret(tenantID string) string {
+	for _, envKey := range []string{
+		"TENANT_" + tenantID + "_JWT_SECRET",
+		"TENANT_" + strings.ToUpper(strings.ReplaceAll(tenantID, "-", "_")) + "_JWT_SECRET",
+	} {
+		if secret := os.Getenv(envKey); secret != "" {
+			return secret
+		}
+	}
+	return ""
+}
+
+func newSigningKey(tenantID string) (SigningKey, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{
+		ID:        tenantID + "-" + base64.RawURLEncoding.EncodeToString(id),
+		TenantID:  tenantID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}, nil
 }
 
 // generateSecret creates a cryptographically secure random secret
-func generateSecret() string {
+func generateSecret() (string, error) {
 	b := make([]byte, 32)
-	rand.Read(b)
-	return base64.URLEncoding.EncodeToString(b)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// RotateTenantKey creates a new signing key for the tenant. Tokens signed with the
+// previous key keep working for RetiredKeyGrace. It returns ErrEnvSigningKey for a
+// tenant whose key is set in its environment.
+func RotateTenantKey(ctx context.Context, tenantID string) (string, error) {
+	if envSecret(tenantID) != "" {
+		return "", ErrEnvSigningKey
+	}
+	key, err := newSigningKey(tenantID)
+	if err != nil {
+		return "", err
+	}
+	if err := keyStore.Add(ctx, key); err != nil {
+		return "", err
+	}
+	if err := keyStore.Prune(ctx, time.Now().Add(-RetiredKeyGrace)); err != nil {
+		return "", err
+	}
+
+	keyCacheMu.Lock()
+	delete(keyCache, tenantID)
+	keyCacheMu.Unlock()
+	return key.ID, nil
 }
 
-// GenerateTenantToken creates a JWT token for a specific tenant
-func GenerateTenantToken(tenantID string, userID string, email string, role string) (string, error) {
-	secret := GetTenantSecret(tenantID)
-	
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"email":   email,
-		"role":    role,
-		"tenant":  tenantID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-		"iat":     time.Now().Unix(),
-	})
-	
-	return token.SignedString([]byte(secret))
+// GenerateTenantToken signs the claims with the tenant's current key. The tenant,
+// iat and exp claims are set here and the key ID goes in the kid header.
+func GenerateTenantToken(tenantID string, claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	keys, err := tenantKeys(context.Background(), tenantID, false)
+	if err != nil {
+		return "", err
+	}
+	key := keys[0]
+
+	now := time.Now()
+	signed := jwt.MapClaims{}
+	for k, v := range claims {
+		signed[k] = v
+	}
+	signed["tenant"] = tenantID
+	signed["iat"] = now.Unix()
+	signed["exp"] = now.Add(ttl).Unix()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, signed)
+	token.Header["kid"] = key.ID
+	return token.SignedString([]byte(key.Secret))
 }
 
-// ValidateTenantToken validates a JWT token for a specific tenant
-func ValidateTenantToken(tokenString string, tenantID string) (*jwt.MapClaims, error) {
-	secret := GetTenantSecret(tenantID)
-	
+// ValidateTenantToken verifies a token against the tenant's keys and checks that it
+// was issued for that tenant
+func ValidateTenantToken(tokenString string, tenantID string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key ID")
+		}
+		secret, err := findKey(tenantID, kid)
+		if err != nil {
+			return nil, err
+		}
 		return []byte(secret), nil
-	})
-	
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return nil, err
 	}
-	
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// Verify tenant matches
-		if claimTenant, ok := claims["tenant"].(string); ok && claimTenant == tenantID {
-			return &claims, nil
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	if claimTenant, _ := claims["tenant"].(string); claimTenant != tenantID {
+		return nil, errors.New("token was issued for a different tenant")
+	}
+	return claims, nil
+}
+
+// findKey looks a kid up in the tenant's keys, reloading once in case another
+// instance rotated recently
+func findKey(tenantID, kid string) (string, error) {
+	for _, reload := range []bool{false, true} {
+		keys, err := tenantKeys(context.Background(), tenantID, reload)
+		if err != nil {
+			return "", err
+		}
+		for _, k := range keys {
+			if k.ID == kid {
+				return k.Secret, nil
+			}
 		}
 	}
-	
-	return nil, jwt.ErrSignatureInvalid
-}
\ No newline at end of file
+	return "", errors.New("unknown signing key")
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTenantTokensAreBoundToTheirTenant(t *testing.T) {
+	SetKeyStore(NewMemoryKeyStore())
+
+	token, err := GenerateTenantToken("codersinflow", jwt.MapClaims{"userId": "abc"}, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateTenantToken: %v", err)
+	}
+
+	claims, err := ValidateTenantToken(token, "codersinflow")
+	if err != nil {
+		t.Fatalf("token rejected by its own tenant: %v", err)
+	}
+	if claims["userId"] != "abc" || claims["tenant"] != "codersinflow" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	if _, err := ValidateTenantToken(token, "darkflows"); err == nil {
+		t.Error("token minted for codersinflow was accepted by darkflows")
+	}
+}
+
+func TestRotationKeepsRecentTokensValid(t *testing.T) {
+	SetKeyStore(NewMemoryKeyStore())
+	ctx := context.Background()
+
+	before, err := GenerateTenantToken("darkflows", jwt.MapClaims{"userId": "abc"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, err := RotateTenantKey(ctx, "darkflows")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := GenerateTenantToken("darkflows", jwt.MapClaims{"userId": "abc"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(after, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != kid {
+		t.Errorf("new token signed with %v, want rotated key %s", parsed.Header["kid"], kid)
+	}
+
+	for name, token := range map[string]string{"pre-rotation": before, "post-rotation": after} {
+		if _, err := ValidateTenantToken(token, "darkflows"); err != nil {
+			t.Errorf("%s token rejected: %v", name, err)
+		}
+	}
+
+	// Once the grace period has passed the old key no longer verifies
+	grace := RetiredKeyGrace
+	RetiredKeyGrace = 0
+	defer func() { RetiredKeyGrace = grace }()
+	SetKeyStore(keyStore) // Clear the cache
+	if _, err := ValidateTenantToken(before, "darkflows"); err == nil {
+		t.Error("token signed with a retired key was accepted after the grace period")
+	}
+}
+
+func TestTokensWithoutKeyIDAreRejected(t *testing.T) {
+	SetKeyStore(NewMemoryKeyStore())
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"tenant": "welcome", "userId": "abc"})
+	token, err := unsigned.SignedString([]byte("default-dev-secret-change-in-production"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateTenantToken(token, "welcome"); err == nil {
+		t.Error("token signed with the old shared secret was accepted")
+	}
+}
+
+func TestRotationRefusedForEnvironmentSecrets(t *testing.T) {
+	SetKeyStore(NewMemoryKeyStore())
+	t.Setenv("TENANT_PRESTON_JWT_SECRET", "from-the-environment")
+
+	if _, err := RotateTenantKey(context.Background(), "preston"); !errors.Is(err, ErrEnvSigningKey) {
+		t.Fatalf("RotateTenantKey = %v, want ErrEnvSigningKey", err)
+	}
+	token, err := GenerateTenantToken("preston", jwt.MapClaims{"userId": "abc"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{}); parsed.Header["kid"] != "env" {
+		t.Errorf("token signed with %v, want the environment secret", parsed.Header["kid"])
+	}
+}
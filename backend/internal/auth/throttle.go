@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coders-website/backend/internal/passwords"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BackoffPolicy decides how long a client must wait after repeated failed sign-ins.
+// The first FreeAttempts failures cost nothing; after that the wait doubles with each
+// failure, from BaseDelay up to MaxDelay. LockoutAfter failures lock the key for
+// LockoutDuration. Failures older than Window are forgotten.
+type BackoffPolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int // 0 disables lockout
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// Backoff policies for sign-in attempts. Accounts are protected more tightly than IPs,
+// since many users can share an address behind a NAT.
+var (
+	AccountLoginPolicy = BackoffPolicy{
+		FreeAttempts:    5,
+		BaseDelay:       time.Second,
+		MaxDelay:        15 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 30 * time.Minute,
+		Window:          24 * time.Hour,
+	}
+	IPLoginPolicy = BackoffPolicy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+)
+
+// Delay returns how long to wait after the given number of consecutive failures
+func (p BackoffPolicy) Delay(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < over; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Locks reports whether this many failures should lock the key
+func (p BackoffPolicy) Locks(failures int) bool {
+	return p.LockoutAfter > 0 && failures >= p.LockoutAfter
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CompareDummyPassword spends as long as a real password check, so a sign-in for an
+// unknown user takes as long as one for a known user with a wrong password. The dummy
+// hash uses the same cost as new password hashes.
+func CompareDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), passwords.Cost())
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	p := BackoffPolicy{FreeAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second, LockoutAfter: 10}
+	cases := map[int]time.Duration{
+		0:   0,
+		5:   0,
+		6:   time.Second,
+		7:   2 * time.Second,
+		8:   4 * time.Second,
+		9:   8 * time.Second,
+		10:  10 * time.Second,
+		500: 10 * time.Second,
+	}
+	for failures, want := range cases {
+		if got := p.Delay(failures); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+	if p.Locks(9) || !p.Locks(10) {
+		t.Error("lockout should start at LockoutAfter failures")
+	}
+	if (BackoffPolicy{}).Locks(100) {
+		t.Error("a zero LockoutAfter should never lock")
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors for SHA-1, truncated to six digits
+func TestTOTPCodeMatchesRFCVectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range vectors {
+		got, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("TOTPCode at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTPRejectsReuseAndDrift(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+
+	step, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok {
+		t.Fatal("current code rejected")
+	}
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Error("code accepted twice")
+	}
+
+	// One period of drift is tolerated, two are not
+	if _, ok := ValidateTOTP(secret, code, now.Add(totpPeriod*time.Second), 0); !ok {
+		t.Error("code from the previous period rejected")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(3*totpPeriod*time.Second), 0); ok {
+		t.Error("stale code accepted")
+	}
+}
+
+func TestRecoveryCodeHashIgnoresFormatting(t *testing.T) {
+	codes, err := NewRecoveryCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := codes[0]
+	typed := " " + strings.ToUpper(strings.Replace(code, "-", "", 1)) + " "
+	if HashRecoveryCode(typed) != HashRecoveryCode(code) {
+		t.Errorf("recovery code %q did not match when typed as %q", code, typed)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("CodersInFlow", "admin@example.com", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/CodersInFlow:admin@example.com?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+}
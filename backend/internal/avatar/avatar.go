@@ -0,0 +1,87 @@
+// Package avatar turns uploaded pictures into square profile pictures at fixed sizes
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif" // Registered so uploads can be GIFs, JPEGs and WebPs too
+	_ "image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// maxPixels stops a small file that decodes to a huge image from exhausting memory
+const maxPixels = 40_000_000
+
+// ErrUnsupported is returned for files that aren't a JPEG, PNG, GIF or WebP image
+var ErrUnsupported = errors.New("avatar: not a JPEG, PNG, GIF or WebP image")
+
+// ErrTooLarge is returned for images with more than maxPixels pixels
+var ErrTooLarge = errors.New("avatar: image dimensions are too large")
+
+// Decode reads an uploaded image, checking its dimensions before decoding it
+func Decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	return img, nil
+}
+
+// Square crops the image to a square. With a crop rectangle, the square is the largest
+// one inside it (clipped to the image), centred; without one, the largest square
+// centred in the image.
+func Square(img image.Image, crop *image.Rectangle) image.Image {
+	area := img.Bounds()
+	if crop != nil {
+		if clipped := crop.Add(area.Min).Intersect(area); !clipped.Empty() {
+			area = clipped
+		}
+	}
+	side := area.Dx()
+	if area.Dy() < side {
+		side = area.Dy()
+	}
+	x := area.Min.X + (area.Dx()-side)/2
+	y := area.Min.Y + (area.Dy()-side)/2
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Pt(x, y), draw.Src)
+	return square
+}
+
+// Render scales a square image to size x size pixels and writes it as a PNG
+func Render(w io.Writer, square image.Image, size int) error {
+	scaled := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), square, square.Bounds(), draw.Src, nil)
+	return png.Encode(w, scaled)
+}
+
+// Process decodes an uploaded image, crops it square and renders it at each size,
+// returning the PNGs by size
+func Process(data []byte, crop *image.Rectangle, sizes []int) (map[int][]byte, error) {
+	img, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	square := Square(img, crop)
+	rendered := make(map[int][]byte, len(sizes))
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		if err := Render(&buf, square, size); err != nil {
+			return nil, err
+		}
+		rendered[size] = buf.Bytes()
+	}
+	return rendered, nil
+}
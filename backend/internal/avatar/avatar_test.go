@@ -0,0 +1,72 @@
+package avatar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testImage is w x h pixels, red in the left half and blue in the right
+func testImage(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessRendersSquareSizes(t *testing.T) {
+	rendered, err := Process(testImage(t, 300, 200), nil, []int{64, 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{64, 128} {
+		img, err := png.Decode(bytes.NewReader(rendered[size]))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("size %d rendered as %dx%d", size, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestSquareCropsToRectangle(t *testing.T) {
+	img, err := Decode(testImage(t, 300, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	centred := Square(img, nil)
+	if b := centred.Bounds(); b.Dx() != 200 || b.Dy() != 200 {
+		t.Fatalf("centred square is %dx%d", b.Dx(), b.Dy())
+	}
+
+	// A crop of the right-hand third is all blue
+	crop := image.Rect(200, 0, 300, 200)
+	right := Square(img, &crop)
+	if b := right.Bounds(); b.Dx() != 100 || b.Dy() != 100 {
+		t.Fatalf("cropped square is %dx%d", b.Dx(), b.Dy())
+	}
+	if r, _, b, _ := right.At(0, 0).RGBA(); r != 0 || b == 0 {
+		t.Errorf("cropped square should be blue, got %v", right.At(0, 0))
+	}
+}
+
+func TestDecodeRejectsNonImages(t *testing.T) {
+	if _, err := Decode([]byte("not an image")); err != ErrUnsupported {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+}
@@ -70,4 +70,19 @@ func GetDB() *mongo.Database {
 
 func GetCollection(name string) *mongo.Collection {
 	return database.Collection(name)
-}
\ No newline at end of file
+}
+
+// UseClient points the package at an already connected client and database, instead of
+// connecting with Connect, and checks the new deployment for transaction support again.
+// Tests use it with a mock deployment.
+func UseClient(c *mongo.Client, dbName string) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	client = c
+	database = c.Database(dbName)
+	tenantDBs = make(map[string]*mongo.Database)
+
+	transactionsMutex.Lock()
+	transactionsSupported = nil
+	transactionsMutex.Unlock()
+}
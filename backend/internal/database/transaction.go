@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	transactionsSupported *bool
+	transactionsMutex     sync.Mutex
+)
+
+// SupportsTransactions reports whether the connected deployment is a replica set or
+// sharded cluster. Standalone servers reject multi-document transactions. The answer
+// is remembered once the server has given one; a failed check is retried next time.
+func SupportsTransactions(ctx context.Context) bool {
+	transactionsMutex.Lock()
+	defer transactionsMutex.Unlock()
+
+	if transactionsSupported != nil {
+		return *transactionsSupported
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		log.Printf("Failed to check MongoDB transaction support: %v", err)
+		return false
+	}
+	supported := hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !supported {
+		log.Println("MongoDB deployment does not support transactions, multi-document writes won't be atomic")
+	}
+	transactionsSupported = &supported
+	return supported
+}
+
+// WithTransaction runs fn inside a multi-document transaction when the deployment
+// supports it, and reports whether it did. On a standalone server fn runs directly and
+// a failure part way leaves the earlier writes in place, so callers should order their
+// writes to keep data consistent and tell the user the change wasn't atomic.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) (atomic bool, err error) {
+	if !SupportsTransactions(ctx) {
+		return false, fn(ctx)
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return true, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return true, err
+}
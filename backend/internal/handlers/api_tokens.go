@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lifetimes of personal access tokens, in days
+const (
+	defaultAPITokenDays = 30
+	maxAPITokenDays     = 365
+)
+
+type CreateAPITokenRequest struct {
+	Name          string              `json:"name" validate:"required,max=100"`
+	Scopes        []models.Permission `json:"scopes" validate:"required"`
+	ExpiresInDays int                 `json:"expiresInDays"`
+}
+
+// CreateAPIToken issues a personal access token. The token is only returned here; the
+// server keeps its hash.
+func CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+
+	// A token can't do more than its owner
+	scopes := []models.Permission{}
+	seen := map[models.Permission]bool{}
+	for _, scope := range req.Scopes {
+		if !models.ValidPermission(scope) {
+			http.Error(w, "Unknown scope: "+string(scope), http.StatusBadRequest)
+			return
+		}
+		if !user.Can(scope) {
+			http.Error(w, "Your role doesn't allow the scope "+string(scope), http.StatusForbidden)
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultAPITokenDays
+	}
+	if days < 1 || days > maxAPITokenDays {
+		http.Error(w, "Tokens must expire within a year", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := auth.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	token := models.APITokenPrefix + secret
+
+	now := time.Now()
+	record := models.APIToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Name:      req.Name,
+		Hint:      token[:len(models.APITokenPrefix)+4],
+		TokenHash: auth.HashToken(token),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, days),
+	}
+	if _, err := database.GetCollectionFromRequest(r, "api_tokens").InsertOne(context.Background(), record); err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":    token,
+		"apiToken": record,
+		"message":  "Copy this token now. It won't be shown again.",
+	})
+}
+
+// GetAPITokens lists the current user's tokens, including expired and revoked ones
+func GetAPITokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := database.GetCollectionFromRequest(r, "api_tokens").Find(context.Background(),
+		bson.M{"userId": user.ID},
+		options.Find().SetSort(bson.M{"createdAt": -1}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch tokens", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	tokens := []models.APIToken{}
+	if err := cursor.All(context.Background(), &tokens); err != nil {
+		http.Error(w, "Failed to decode tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeAPIToken stops one of the current user's tokens from working
+func RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := database.GetCollectionFromRequest(r, "api_tokens").UpdateOne(context.Background(),
+		bson.M{"_id": id, "userId": user.ID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Token revoked",
+	})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// auditExportLimit caps how many entries a CSV export holds
+const auditExportLimit = 10000
+
+// recordAudit appends an entry to the site's audit log. before and after are the
+// target's state around the change; either may be nil.
+func recordAudit(r *http.Request, action, targetType, targetID string, before, after interface{}) {
+	entry := models.AuditEntry{
+		ID:         primitive.NewObjectID(),
+		IP:         middleware.GetClientIP(r),
+		UserAgent:  r.UserAgent(),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    models.DiffFields(before, after),
+		CreatedAt:  time.Now(),
+	}
+	if actor, ok := middleware.GetUserFromContext(r); ok {
+		entry.ActorID = &actor.ID
+		entry.ActorEmail = actor.Email
+		entry.ActorRole = actor.Role
+	}
+	if admin, ok := middleware.GetImpersonator(r); ok {
+		entry.ImpersonatorID = &admin.ID
+		entry.ImpersonatorEmail = admin.Email
+	}
+	if _, err := database.GetCollectionFromRequest(r, "audit_log").InsertOne(context.Background(), entry); err != nil {
+		log.Printf("Failed to record audit entry %s on %s %s: %v", action, targetType, targetID, err)
+	}
+}
+
+// GetAuditLog lists audit entries, newest first. Filter with ?actorId=, ?actorEmail=,
+// ?impersonatorId=, ?action=, ?targetType=, ?targetId=, ?from= and ?to= (RFC 3339 or
+// YYYY-MM-DD); page with ?page= and ?limit= (default 100). ?format=csv downloads the
+// matching entries.
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := bson.M{}
+	if actorHex := params.Get("actorId"); actorHex != "" {
+		actorID, err := primitive.ObjectIDFromHex(actorHex)
+		if err != nil {
+			http.Error(w, "Invalid actor ID", http.StatusBadRequest)
+			return
+		}
+		query["actorId"] = actorID
+	}
+	if impersonatorHex := params.Get("impersonatorId"); impersonatorHex != "" {
+		impersonatorID, err := primitive.ObjectIDFromHex(impersonatorHex)
+		if err != nil {
+			http.Error(w, "Invalid impersonator ID", http.StatusBadRequest)
+			return
+		}
+		query["impersonatorId"] = impersonatorID
+	}
+	if email := params.Get("actorEmail"); email != "" {
+		query["actorEmail"] = strings.ToLower(strings.TrimSpace(email))
+	}
+	for _, field := range []string{"action", "targetType", "targetId"} {
+		if value := params.Get(field); value != "" {
+			query[field] = value
+		}
+	}
+
+	createdAt := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value, param == "to")
+		if err != nil {
+			http.Error(w, "Invalid "+param+" date", http.StatusBadRequest)
+			return
+		}
+		createdAt[op] = t
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	exportCSV := params.Get("format") == "csv"
+	findOptions := options.Find().SetSort(bson.M{"createdAt": -1})
+	if exportCSV {
+		findOptions.SetLimit(auditExportLimit)
+	} else {
+		limit := int64(100)
+		if value, err := strconv.ParseInt(params.Get("limit"), 10, 64); err == nil && value > 0 && value <= 1000 {
+			limit = value
+		}
+		page := int64(1)
+		if value, err := strconv.ParseInt(params.Get("page"), 10, 64); err == nil && value > 0 {
+			page = value
+		}
+		findOptions.SetLimit(limit).SetSkip((page - 1) * limit)
+	}
+
+	collection := database.GetCollectionFromRequest(r, "audit_log")
+	cursor, err := collection.Find(context.Background(), query, findOptions)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	entries := []models.AuditEntry{}
+	if err := cursor.All(context.Background(), &entries); err != nil {
+		http.Error(w, "Failed to decode audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if exportCSV {
+		writeAuditCSV(w, entries)
+		return
+	}
+
+	total, err := collection.CountDocuments(context.Background(), query)
+	if err != nil {
+		http.Error(w, "Failed to count audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+	})
+}
+
+// parseTimeParam accepts a timestamp or a date. A bare date used as an upper bound
+// covers the whole day.
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+func writeAuditCSV(w http.ResponseWriter, entries []models.AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-log-`+time.Now().Format("2006-01-02")+`.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "actor_email", "actor_id", "actor_role", "impersonator_email", "ip", "action", "target_type", "target_id", "changes"})
+	for _, entry := range entries {
+		actorID := ""
+		if entry.ActorID != nil {
+			actorID = entry.ActorID.Hex()
+		}
+		changes, _ := json.Marshal(entry.Changes)
+		out.Write([]string{
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			csvSafe(entry.ActorEmail),
+			actorID,
+			entry.ActorRole,
+			csvSafe(entry.ImpersonatorEmail),
+			entry.IP,
+			entry.Action,
+			entry.TargetType,
+			csvSafe(entry.TargetID),
+			csvSafe(string(changes)),
+		})
+	}
+	out.Flush()
+}
+
+// csvSafe stops spreadsheet apps from running user-supplied text as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -3,14 +3,15 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"log"
 	"net/http"
-	"os"
 	"time"
 
+	"github.com/coders-website/backend/internal/auth"
 	"github.com/coders-website/backend/internal/database"
 	"github.com/coders-website/backend/internal/middleware"
 	"github.com/coders-website/backend/internal/models"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/coders-website/backend/internal/passwords"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -40,100 +41,70 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Auto-create admin user if it doesn't exist (only for admin@codersinflow.com)
-	if req.Email == "admin@codersinflow.com" && req.Password == "c0dersinflow" {
-		var existingAdmin models.User
-		err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"email": "admin@codersinflow.com"}).Decode(&existingAdmin)
-		if err == mongo.ErrNoDocuments {
-			// Admin doesn't exist, create it
-			newAdmin := models.User{
-				ID:        primitive.NewObjectID(),
-				Email:     "admin@codersinflow.com",
-				Name:      "Admin",
-				Password:  "c0dersinflow",
-				Role:      "admin",
-				Approved:  true,
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-			}
-			
-			// Hash the password
-			if err := newAdmin.HashPassword(); err != nil {
-				http.Error(w, "Failed to create admin user", http.StatusInternalServerError)
-				return
-			}
-			
-			// Insert the admin user
-			if _, err := database.GetCollectionFromRequest(r, "users").InsertOne(context.Background(), newAdmin); err != nil {
-				http.Error(w, "Failed to create admin user", http.StatusInternalServerError)
-				return
-			}
-		}
+	// Repeated failures from an address or for an account have to wait
+	if loginThrottled(w, r, req.Email) {
+		return
 	}
 
+	backfillEmailVerification(r)
+
 	// Find user by email (normal login flow)
 	var user models.User
 	err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "User not found", http.StatusNotFound)
-			return
-		} else if err.Error() == "context deadline exceeded" || err.Error() == "server selection timeout" {
+	if err != nil && err != mongo.ErrNoDocuments {
+		if err.Error() == "context deadline exceeded" || err.Error() == "server selection timeout" {
 			http.Error(w, "Database connection timeout - please try again", http.StatusServiceUnavailable)
 			return
-		} else {
-			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
-			return
 		}
+		log.Printf("Login lookup failed: %v", err)
+		http.Error(w, "Database error - please try again", http.StatusInternalServerError)
+		return
+	}
+
+	// Users linked to a shared identity sign in with its password
+	var hash string
+	if err == nil {
+		hash = passwordHash(&user)
 	}
 
-	// Check password
-	if err := user.ComparePassword(req.Password); err != nil {
+	// Unknown users and wrong passwords get the same response in the same time, so it
+	// can't be used to find out who has an account
+	if err == mongo.ErrNoDocuments || hash == "" {
+		auth.CompareDummyPassword(req.Password)
+		var userID *primitive.ObjectID
+		if err == nil {
+			userID = &user.ID
+		}
+		recordLoginFailure(r, req.Email, userID)
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
-
-	// Check if user is approved
-	if !user.Approved {
-		http.Error(w, "Account pending approval - please contact admin", http.StatusForbidden)
+	if err := passwords.Compare(hash, req.Password); err != nil {
+		recordLoginFailure(r, req.Email, &user.ID)
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
+	clearLoginFailures(r, req.Email)
+	recordSecurityEvent(r, models.EventLoginSucceeded, req.Email, &user.ID, "password")
 
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": user.ID.Hex(),
-		"email":  user.Email,
-		"role":   user.Role,
-		"exp":    time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-	})
-
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "default-dev-secret-change-in-production"
+	// Self-registered users confirm their address before they can sign in
+	if !user.EmailVerified {
+		http.Error(w, "Please verify your email address - check your inbox for the link", http.StatusForbidden)
+		return
 	}
-	tokenString, err := token.SignedString([]byte(jwtSecret))
-	if err != nil {
-		http.Error(w, "Token generation failed: "+err.Error(), http.StatusInternalServerError)
+
+	// Check if user is approved
+	if !user.Approved {
+		http.Error(w, "Account pending approval - please contact admin", http.StatusForbidden)
 		return
 	}
 
-	// Set cookie with SameSite=Lax (works since frontend and backend are on same domain)
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth-token",
-		Value:    tokenString,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   os.Getenv("NODE_ENV") == "production", // HTTPS in production
-		SameSite: http.SameSiteLaxMode, // Works for same-site requests
-		MaxAge:   60 * 60 * 24 * 7, // 7 days
-	})
+	// Only accounts that can sign in have their record rewritten
+	upgradePasswordHash(r, &user, hash, req.Password)
+	linkIdentity(r, &user, req.Password)
 
-	// Return user info
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"user": user,
-		"token": tokenString,
-	})
+	// Issue a session, or a 2FA challenge if the user has a second factor
+	completeLogin(w, r, &user)
 }
 
 func Register(w http.ResponseWriter, r *http.Request) {
@@ -148,6 +119,9 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "All fields are required", http.StatusBadRequest)
 		return
 	}
+	if !checkPasswordPolicy(w, r, req.Password, req.Name, req.Email) {
+		return
+	}
 
 	// Check database connectivity
 	if database.GetDB() == nil {
@@ -176,8 +150,9 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		Name:      req.Name,
 		Email:     req.Email,
 		Password:  req.Password,
-		Role:      "user",
+		Role:      models.RoleAuthor,
 		Approved:  false, // Require admin approval
+		// EmailVerified stays false until the emailed link is followed
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -188,16 +163,21 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert user
+	// Insert user. On a site sharing identities they stay local until their first sign-in
+	// after verifying their email, so nobody can claim an address they don't own.
 	_, err = database.GetCollectionFromRequest(r, "users").InsertOne(context.Background(), user)
 	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
+	if err := sendVerificationEmail(r, &user); err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Registration successful. Please wait for admin approval.",
+		"message": "Registration successful. Check your email to confirm your address, then wait for admin approval.",
 		"user": map[string]interface{}{
 			"id":    user.ID,
 			"name":  user.Name,
@@ -206,17 +186,29 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Logout revokes the session and clears the auth cookies. It finds the session from the
+// access token, or from the refresh token once the access token has expired.
 func Logout(w http.ResponseWriter, r *http.Request) {
-	// Clear cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth-token",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   os.Getenv("NODE_ENV") == "production",
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   -1,
-	})
+	// Logging out while impersonating signs the admin out too
+	if _, ok := middleware.GetImpersonator(r); ok {
+		if session, err := endImpersonation(r, "logout"); err != nil {
+			log.Printf("Failed to end impersonation: %v", err)
+		} else if _, err := revokeSessions(r, bson.M{"_id": *session.ParentSessionID}, "logout"); err != nil {
+			log.Printf("Failed to revoke session %s: %v", session.ParentSessionID.Hex(), err)
+		}
+	} else if sessionID := middleware.GetSessionID(r); !sessionID.IsZero() {
+		// Revoke the server-side session so the refresh token can't be used again
+		if _, err := revokeSessions(r, bson.M{"_id": sessionID}, "logout"); err != nil {
+			log.Printf("Failed to revoke session %s: %v", sessionID.Hex(), err)
+		}
+	} else if sessionID, secret, ok := presentedRefreshToken(r); ok {
+		// The access token has expired; the refresh token still identifies the session
+		if _, err := revokeSessions(r, bson.M{"_id": sessionID, "tokenHash": auth.HashToken(secret)}, "logout"); err != nil {
+			log.Printf("Failed to revoke session %s: %v", sessionID.Hex(), err)
+		}
+	}
+
+	clearAuthCookies(w)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -224,15 +216,23 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetMe returns the signed-in user. The third-party secrets in Social are left out:
+// this is called on every page load, with API tokens and while impersonating.
 func GetMe(w http.ResponseWriter, r *http.Request) {
 	user, ok := middleware.GetUserFromContext(r)
 	if !ok {
 		http.Error(w, "User not found", http.StatusUnauthorized)
 		return
 	}
+	me := *user
+	me.Social = nil
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(struct {
+		*models.User
+		Permissions   []models.Permission `json:"permissions"`
+		Impersonation *impersonationInfo  `json:"impersonation,omitempty"`
+	}{&me, user.Permissions(), currentImpersonation(r)})
 }
 
 type ChangePasswordRequest struct {
@@ -262,124 +262,35 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check current password
-	if err := fullUser.ComparePassword(req.CurrentPassword); err != nil {
+	if err := checkPassword(&fullUser, req.CurrentPassword); err != nil {
 		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
 		return
 	}
+	if !checkPasswordPolicy(w, r, req.NewPassword, fullUser.Name, fullUser.Email) {
+		return
+	}
 
 	// Hash new password
-	fullUser.Password = req.NewPassword
-	if err := fullUser.HashPassword(); err != nil {
+	hashedPassword, err := passwords.Hash(req.NewPassword)
+	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
-	hashedPassword := fullUser.Password
-
-	// Update password in database
-	_, err = database.GetCollectionFromRequest(r, "users").UpdateOne(
-		context.Background(),
-		bson.M{"_id": user.ID},
-		bson.M{"$set": bson.M{
-			"password": hashedPassword,
-			"updatedAt": time.Now(),
-		}},
-	)
-	if err != nil {
+
+	// Update password in database, or on the shared identity
+	if err := storePassword(r, &fullUser, hashedPassword, bson.M{}); err != nil {
 		http.Error(w, "Failed to update password", http.StatusInternalServerError)
 		return
 	}
 
+	// Log out every other device, on every site sharing the password
+	if _, err := revokeSessions(r, bson.M{"userId": user.ID, "_id": bson.M{"$ne": middleware.GetSessionID(r)}}, "password changed"); err != nil {
+		log.Printf("Failed to revoke sessions after password change: %v", err)
+	}
+	revokeIdentitySessions(r, &fullUser, "password changed")
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Password changed successfully",
 	})
 }
-
-func CheckAdmin(w http.ResponseWriter, r *http.Request) {
-	// Check if admin user exists
-	var user models.User
-	err := database.GetCollectionFromRequest(r, "users").FindOne(
-		context.Background(),
-		bson.M{"email": "admin@codersinflow.com"},
-	).Decode(&user)
-	
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "Admin not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"exists": true,
-		"email": user.Email,
-	})
-}
-
-func CreateAdmin(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		Name     string `json:"name"`
-	}
-	
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-	
-	// Check if admin already exists
-	var existingUser models.User
-	err := database.GetCollectionFromRequest(r, "users").FindOne(
-		context.Background(),
-		bson.M{"email": req.Email},
-	).Decode(&existingUser)
-	
-	if err == nil {
-		// User already exists
-		http.Error(w, "User already exists", http.StatusConflict)
-		return
-	}
-	
-	if err != mongo.ErrNoDocuments {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		return
-	}
-	
-	// Create the admin user
-	newAdmin := models.User{
-		ID:        primitive.NewObjectID(),
-		Email:     req.Email,
-		Name:      req.Name,
-		Password:  req.Password,
-		Role:      "admin",
-		Approved:  true,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	
-	// Hash the password
-	if err := newAdmin.HashPassword(); err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
-		return
-	}
-	
-	// Insert the admin user
-	if _, err := database.GetCollectionFromRequest(r, "users").InsertOne(context.Background(), newAdmin); err != nil {
-		http.Error(w, "Failed to create admin user", http.StatusInternalServerError)
-		return
-	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "Admin user created successfully",
-		"user": map[string]string{
-			"id":    newAdmin.ID.Hex(),
-			"email": newAdmin.Email,
-			"name":  newAdmin.Name,
-		},
-	})
-}
\ No newline at end of file
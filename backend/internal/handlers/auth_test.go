@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestGetMeLeavesOutSocialCredentials(t *testing.T) {
+	runWithMockDB(t, "me", func(mt *mtest.T) {
+		user := testUser(models.RoleAuthor)
+		user.Social = &models.SocialCredentials{Devto: &models.DevtoCredentials{APIKey: "devto-secret"}}
+
+		r := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+		r.AddCookie(signIn(mt, user))
+		w := serve(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if body := w.Body.String(); strings.Contains(body, "devto-secret") || strings.Contains(body, `"social"`) {
+			t.Errorf("social credentials returned: %s", body)
+		}
+		if !strings.Contains(w.Body.String(), `"permissions"`) {
+			t.Errorf("permissions missing: %s", w.Body)
+		}
+	})
+}
+
+func TestRefusedLoginLeavesTheUserAlone(t *testing.T) {
+	withSharedIdentity(t)
+	backfilledDatabases.Store("coders_website", true)
+	// An old, cheaper hash would be upgraded on a successful sign-in
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, change := range map[string]func(u *models.User){
+		"unverified": func(u *models.User) { u.EmailVerified = false },
+		"unapproved": func(u *models.User) { u.Approved = false },
+	} {
+		runWithMockDB(t, name, func(mt *mtest.T) {
+			user := testUser(models.RoleAuthor)
+			user.Password = string(hash)
+			change(&user)
+			queueFound(mt) // No failures from this address
+			queueFound(mt) // or for this account
+			queueFound(mt, user)
+			queueWrite(mt, 0) // Clear failures
+			queueWrite(mt, 1) // Security event
+
+			w := serve(httptest.NewRequest(http.MethodPost, "/api/auth/login",
+				strings.NewReader(`{"email":"`+user.Email+`","password":"password"}`)))
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want 403: %s", w.Code, w.Body)
+			}
+			for _, event := range mt.GetAllStartedEvents() {
+				collection, _ := event.Command.Lookup(event.CommandName).StringValueOK()
+				if event.CommandName == "update" || collection == "identities" {
+					t.Errorf("refused sign-in ran %s on %s", event.CommandName, collection)
+				}
+			}
+		})
+	}
+}
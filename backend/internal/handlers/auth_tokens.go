@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/mailer"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errInvalidAuthToken = errors.New("invalid or expired token")
+
+// createAuthToken issues a single-use token and stores its hash. Earlier unused tokens
+// for the same user and purpose stop working, so only the latest email's link is valid.
+func createAuthToken(r *http.Request, purpose string, userID primitive.ObjectID, email string, ttl time.Duration) (string, error) {
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	collection := database.GetCollectionFromRequest(r, "auth_tokens")
+	filter := bson.M{"purpose": purpose, "usedAt": bson.M{"$exists": false}}
+	if !userID.IsZero() {
+		filter["userId"] = userID
+	} else {
+		filter["email"] = email
+	}
+	if _, err := collection.DeleteMany(context.Background(), filter); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = collection.InsertOne(context.Background(), models.AuthToken{
+		ID:        primitive.NewObjectID(),
+		Purpose:   purpose,
+		UserID:    userID,
+		Email:     email,
+		TokenHash: auth.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeAuthToken marks a token as used and returns it. A token can only be consumed
+// once, even by concurrent requests.
+func consumeAuthToken(r *http.Request, purpose, token string) (*models.AuthToken, error) {
+	if token == "" {
+		return nil, errInvalidAuthToken
+	}
+	now := time.Now()
+	var record models.AuthToken
+	err := database.GetCollectionFromRequest(r, "auth_tokens").FindOneAndUpdate(
+		context.Background(),
+		bson.M{
+			"tokenHash": auth.HashToken(token),
+			"purpose":   purpose,
+			"usedAt":    bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&record)
+	if err != nil {
+		return nil, errInvalidAuthToken
+	}
+	return &record, nil
+}
+
+// releaseAuthToken makes a consumed token usable again, for when the request that
+// consumed it fails for a reason the user can fix
+func releaseAuthToken(r *http.Request, token *models.AuthToken) {
+	if _, err := database.GetCollectionFromRequest(r, "auth_tokens").UpdateOne(context.Background(),
+		bson.M{"_id": token.ID},
+		bson.M{"$unset": bson.M{"usedAt": ""}},
+	); err != nil {
+		log.Printf("Failed to release %s token %s: %v", token.Purpose, token.ID.Hex(), err)
+	}
+}
+
+// mailTenant describes the request's site for the mailer
+func mailTenant(r *http.Request) mailer.Tenant {
+	config := middleware.GetTenantConfig(r)
+	return mailer.Tenant{
+		ID:     middleware.GetTenantID(r),
+		Name:   config.Name,
+		Domain: middleware.GetTenantDomain(r),
+		From:   config.MailFrom,
+	}
+}
+
+// siteURL returns the public base URL of the request's site, for links in emails
+func siteURL(r *http.Request) string {
+	domain := middleware.GetTenantDomain(r)
+	if os.Getenv("NODE_ENV") != "production" && (domain == "localhost" || strings.HasSuffix(domain, ".localhost")) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			return strings.TrimSuffix(origin, "/")
+		}
+		return "http://" + domain
+	}
+	return "https://" + domain
+}
+
+// sendMailAsync sends an email without holding up the response, so response times
+// don't reveal whether an address has an account
+func sendMailAsync(r *http.Request, to, template string, data map[string]interface{}) {
+	tenant := mailTenant(r)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := mailer.Default().Send(ctx, tenant, to, template, data); err != nil {
+			log.Printf("[%s] failed to send %s email: %v", tenant.ID, template, err)
+		}
+	}()
+}
+
+// formatTTL renders a token lifetime for email copy, e.g. "1 hour" or "2 days"
+func formatTTL(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d >= 48*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/time.Minute), "minute")
+	}
+}
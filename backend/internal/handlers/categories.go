@@ -3,15 +3,20 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
 	"github.com/coders-website/backend/internal/models"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func GetCategories(w http.ResponseWriter, r *http.Request) {
@@ -23,7 +28,11 @@ func GetCategories(w http.ResponseWriter, r *http.Request) {
 		query["type"] = categoryType
 	}
 
-	cursor, err := database.GetCollectionFromRequest(r, "categories").Find(context.Background(), query)
+	cursor, err := database.GetCollectionFromRequest(r, "categories").Find(
+		context.Background(),
+		query,
+		options.Find().SetSort(bson.D{{Key: "order", Value: 1}, {Key: "name", Value: 1}}),
+	)
 	if err != nil {
 		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
 		return
@@ -66,6 +75,13 @@ func GetCategories(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Attach live published-post counts
+	if counts, err := countPostsByCategory(r, bson.M{"published": true}); err == nil {
+		for i := range categories {
+			categories[i].PostCount = counts[categories[i].ID]
+		}
+	}
+
 	// Ensure we always return an array, never null
 	if categories == nil {
 		categories = []models.Category{}
@@ -75,6 +91,35 @@ func GetCategories(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(categories)
 }
 
+// GetCategoryTree returns categories nested under their parents with post counts
+func GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	query := bson.M{}
+	categoryType := r.URL.Query().Get("type")
+	if categoryType != "" {
+		query["type"] = categoryType
+	}
+
+	categories, err := loadCategories(r, query)
+	if err != nil {
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+
+	// Only count drafts for users who can see them
+	postQuery := bson.M{}
+	if user, authenticated := middleware.GetUserFromContext(r); !authenticated || !user.Can(models.PermViewDrafts) {
+		postQuery["published"] = true
+	}
+	counts, err := countPostsByCategory(r, postQuery)
+	if err != nil {
+		http.Error(w, "Failed to count posts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.BuildCategoryTree(categories, counts))
+}
+
 func CreateCategory(w http.ResponseWriter, r *http.Request) {
 	var category models.Category
 	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
@@ -86,16 +131,64 @@ func CreateCategory(w http.ResponseWriter, r *http.Request) {
 	category.CreatedAt = time.Now()
 	category.GenerateSlug()
 
+	if err := category.Validate(); err != nil {
+		http.Error(w, "Invalid category: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(category.FeaturedPostIDs) > 0 {
+		hexIDs := make([]string, len(category.FeaturedPostIDs))
+		for i, postID := range category.FeaturedPostIDs {
+			hexIDs[i] = postID.Hex()
+		}
+		featured, err := parseFeaturedPosts(r, hexIDs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category.FeaturedPostIDs = featured
+	}
+
+	if category.ParentID != nil {
+		var parent models.Category
+		err := database.GetCollectionFromRequest(r, "categories").FindOne(context.Background(), bson.M{"_id": *category.ParentID}).Decode(&parent)
+		if err != nil {
+			http.Error(w, "Parent category not found", http.StatusBadRequest)
+			return
+		}
+		if parent.Type != category.Type {
+			http.Error(w, "Parent category must have the same type", http.StatusBadRequest)
+			return
+		}
+	}
+
 	_, err := database.GetCollectionFromRequest(r, "categories").InsertOne(context.Background(), category)
 	if err != nil {
 		http.Error(w, "Failed to create category", http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, models.AuditCategoryCreate, "category", category.ID.Hex(), nil, category)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(category)
 }
 
+// UpdateCategoryRequest lists the fields an update may change. Omitted fields are left as they are.
+type UpdateCategoryRequest struct {
+	Name            *string         `json:"name"`
+	Slug            *string         `json:"slug"`
+	Type            *string         `json:"type"`
+	ParentID        json.RawMessage `json:"parentId"` // null or "" moves the category to the root
+	Order           *int            `json:"order"`
+	Description     *string         `json:"description"`
+	CoverImage      *string         `json:"coverImage"`
+	SEOTitle        *string         `json:"seoTitle"`
+	MetaDescription *string         `json:"metaDescription"`
+	Color           *string         `json:"color"`
+	Icon            *string         `json:"icon"`
+	FeaturedPostIDs *[]string       `json:"featuredPostIds"`
+}
+
 func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := primitive.ObjectIDFromHex(vars["id"])
@@ -104,16 +197,136 @@ func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var updateData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+	// Unknown fields are ignored rather than written through to the document
+	var req UpdateCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	categories, err := loadCategories(r, bson.M{})
+	if err != nil {
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+	index := indexCategories(categories)
+	category, exists := index[id]
+	if !exists {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	// Apply the requested changes to a copy, validate it, then persist only what changed
+	set := bson.M{}
+	unset := bson.M{}
+	setString := func(field *string, target *string, key string) {
+		if field == nil {
+			return
+		}
+		*target = strings.TrimSpace(*field)
+		if *target == "" {
+			unset[key] = ""
+		} else {
+			set[key] = *target
+		}
+	}
+
+	if req.Name != nil {
+		category.Name = strings.TrimSpace(*req.Name)
+		set["name"] = category.Name
+	}
+	if req.Slug != nil {
+		category.Slug = strings.TrimSpace(*req.Slug)
+		set["slug"] = category.Slug
+	}
+	if req.Type != nil && *req.Type != category.Type {
+		if category.ParentID != nil || len(models.CategoryDescendants(categories, id)) > 0 {
+			http.Error(w, "Cannot change the type of a category with a parent or subcategories", http.StatusBadRequest)
+			return
+		}
+		category.Type = *req.Type
+		set["type"] = category.Type
+	}
+	if req.Order != nil {
+		category.Order = *req.Order
+		set["order"] = category.Order
+	}
+	setString(req.Description, &category.Description, "description")
+	setString(req.CoverImage, &category.CoverImage, "coverImage")
+	setString(req.SEOTitle, &category.SEOTitle, "seoTitle")
+	setString(req.MetaDescription, &category.MetaDescription, "metaDescription")
+	setString(req.Color, &category.Color, "color")
+	setString(req.Icon, &category.Icon, "icon")
+
+	if req.FeaturedPostIDs != nil {
+		featured, err := parseFeaturedPosts(r, *req.FeaturedPostIDs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		category.FeaturedPostIDs = featured
+		if len(featured) == 0 {
+			unset["featuredPostIds"] = ""
+		} else {
+			set["featuredPostIds"] = featured
+		}
+	}
+
+	// Reparenting
+	if len(req.ParentID) > 0 {
+		var parentHex *string
+		if err := json.Unmarshal(req.ParentID, &parentHex); err != nil {
+			http.Error(w, "Invalid parent category ID", http.StatusBadRequest)
+			return
+		}
+		if parentHex == nil || *parentHex == "" {
+			category.ParentID = nil
+			unset["parentId"] = ""
+		} else {
+			parentID, err := primitive.ObjectIDFromHex(*parentHex)
+			if err != nil {
+				http.Error(w, "Invalid parent category ID", http.StatusBadRequest)
+				return
+			}
+			parent, exists := index[parentID]
+			if !exists {
+				http.Error(w, "Parent category not found", http.StatusBadRequest)
+				return
+			}
+			if category.Type != parent.Type {
+				http.Error(w, "Parent category must have the same type", http.StatusBadRequest)
+				return
+			}
+			if models.CreatesCategoryCycle(index, id, parentID) {
+				http.Error(w, "Category cannot be moved under itself or one of its descendants", http.StatusBadRequest)
+				return
+			}
+			category.ParentID = &parentID
+			set["parentId"] = parentID
+		}
+	}
+
+	if err := category.Validate(); err != nil {
+		http.Error(w, "Invalid category: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
 	result, err := database.GetCollectionFromRequest(r, "categories").UpdateOne(
 		context.Background(),
 		bson.M{"_id": id},
-		bson.M{"$set": updateData},
+		update,
 	)
 
 	if err != nil {
@@ -125,13 +338,49 @@ func UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Category not found", http.StatusNotFound)
 		return
 	}
+	recordAudit(r, models.AuditCategoryUpdate, "category", id.Hex(), index[id], category)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Category updated successfully",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Category updated successfully",
+		"category": category,
 	})
 }
 
+// parseFeaturedPosts converts featured post IDs and checks that each post exists
+func parseFeaturedPosts(r *http.Request, hexIDs []string) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0, len(hexIDs))
+	seen := make(map[primitive.ObjectID]bool)
+	for _, hex := range hexIDs {
+		postID, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid featured post ID: %s", hex)
+		}
+		if !seen[postID] {
+			seen[postID] = true
+			ids = append(ids, postID)
+		}
+	}
+	if len(ids) > models.MaxFeaturedPosts {
+		return nil, fmt.Errorf("at most %d featured posts are allowed", models.MaxFeaturedPosts)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	count, err := database.GetCollectionFromRequest(r, "posts").CountDocuments(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, errors.New("failed to check featured posts")
+	}
+	if count != int64(len(ids)) {
+		return nil, errors.New("one or more featured posts do not exist")
+	}
+	return ids, nil
+}
+
+// DeleteCategory removes a category. When posts still use it, ?reassignTo=<id> moves
+// them to another category first; without it the request is rejected with 409.
+// Subcategories are moved up to the deleted category's parent.
 func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := primitive.ObjectIDFromHex(vars["id"])
@@ -140,30 +389,306 @@ func DeleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if category is in use
-	count, err := database.GetCollectionFromRequest(r, "posts").CountDocuments(context.Background(), bson.M{"category": id})
+	categories, err := loadCategories(r, bson.M{})
+	if err != nil {
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+	index := indexCategories(categories)
+	category, exists := index[id]
+	if !exists {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	// Check if category is in use (category is stored as either hex string or ObjectID)
+	count, err := database.GetCollectionFromRequest(r, "posts").CountDocuments(
+		context.Background(),
+		bson.M{"category": bson.M{"$in": categoryMatchValues(id)}},
+	)
 	if err != nil {
 		http.Error(w, "Failed to check category usage", http.StatusInternalServerError)
 		return
 	}
-	if count > 0 {
-		http.Error(w, "Category is in use", http.StatusConflict)
+
+	var target *models.Category
+	if reassignTo := r.URL.Query().Get("reassignTo"); reassignTo != "" {
+		targetID, err := primitive.ObjectIDFromHex(reassignTo)
+		if err != nil {
+			http.Error(w, "Invalid reassignTo category ID", http.StatusBadRequest)
+			return
+		}
+		t, ok := index[targetID]
+		if !ok {
+			http.Error(w, "Target category not found", http.StatusBadRequest)
+			return
+		}
+		if t.ID == id {
+			http.Error(w, "Cannot reassign posts to the category being deleted", http.StatusBadRequest)
+			return
+		}
+		if t.Type != category.Type {
+			http.Error(w, "Target category must have the same type", http.StatusBadRequest)
+			return
+		}
+		target = &t
+	}
+
+	if count > 0 && target == nil {
+		http.Error(w, fmt.Sprintf("Category is in use by %d posts; pass reassignTo to move them", count), http.StatusConflict)
 		return
 	}
 
-	result, err := database.GetCollectionFromRequest(r, "categories").DeleteOne(context.Background(), bson.M{"_id": id})
+	var moved int64
+	atomic, err := database.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if target != nil {
+			if moved, err = reassignCategoryPosts(ctx, r, []primitive.ObjectID{id}, target.ID); err != nil {
+				return err
+			}
+		}
+		if err := reparentChildren(ctx, r, []primitive.ObjectID{id}, category.ParentID); err != nil {
+			return err
+		}
+		_, err := database.GetCollectionFromRequest(r, "categories").DeleteOne(ctx, bson.M{"_id": id})
+		return err
+	})
 	if err != nil {
-		http.Error(w, "Failed to delete category", http.StatusInternalServerError)
+		http.Error(w, "Failed to delete category"+partialWritesNote(atomic), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, models.AuditCategoryDelete, "category", id.Hex(), category, nil)
 
-	if result.DeletedCount == 0 {
-		http.Error(w, "Category not found", http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Category deleted successfully",
+		"movedPosts": moved,
+		"atomic":     atomic,
+	})
+}
+
+type MergeCategoriesRequest struct {
+	SourceIDs []string `json:"sourceIds"`
+	TargetID  string   `json:"targetId"`
+}
+
+// MergeCategories moves every post and subcategory from the source categories into
+// the target category and then deletes the sources.
+func MergeCategories(w http.ResponseWriter, r *http.Request) {
+	var req MergeCategoriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	targetID, err := primitive.ObjectIDFromHex(req.TargetID)
+	if err != nil {
+		http.Error(w, "Invalid target category ID", http.StatusBadRequest)
+		return
+	}
+	if len(req.SourceIDs) == 0 {
+		http.Error(w, "At least one source category is required", http.StatusBadRequest)
 		return
 	}
 
+	categories, err := loadCategories(r, bson.M{})
+	if err != nil {
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+	index := indexCategories(categories)
+	target, ok := index[targetID]
+	if !ok {
+		http.Error(w, "Target category not found", http.StatusNotFound)
+		return
+	}
+
+	sources := make([]primitive.ObjectID, 0, len(req.SourceIDs))
+	isSource := make(map[primitive.ObjectID]bool)
+	for _, hex := range req.SourceIDs {
+		sourceID, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			http.Error(w, "Invalid source category ID: "+hex, http.StatusBadRequest)
+			return
+		}
+		source, ok := index[sourceID]
+		if !ok {
+			http.Error(w, "Source category not found: "+hex, http.StatusNotFound)
+			return
+		}
+		if sourceID == targetID {
+			http.Error(w, "Target category cannot also be a source", http.StatusBadRequest)
+			return
+		}
+		if source.Type != target.Type {
+			http.Error(w, "Source and target categories must have the same type", http.StatusBadRequest)
+			return
+		}
+		if !isSource[sourceID] {
+			isSource[sourceID] = true
+			sources = append(sources, sourceID)
+		}
+	}
+
+	// If the target sits below a source, lift it to the nearest surviving ancestor
+	targetParent := target.ParentID
+	for targetParent != nil && isSource[*targetParent] {
+		targetParent = index[*targetParent].ParentID
+	}
+
+	var moved int64
+	atomic, err := database.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if moved, err = reassignCategoryPosts(ctx, r, sources, targetID); err != nil {
+			return err
+		}
+		if !sameParent(target.ParentID, targetParent) {
+			update := bson.M{"$unset": bson.M{"parentId": ""}}
+			if targetParent != nil {
+				update = bson.M{"$set": bson.M{"parentId": *targetParent}}
+			}
+			if _, err := database.GetCollectionFromRequest(r, "categories").UpdateOne(ctx, bson.M{"_id": targetID}, update); err != nil {
+				return err
+			}
+		}
+		if err := reparentChildren(ctx, r, sources, &targetID); err != nil {
+			return err
+		}
+		_, err := database.GetCollectionFromRequest(r, "categories").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": sources}})
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Failed to merge categories"+partialWritesNote(atomic), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, models.AuditCategoryMerge, "category", targetID.Hex(),
+		map[string]interface{}{"sourceIds": sources, "parentId": target.ParentID},
+		map[string]interface{}{"parentId": targetParent, "movedPosts": moved},
+	)
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Category deleted successfully",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Categories merged successfully",
+		"movedPosts": moved,
+		"target":     target,
+		"atomic":     atomic,
+	})
+}
+
+// partialWritesNote warns that a failed change may have been partly applied, when the
+// database couldn't run it in a transaction
+func partialWritesNote(atomic bool) string {
+	if atomic {
+		return ""
+	}
+	return " - this database doesn't support transactions, so some of the changes may have been applied"
+}
+
+// reassignCategoryPosts points every post in the given categories at the target,
+// writing the category back as an ObjectID whichever form it was stored in
+func reassignCategoryPosts(ctx context.Context, r *http.Request, from []primitive.ObjectID, to primitive.ObjectID) (int64, error) {
+	result, err := database.GetCollectionFromRequest(r, "posts").UpdateMany(
+		ctx,
+		bson.M{"category": bson.M{"$in": categoryMatchValues(from...)}},
+		bson.M{"$set": bson.M{"category": to, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// reparentChildren moves the direct children of the given categories under newParent,
+// or to the root when newParent is nil. The parents themselves are left untouched.
+func reparentChildren(ctx context.Context, r *http.Request, parents []primitive.ObjectID, newParent *primitive.ObjectID) error {
+	filter := bson.M{
+		"parentId": bson.M{"$in": parents},
+		"_id":      bson.M{"$nin": parents},
+	}
+	update := bson.M{"$unset": bson.M{"parentId": ""}}
+	if newParent != nil {
+		filter["_id"] = bson.M{"$nin": append([]primitive.ObjectID{*newParent}, parents...)}
+		update = bson.M{"$set": bson.M{"parentId": *newParent}}
+	}
+	_, err := database.GetCollectionFromRequest(r, "categories").UpdateMany(ctx, filter, update)
+	return err
+}
+
+func sameParent(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// loadCategories fetches all categories matching the query for the request's tenant
+func loadCategories(r *http.Request, query bson.M) ([]models.Category, error) {
+	cursor, err := database.GetCollectionFromRequest(r, "categories").Find(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var categories []models.Category
+	if err := cursor.All(context.Background(), &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func indexCategories(categories []models.Category) map[primitive.ObjectID]models.Category {
+	index := make(map[primitive.ObjectID]models.Category, len(categories))
+	for _, c := range categories {
+		index[c.ID] = c
+	}
+	return index
+}
+
+// categoryMatchValues returns the values to match a post's category field against.
+// Older posts store the category as a hex string and newer ones as an ObjectID,
+// so both representations are included.
+func categoryMatchValues(ids ...primitive.ObjectID) []interface{} {
+	values := make([]interface{}, 0, len(ids)*2)
+	for _, id := range ids {
+		values = append(values, id, id.Hex())
+	}
+	return values
+}
+
+// categoryIDFromValue converts a stored category value (ObjectID or hex string) to an ObjectID
+func categoryIDFromValue(value interface{}) (primitive.ObjectID, bool) {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return v, true
+	case string:
+		id, err := primitive.ObjectIDFromHex(v)
+		return id, err == nil
+	}
+	return primitive.NilObjectID, false
+}
+
+// countPostsByCategory counts posts matching the query, grouped by category
+func countPostsByCategory(r *http.Request, query bson.M) (map[primitive.ObjectID]int64, error) {
+	cursor, err := database.GetCollectionFromRequest(r, "posts").Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$group", Value: bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}}},
 	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var groups []struct {
+		Category interface{} `bson:"_id"`
+		Count    int64       `bson:"count"`
+	}
+	if err := cursor.All(context.Background(), &groups); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[primitive.ObjectID]int64)
+	for _, g := range groups {
+		if id, ok := categoryIDFromValue(g.Category); ok {
+			counts[id] += g.Count
+		}
+	}
+	return counts, nil
 }
\ No newline at end of file
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCategoryTreeCountsDraftsForThoseWhoCanSeeThem(t *testing.T) {
+	parent := models.Category{ID: primitive.NewObjectID(), Name: "Go", Slug: "go", Type: "blog"}
+	child := models.Category{ID: primitive.NewObjectID(), Name: "Generics", Slug: "generics", Type: "blog", ParentID: &parent.ID}
+
+	for _, tc := range []struct {
+		name      string
+		signedIn  bool
+		published bool
+	}{
+		{"anonymous", false, true},
+		{"viewer", true, false},
+	} {
+		runWithMockDB(t, tc.name, func(mt *mtest.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/categories/tree", nil)
+			if tc.signedIn {
+				r.AddCookie(signIn(mt, testUser(models.RoleViewer)))
+			}
+			queueFound(mt, parent, child)
+			queueFound(mt, bson.M{"_id": child.ID.Hex(), "count": 2}) // Stored as a hex string
+
+			w := serve(r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			var tree []*models.CategoryNode
+			if err := json.NewDecoder(w.Body).Decode(&tree); err != nil {
+				t.Fatal(err)
+			}
+			if len(tree) != 1 || len(tree[0].Children) != 1 || tree[0].Children[0].PostCount != 2 {
+				t.Errorf("unexpected tree: %+v", tree)
+			}
+
+			var match bson.Raw
+			for _, event := range mt.GetAllStartedEvents() {
+				if event.CommandName == "aggregate" {
+					match = event.Command.Lookup("pipeline", "0", "$match").Document()
+				}
+			}
+			if _, err := match.LookupErr("published"); (err == nil) != tc.published {
+				t.Errorf("counted with %s, want only published posts: %v", match, tc.published)
+			}
+		})
+	}
+}
+
+func TestDeletingUsedCategoryNeedsReassignment(t *testing.T) {
+	runWithMockDB(t, "in use", func(mt *mtest.T) {
+		category := models.Category{ID: primitive.NewObjectID(), Name: "Go", Slug: "go", Type: "blog"}
+
+		r := httptest.NewRequest(http.MethodDelete, "/api/categories/"+category.ID.Hex(), nil)
+		r.AddCookie(signIn(mt, testUser(models.RoleEditor)))
+		queueFound(mt, category)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.posts", mtest.FirstBatch, bson.D{{Key: "n", Value: 3}}))
+
+		w := serve(r)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "3 posts") {
+			t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+		}
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "delete" || event.CommandName == "update" {
+				t.Errorf("category changed: %s", event.Command)
+			}
+		}
+	})
+}
+
+func TestMergeCategoriesMovesPostsAndChildren(t *testing.T) {
+	runWithMockDB(t, "merge", func(mt *mtest.T) {
+		target := models.Category{ID: primitive.NewObjectID(), Name: "Go", Slug: "go", Type: "blog"}
+		source := models.Category{ID: primitive.NewObjectID(), Name: "Golang", Slug: "golang", Type: "blog"}
+		child := models.Category{ID: primitive.NewObjectID(), Name: "Generics", Slug: "generics", Type: "blog", ParentID: &source.ID}
+
+		body := `{"targetId":"` + target.ID.Hex() + `","sourceIds":["` + source.ID.Hex() + `"]}`
+		r := httptest.NewRequest(http.MethodPost, "/api/categories/merge", strings.NewReader(body))
+		r.AddCookie(signIn(mt, testUser(models.RoleEditor)))
+		queueFound(mt, target, source, child)
+		mt.AddMockResponses(mtest.CreateSuccessResponse()) // hello: a standalone server
+		queueWrite(mt, 2)                                  // Posts
+		queueWrite(mt, 1)                                  // Subcategories
+		queueWrite(mt, 1)                                  // Sources
+		queueWrite(mt, 1)                                  // Audit entry
+
+		w := serve(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if !strings.Contains(w.Body.String(), `"movedPosts":2`) || !strings.Contains(w.Body.String(), `"atomic":false`) {
+			t.Errorf("unexpected response: %s", w.Body)
+		}
+
+		var updates []bson.Raw
+		var deleted bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			switch event.CommandName {
+			case "update":
+				updates = append(updates, event.Command)
+			case "delete":
+				deleted = event.Command
+			}
+		}
+		if len(updates) != 2 || deleted == nil {
+			t.Fatalf("got %d updates and delete %v", len(updates), deleted)
+		}
+
+		// Posts store their category as an ObjectID or, in older posts, a hex string
+		posts := updates[0].Lookup("updates", "0")
+		matched := posts.Document().Lookup("q", "category", "$in").String()
+		if !strings.Contains(matched, source.ID.Hex()) || !strings.Contains(matched, `"$oid"`) {
+			t.Errorf("posts matched by %s", matched)
+		}
+		if moved := posts.Document().Lookup("u", "$set", "category").ObjectID(); moved != target.ID {
+			t.Errorf("posts moved to %s, want %s", moved.Hex(), target.ID.Hex())
+		}
+		if parent := updates[1].Lookup("updates", "0", "u", "$set", "parentId").ObjectID(); parent != target.ID {
+			t.Errorf("subcategories moved under %s, want %s", parent.Hex(), target.ID.Hex())
+		}
+		if removed := deleted.Lookup("deletes", "0", "q", "_id", "$in", "0").ObjectID(); removed != source.ID {
+			t.Errorf("deleted %s, want the source %s", removed.Hex(), source.ID.Hex())
+		}
+	})
+}
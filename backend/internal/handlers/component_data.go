@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/coders-website/backend/internal/models"
 )
 
 // GetComponentData retrieves JSON data for a component
@@ -97,10 +99,12 @@ func UpdateComponentData(w http.ResponseWriter, r *http.Request) {
 	dataPath := filepath.Join("..", "astro-multi-tenant", "src", "sites", req.Site, "data", req.Path)
 
 	// Create backup of existing file (optional)
+	var before interface{}
 	if _, err := os.Stat(dataPath); err == nil {
 		backupPath := dataPath + ".backup"
 		if data, err := ioutil.ReadFile(dataPath); err == nil {
 			ioutil.WriteFile(backupPath, data, 0644)
+			json.Unmarshal(data, &before)
 		}
 	}
 
@@ -109,6 +113,7 @@ func UpdateComponentData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Error writing component data: %v", err), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, models.AuditComponentUpdate, "component_data", req.Site+"/"+req.Path, before, jsonData)
 
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
@@ -200,6 +205,12 @@ func ReorderComponents(w http.ResponseWriter, r *http.Request) {
 
 	// Construct file path
 	astroPath := filepath.Join("..", "astro-multi-tenant", "src", "sites", req.Site, "pages", pagePath)
+	auditReorder := func() {
+		recordAudit(r, models.AuditComponentReorder, "page", req.Site+"/"+pagePath,
+			map[string]interface{}{"components": []string{req.Component1.Name, req.Component2.Name}},
+			map[string]interface{}{"components": []string{req.Component2.Name, req.Component1.Name}},
+		)
+	}
 
 	// Read the file
 	content, err := ioutil.ReadFile(astroPath)
@@ -273,6 +284,7 @@ func ReorderComponents(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("Error writing updated page: %v", err), http.StatusInternalServerError)
 			return
 		}
+		auditReorder()
 		
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -386,6 +398,7 @@ func ReorderComponents(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, fmt.Sprintf("Error writing updated page: %v", err), http.StatusInternalServerError)
 				return
 			}
+			auditReorder()
 			
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/mailer"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// emailVerificationTTL is how long a verification link works
+const emailVerificationTTL = 48 * time.Hour
+
+// sendVerificationEmail emails the user a link to confirm their address
+func sendVerificationEmail(r *http.Request, user *models.User) error {
+	token, err := createAuthToken(r, models.TokenPurposeVerifyEmail, user.ID, user.Email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	sendMailAsync(r, user.Email, mailer.TemplateVerifyEmail, map[string]interface{}{
+		"Name":      user.Name,
+		"Link":      siteURL(r) + "/blog/editor/verify-email?token=" + url.QueryEscape(token),
+		"ExpiresIn": formatTTL(emailVerificationTTL),
+	})
+	return nil
+}
+
+// VerifyEmail confirms an address using the token from the verification email
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := consumeAuthToken(r, models.TokenPurposeVerifyEmail, req.Token)
+	if err != nil {
+		http.Error(w, "This verification link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+
+	// The address must not have changed since the link was sent
+	now := time.Now()
+	result, err := database.GetCollectionFromRequest(r, "users").UpdateOne(
+		context.Background(),
+		bson.M{"_id": token.UserID, "email": token.Email},
+		bson.M{"$set": bson.M{"emailVerified": true, "emailVerifiedAt": now, "updatedAt": now}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "This verification link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification sends a fresh verification link. Like ForgotPassword it doesn't
+// reveal whether the address is registered.
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err := database.GetCollectionFromRequest(r, "users").FindOne(
+		context.Background(),
+		bson.M{"email": strings.TrimSpace(req.Email), "emailVerified": false},
+	).Decode(&user)
+	if err == nil {
+		if err := sendVerificationEmail(r, &user); err != nil {
+			log.Printf("Failed to resend verification email: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If that address is waiting for verification, a new link is on its way.",
+	})
+}
+
+// backfilledDatabases records tenant databases whose legacy users were already
+// marked as verified by this process
+var backfilledDatabases sync.Map
+
+// backfillEmailVerification marks accounts created before email verification existed
+// as verified, so they aren't locked out. It runs once per tenant database.
+func backfillEmailVerification(r *http.Request) {
+	dbName := middleware.GetTenantDatabase(r)
+	if _, done := backfilledDatabases.Load(dbName); done {
+		return
+	}
+
+	result, err := database.GetCollectionFromRequest(r, "users").UpdateMany(context.Background(),
+		bson.M{"emailVerified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	)
+	if err != nil {
+		log.Printf("[%s] email verification backfill failed: %v", dbName, err)
+		return
+	}
+	backfilledDatabases.Store(dbName, true)
+	if result.ModifiedCount > 0 {
+		log.Printf("[%s] marked %d existing users as email-verified", dbName, result.ModifiedCount)
+	}
+}
@@ -1,59 +1,43 @@
 package handlers
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
-	"time"
+	"net/http/httptest"
+	"strings"
+	"testing"
 )
 
-// TestFacebookPageConnection tests Facebook Page API connection with real API call
-func TestFacebookPageConnection(pageID, pageToken string) (bool, string) {
-	if pageID == "" || pageToken == "" {
-		return false, "Missing Facebook Page ID or Access Token"
-	}
-	
-	// Test with Facebook Graph API - get page details
-	client := &http.Client{Timeout: 10 * time.Second}
-	url := fmt.Sprintf("https://graph.facebook.com/v18.0/%s?fields=name,id,fan_count&access_token=%s", pageID, pageToken)
-	
-	resp, err := client.Get(url)
-	if err != nil {
-		return false, fmt.Sprintf("Connection failed: %v", err)
-	}
-	defer resp.Body.Close()
-	
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return false, "Failed to read response"
-	}
-	
-	if resp.StatusCode == 200 {
-		// Parse the response to get page name
-		var pageData struct {
-			Name     string `json:"name"`
-			ID       string `json:"id"`
-			FanCount int    `json:"fan_count"`
+func TestFacebookPageConnectionMessages(t *testing.T) {
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != "good-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"Invalid OAuth access token.","code":190}}`))
+			return
 		}
-		
-		if err := json.Unmarshal(body, &pageData); err == nil && pageData.Name != "" {
-			return true, fmt.Sprintf("Connected to Facebook Page: %s (Fans: %d)", pageData.Name, pageData.FanCount)
+		if r.URL.Path == "/unnamed" {
+			w.Write([]byte(`{"id":"2"}`))
+			return
 		}
-		return true, "Facebook Page connection successful"
-	}
-	
-	// Try to get error message from response
-	var fbError struct {
-		Error struct {
-			Message string `json:"message"`
-			Code    int    `json:"code"`
-		} `json:"error"`
+		w.Write([]byte(`{"name":"Coders in Flow","id":"1","fan_count":42}`))
+	}))
+	defer graph.Close()
+	defer func(url string) { facebookGraphURL = url }(facebookGraphURL)
+	facebookGraphURL = graph.URL
+
+	cases := []struct {
+		pageID, token string
+		ok            bool
+		message       string
+	}{
+		{"", "good-token", false, "Missing Facebook Page ID or Access Token"},
+		{"page", "good-token", true, "Connected to Facebook Page: Coders in Flow (Fans: 42)"},
+		{"unnamed", "good-token", true, "Facebook Page connection successful"},
+		{"page", "expired", false, "Facebook API error: Invalid OAuth access token."},
 	}
-	
-	if err := json.Unmarshal(body, &fbError); err == nil && fbError.Error.Message != "" {
-		return false, fmt.Sprintf("Facebook API error: %s", fbError.Error.Message)
+	for _, c := range cases {
+		ok, message := TestFacebookPageConnection(c.pageID, c.token)
+		if ok != c.ok || !strings.Contains(message, c.message) {
+			t.Errorf("page %q with %q = %v, %q; want %v, %q", c.pageID, c.token, ok, message, c.ok, c.message)
+		}
 	}
-	
-	return false, fmt.Sprintf("Facebook API error: HTTP %d", resp.StatusCode)
-}
\ No newline at end of file
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"runtime"
 	"strings"
 	"time"
@@ -57,13 +56,8 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Check JWT secret configuration
-	if os.Getenv("JWT_SECRET") == "" {
-		health.Status = "degraded"
-		health.Services["jwt"] = "using default secret (not secure for production)"
-	} else {
-		health.Services["jwt"] = "configured"
-	}
+	// Tokens are signed with per-tenant keys kept in the tenant_secrets collection
+	health.Services["jwt"] = "per-tenant signing keys"
 
 	// Set appropriate status code
 	statusCode := http.StatusOK
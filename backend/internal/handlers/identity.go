@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/identity"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/passwords"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errIdentityPassword is returned when a new member of a site gives a password that
+// doesn't match the shared account with their email
+var errIdentityPassword = errors.New("password doesn't match the shared account")
+
+// errIdentityUnverified is returned when an admin adds a member whose email belongs to
+// a shared account nobody has confirmed owning
+var errIdentityUnverified = errors.New("shared account isn't verified")
+
+// passwordHash returns the hash the user's password is checked against: their
+// identity's if they are linked to one, otherwise their own. It is empty for users
+// without a password.
+func passwordHash(user *models.User) string {
+	if user.IdentityID == nil {
+		return user.Password
+	}
+	shared, err := identity.Get(context.Background(), *user.IdentityID)
+	if err != nil {
+		log.Printf("Failed to load identity of user %s: %v", user.ID.Hex(), err)
+		return ""
+	}
+	return shared.Password
+}
+
+// hasPassword reports whether the user can sign in with a password
+func hasPassword(user *models.User) bool {
+	return user.IdentityID != nil || user.Password != ""
+}
+
+// checkPassword compares a password with the user's, on their identity if they have one
+func checkPassword(user *models.User, password string) error {
+	hash := passwordHash(user)
+	if hash == "" {
+		return errors.New("no password set")
+	}
+	return passwords.Compare(hash, password)
+}
+
+// storePassword saves a new password hash for the user, together with any other fields
+// in set. A linked user's password is kept on their identity, so it changes on every
+// site they belong to.
+func storePassword(r *http.Request, user *models.User, hash string, set bson.M) error {
+	if user.IdentityID != nil {
+		if err := identity.SetPassword(context.Background(), *user.IdentityID, hash, ""); err != nil {
+			return err
+		}
+	} else {
+		set["password"] = hash
+	}
+	set["updatedAt"] = time.Now()
+	result, err := database.GetCollectionFromRequest(r, "users").UpdateOne(context.Background(),
+		bson.M{"_id": user.ID}, bson.M{"$set": set})
+	if err == nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
+
+// revokeIdentitySessions signs a linked user out on every other site they belong to,
+// after their shared password changed
+func revokeIdentitySessions(r *http.Request, user *models.User, reason string) {
+	if user.IdentityID == nil {
+		return
+	}
+	shared, err := identity.Get(context.Background(), *user.IdentityID)
+	if err != nil {
+		log.Printf("Failed to load identity of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	current := middleware.GetTenantDatabase(r)
+	for _, m := range shared.Memberships {
+		if m.Database == current {
+			continue
+		}
+		_, err := database.GetTenantCollection(m.Database, "sessions").UpdateMany(context.Background(),
+			bson.M{"userId": m.UserID, "revokedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"revokedAt": time.Now(), "revokedReason": reason}},
+		)
+		if err != nil {
+			log.Printf("[%s] failed to revoke sessions of user %s: %v", m.Database, m.UserID.Hex(), err)
+		}
+	}
+}
+
+// identityFor returns the identity a user on a site sharing identities belongs to: the
+// one with their email, or a new one holding their password hash. Joining an existing
+// identity takes its password unless trusted, for an admin adding a member, which needs
+// the identity to be verified instead. Callers make sure the user's email is verified.
+func identityFor(user *models.User, password string, trusted bool) (*models.Identity, error) {
+	existing, err := identity.FindByEmail(context.Background(), user.Email)
+	if err == identity.ErrNotFound {
+		return identity.New(user.Email, user.Password), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if trusted && !existing.Verified {
+		return nil, errIdentityUnverified
+	}
+	if !trusted && existing.ComparePassword(password) != nil {
+		return nil, errIdentityPassword
+	}
+	return existing, nil
+}
+
+// prepareIdentity links a user about to be created on a site sharing identities to
+// their identity, leaving them without a password of their own. It writes the error
+// response and returns false if they can't join. Local sites get a nil identity, as do
+// users whose email isn't verified yet; they are linked when they first sign in after
+// verifying it.
+func prepareIdentity(w http.ResponseWriter, r *http.Request, user *models.User, password string, trusted bool) (*models.Identity, bool) {
+	if !middleware.GetTenantConfig(r).SharesIdentity() || !user.EmailVerified {
+		return nil, true
+	}
+	shared, err := identityFor(user, password, trusted)
+	if errors.Is(err, errIdentityPassword) {
+		http.Error(w, "This email already has an account on another of our sites - use that account's password", http.StatusConflict)
+		return nil, false
+	}
+	if errors.Is(err, errIdentityUnverified) {
+		http.Error(w, "This email has an unconfirmed account on another of our sites - invite them instead", http.StatusConflict)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to look up identity for %s: %v", user.Email, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	user.IdentityID = &shared.ID
+	user.Password = ""
+	return shared, true
+}
+
+// joinIdentity records a newly created user's membership of their identity, removing
+// the user again if that fails. It writes the error response and returns false then.
+func joinIdentity(w http.ResponseWriter, r *http.Request, user *models.User, shared *models.Identity) bool {
+	if shared == nil {
+		return true
+	}
+	if err := identity.Join(context.Background(), shared, middleware.GetTenantDatabase(r), user.ID); err != nil {
+		log.Printf("Failed to add user %s to identity %s: %v", user.ID.Hex(), shared.ID.Hex(), err)
+		database.GetCollectionFromRequest(r, "users").DeleteOne(context.Background(), bson.M{"_id": user.ID})
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// linkIdentity moves a local user on a site sharing identities into the global store
+// when they sign in with their password, so sites can switch to sharing without anyone
+// resetting their password. A user whose password differs from the existing identity
+// with their email keeps signing in locally, and so does one who hasn't verified it.
+func linkIdentity(r *http.Request, user *models.User, password string) {
+	if user.IdentityID != nil || !user.EmailVerified || !middleware.GetTenantConfig(r).SharesIdentity() {
+		return
+	}
+	shared, err := identityFor(user, password, false)
+	if errors.Is(err, errIdentityPassword) {
+		log.Printf("Not linking user %s: password differs from the identity of %s", user.ID.Hex(), user.Email)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up identity for %s: %v", user.Email, err)
+		return
+	}
+	db := middleware.GetTenantDatabase(r)
+	if err := identity.Join(context.Background(), shared, db, user.ID); err != nil {
+		log.Printf("Failed to add user %s to identity %s: %v", user.ID.Hex(), shared.ID.Hex(), err)
+		return
+	}
+	result, err := database.GetCollectionFromRequest(r, "users").UpdateOne(context.Background(),
+		bson.M{"_id": user.ID, "identityId": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"identityId": shared.ID, "password": "", "updatedAt": time.Now()}},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		log.Printf("Failed to link user %s to identity %s: %v", user.ID.Hex(), shared.ID.Hex(), err)
+		identity.Leave(context.Background(), shared.ID, db)
+		return
+	}
+	user.IdentityID = &shared.ID
+	user.Password = ""
+
+	// The user is the actor; nobody is signed in yet
+	ctx := context.WithValue(r.Context(), middleware.UserContextKey, user)
+	recordAudit(r.WithContext(ctx), models.AuditUserIdentityLink, "user", user.ID.Hex(), nil,
+		map[string]interface{}{"identityId": shared.ID})
+}
+
+// siteView is a site in the tenant switcher
+type siteView struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	URL      string `json:"url,omitempty"`
+	Role     string `json:"role"`
+	Approved bool   `json:"approved"` // Whether the user can sign in there yet
+	Current  bool   `json:"current"`
+}
+
+// memberSite describes a site the identity is a member of, skipping sites that stopped
+// sharing identities and memberships whose user is gone
+func memberSite(m models.Membership, identityID primitive.ObjectID, current string) (siteView, bool) {
+	config := middleware.GetConfigForDatabase(m.Database)
+	if !config.SharesIdentity() {
+		return siteView{}, false
+	}
+	var member models.User
+	err := database.GetTenantCollection(m.Database, "users").FindOne(context.Background(), bson.M{
+		"_id":        m.UserID,
+		"identityId": identityID,
+		"deletedAt":  bson.M{"$exists": false},
+	}).Decode(&member)
+	if err != nil {
+		return siteView{}, false
+	}
+	return siteView{
+		ID:       config.ID,
+		Name:     config.Name,
+		URL:      middleware.GetSiteURLForDatabase(m.Database),
+		Role:     member.Role,
+		Approved: member.Approved && member.EmailVerified,
+		Current:  m.Database == current,
+	}, true
+}
+
+// GetMySites lists the sites the signed-in user can switch between: every site their
+// identity belongs to, or just this one for users of an isolated site
+func GetMySites(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	current := middleware.GetTenantDatabase(r)
+	sites := []siteView{}
+	if user.IdentityID != nil {
+		shared, err := identity.Get(context.Background(), *user.IdentityID)
+		if err != nil && err != identity.ErrNotFound {
+			http.Error(w, "Failed to load sites", http.StatusInternalServerError)
+			return
+		}
+		if shared != nil {
+			for _, m := range shared.Memberships {
+				if site, ok := memberSite(m, shared.ID, current); ok {
+					sites = append(sites, site)
+				}
+			}
+		}
+	}
+	if len(sites) == 0 {
+		config := middleware.GetTenantConfig(r)
+		sites = append(sites, siteView{ID: config.ID, Name: config.Name, URL: siteURL(r), Role: user.Role, Approved: true, Current: true})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"shared": user.IdentityID != nil,
+		"sites":  sites,
+	})
+}
+
+type SwitchSiteRequest struct {
+	Site string `json:"site"` // Site ID from GetMySites
+}
+
+// SwitchSite starts single sign-on to another site the signed-in user belongs to. It
+// returns the URL to send the browser to, which signs them in there with a one-time
+// ticket.
+func SwitchSite(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req SwitchSiteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Site == "" {
+		http.Error(w, "Site is required", http.StatusBadRequest)
+		return
+	}
+	if user.IdentityID == nil {
+		http.Error(w, "Your account isn't shared with other sites", http.StatusBadRequest)
+		return
+	}
+	shared, err := identity.Get(context.Background(), *user.IdentityID)
+	if err != nil {
+		http.Error(w, "Your account isn't shared with other sites", http.StatusBadRequest)
+		return
+	}
+
+	current := middleware.GetTenantDatabase(r)
+	for _, m := range shared.Memberships {
+		site, ok := memberSite(m, shared.ID, current)
+		if !ok || site.ID != req.Site || site.Current {
+			continue
+		}
+		if !site.Approved {
+			http.Error(w, "Your account on that site is waiting for approval", http.StatusForbidden)
+			return
+		}
+		if site.URL == "" {
+			http.Error(w, "That site has no address to switch to", http.StatusConflict)
+			return
+		}
+		ticket, err := identity.IssueTicket(context.Background(), shared.ID, current, m.Database)
+		if err != nil {
+			log.Printf("Failed to issue sign-on ticket for identity %s: %v", shared.ID.Hex(), err)
+			http.Error(w, "Failed to switch site", http.StatusInternalServerError)
+			return
+		}
+		recordSecurityEvent(r, models.EventSSOTicketIssued, user.Email, &user.ID, site.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"redirect": site.URL + "/blog/editor/sso?ticket=" + url.QueryEscape(ticket),
+		})
+		return
+	}
+	http.Error(w, "You aren't a member of that site", http.StatusNotFound)
+}
+
+// GetSSOTicket tells the single sign-on page which account a ticket from SwitchSite
+// signs in as, so the user can check it's theirs before continuing. Pass the ticket as
+// ?ticket=; it isn't used up.
+func GetSSOTicket(w http.ResponseWriter, r *http.Request) {
+	db := middleware.GetTenantDatabase(r)
+	ticket, err := identity.FindTicket(context.Background(), r.URL.Query().Get("ticket"), db)
+	if err != nil || !middleware.GetTenantConfig(r).SharesIdentity() {
+		http.Error(w, ssoExpiredMessage, http.StatusNotFound)
+		return
+	}
+	shared, err := identity.Get(context.Background(), ticket.IdentityID)
+	if err != nil {
+		http.Error(w, ssoExpiredMessage, http.StatusNotFound)
+		return
+	}
+
+	from := middleware.GetConfigForDatabase(ticket.SourceDatabase)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"email": shared.Email,
+		"from":  from.Name,
+	})
+}
+
+const ssoExpiredMessage = "This sign-in link has expired - please sign in again"
+
+type RedeemSSOTicketRequest struct {
+	Ticket string `json:"ticket" validate:"required"`
+}
+
+// RedeemSSOTicket signs the browser in with a ticket from SwitchSite on another site.
+// It's a POST from the single sign-on page after the user confirms the account, never
+// the link itself, so another site can't sign a visitor in to the sender's account
+// (login CSRF). Users with a second factor on this site still have to complete it.
+func RedeemSSOTicket(w http.ResponseWriter, r *http.Request) {
+	var req RedeemSSOTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	db := middleware.GetTenantDatabase(r)
+	ticket, err := identity.RedeemTicket(context.Background(), req.Ticket, db)
+	if err != nil || !middleware.GetTenantConfig(r).SharesIdentity() {
+		http.Error(w, ssoExpiredMessage, http.StatusBadRequest)
+		return
+	}
+	shared, err := identity.Get(context.Background(), ticket.IdentityID)
+	if err != nil || shared.Membership(db) == nil {
+		http.Error(w, "Your account doesn't have access to this site", http.StatusForbidden)
+		return
+	}
+
+	var user models.User
+	err = database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{
+		"_id":        shared.Membership(db).UserID,
+		"identityId": shared.ID,
+		"deletedAt":  bson.M{"$exists": false},
+	}).Decode(&user)
+	if err != nil {
+		http.Error(w, "Your account doesn't have access to this site", http.StatusForbidden)
+		return
+	}
+	if !user.Approved || !user.EmailVerified {
+		http.Error(w, "Your account on this site is waiting for approval", http.StatusForbidden)
+		return
+	}
+	if accountLocked(w, r, &user, "sso") {
+		return
+	}
+
+	recordSecurityEvent(r, models.EventLoginSucceeded, user.Email, &user.ID, "sso from "+ticket.SourceDatabase)
+	// The ticket stands in for the password, not for a second factor set up on this site
+	completeLogin(w, r, &user)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// withSharedIdentity serves the default site from the global identity store
+func withSharedIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites-config.json")
+	config := `{"default": {"id": "default", "database": "coders_website", "identity": "global"}}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SITES_CONFIG_PATH", path)
+	middleware.LoadSitesConfig()
+	t.Cleanup(func() {
+		os.Unsetenv("SITES_CONFIG_PATH")
+		middleware.LoadSitesConfig()
+	})
+}
+
+func TestSSOTicketNeedsAPost(t *testing.T) {
+	withSharedIdentity(t)
+	user := testUser(models.RoleAuthor)
+	shared := models.Identity{
+		ID:          primitive.NewObjectID(),
+		Email:       user.Email,
+		Memberships: []models.Membership{{Database: "coders_website", UserID: user.ID}},
+	}
+	user.IdentityID = &shared.ID
+	ticket := models.SSOTicket{ID: "hash", IdentityID: shared.ID, Database: "coders_website", SourceDatabase: "other", ExpiresAt: time.Now().Add(time.Minute)}
+
+	runWithMockDB(t, "opening the link", func(mt *mtest.T) {
+		queueFound(mt, ticket)
+		queueFound(mt, shared)
+		w := serve(httptest.NewRequest(http.MethodGet, "/api/auth/sso?ticket=secret", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), user.Email) {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if len(w.Header().Values("Set-Cookie")) > 0 {
+			t.Error("opening the link signed the browser in")
+		}
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName != "find" {
+				t.Errorf("opening the link ran %s; the ticket must stay usable", event.CommandName)
+			}
+		}
+	})
+
+	runWithMockDB(t, "continuing", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: toDoc(mt, ticket)}))
+		queueFound(mt, shared)
+		queueFound(mt, user)
+		queueFound(mt)    // login_attempts: not locked
+		queueWrite(mt, 1) // security event
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.passkeys", mtest.FirstBatch, bson.D{{Key: "n", Value: 0}}))
+		queueWrite(mt, 1) // session
+
+		w := serve(httptest.NewRequest(http.MethodPost, "/api/auth/sso", strings.NewReader(`{"ticket":"secret"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if !strings.Contains(strings.Join(w.Header().Values("Set-Cookie"), ";"), accessTokenCookie+"=") {
+			t.Error("no session cookie was set")
+		}
+	})
+}
+
+func TestIdentitiesOnlyFromVerifiedEmails(t *testing.T) {
+	withSharedIdentity(t)
+
+	// prepare runs prepareIdentity for the user as the default site would
+	prepare := func(user models.User, trusted bool) (*models.Identity, *httptest.ResponseRecorder) {
+		var shared *models.Identity
+		w := httptest.NewRecorder()
+		middleware.TenantMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			shared, _ = prepareIdentity(w, r, &user, "password", trusted)
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/users", nil))
+		return shared, w
+	}
+
+	runWithMockDB(t, "unverified users stay local", func(mt *mtest.T) {
+		user := testUser(models.RoleAuthor)
+		user.EmailVerified = false
+		if shared, w := prepare(user, false); shared != nil || w.Code != http.StatusOK {
+			t.Errorf("got identity %v, status %d", shared, w.Code)
+		}
+		if events := mt.GetAllStartedEvents(); len(events) > 0 {
+			t.Errorf("looked up an identity: %s", events[0].CommandName)
+		}
+	})
+
+	runWithMockDB(t, "admins can't add members to unverified identities", func(mt *mtest.T) {
+		user := testUser(models.RoleAuthor)
+		queueFound(mt, models.Identity{ID: primitive.NewObjectID(), Email: user.Email})
+		if shared, w := prepare(user, true); shared != nil || w.Code != http.StatusConflict {
+			t.Errorf("got identity %v, status %d", shared, w.Code)
+		}
+	})
+
+	runWithMockDB(t, "admins can add members to verified identities", func(mt *mtest.T) {
+		user := testUser(models.RoleAuthor)
+		queueFound(mt, models.Identity{ID: primitive.NewObjectID(), Email: user.Email, Verified: true})
+		if shared, w := prepare(user, true); shared == nil || w.Code != http.StatusOK {
+			t.Errorf("got identity %v, status %d", shared, w.Code)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// impersonationTTL is how long an admin can act as another user before having to start
+// again (IMPERSONATION_TTL, default 30m)
+func impersonationTTL() time.Duration {
+	return durationFromEnv("IMPERSONATION_TTL", 30*time.Minute)
+}
+
+// StartImpersonation lets an admin act as another user, to see exactly what they see.
+// The access cookie is replaced by one for the user that also names the admin; the
+// admin's refresh token is left alone, and StopImpersonation switches back.
+func StartImpersonation(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUserFromContext(r)
+	parentID := middleware.GetSessionID(r)
+	if !ok || parentID.IsZero() {
+		http.Error(w, "Impersonation needs a signed-in session", http.StatusForbidden)
+		return
+	}
+
+	targetID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	target := findUser(r, targetID)
+	if target == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if target.ID == admin.ID {
+		http.Error(w, "You can't impersonate yourself", http.StatusBadRequest)
+		return
+	}
+	if target.Can(models.PermManageUsers) {
+		http.Error(w, "Admins can't be impersonated", http.StatusForbidden)
+		return
+	}
+
+	// The session has no refresh token, so it ends at ExpiresAt however it's used
+	secret, err := auth.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	ttl := impersonationTTL()
+	session := models.Session{
+		ID:              primitive.NewObjectID(),
+		UserID:          target.ID,
+		TokenHash:       auth.HashToken(secret),
+		UserAgent:       r.UserAgent(),
+		IP:              middleware.GetClientIP(r),
+		CreatedAt:       now,
+		LastUsedAt:      now,
+		ExpiresAt:       now.Add(ttl),
+		ImpersonatorID:  &admin.ID,
+		ParentSessionID: &parentID,
+	}
+	if _, err := database.GetCollectionFromRequest(r, "sessions").InsertOne(context.Background(), session); err != nil {
+		http.Error(w, "Failed to start impersonation", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := auth.GenerateTenantToken(middleware.GetTenantID(r), jwt.MapClaims{
+		"userId":         target.ID.Hex(),
+		"email":          target.Email,
+		"role":           target.Role,
+		"sid":            session.ID.Hex(),
+		"impersonatorId": admin.ID.Hex(),
+	}, ttl)
+	if err != nil {
+		revokeSessions(r, bson.M{"_id": session.ID}, "impersonation failed")
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+	setAccessTokenCookie(w, token, ttl)
+
+	recordAudit(r, models.AuditImpersonationStart, "user", target.ID.Hex(), nil, nil)
+	log.Printf("Admin %s started impersonating %s until %s", admin.Email, target.Email, session.ExpiresAt.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Now signed in as " + target.Name,
+		"user":      target.Summary(),
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+	})
+}
+
+// StopImpersonation ends the current impersonation session and signs the admin back in
+// with their own session
+func StopImpersonation(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetImpersonator(r)
+	if !ok {
+		http.Error(w, "You are not impersonating anyone", http.StatusBadRequest)
+		return
+	}
+
+	session, err := endImpersonation(r, "impersonation ended")
+	if err != nil {
+		http.Error(w, "Failed to end impersonation", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := signAccessToken(r, admin, *session.ParentSessionID)
+	if err != nil {
+		clearAuthCookies(w)
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+	setAccessTokenCookie(w, token, accessTokenTTL())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Impersonation ended",
+		"user":      admin,
+		"token":     token,
+		"expiresIn": int(accessTokenTTL().Seconds()),
+	})
+}
+
+// endImpersonation revokes the request's impersonation session, records it in the
+// audit log and returns the session
+func endImpersonation(r *http.Request, reason string) (*models.Session, error) {
+	var session models.Session
+	sessions := database.GetCollectionFromRequest(r, "sessions")
+	if err := sessions.FindOne(context.Background(), bson.M{"_id": middleware.GetSessionID(r)}).Decode(&session); err != nil {
+		return nil, err
+	}
+	if _, err := revokeSessions(r, bson.M{"_id": session.ID}, reason); err != nil {
+		return nil, err
+	}
+
+	recordAudit(r, models.AuditImpersonationStop, "user", session.UserID.Hex(), nil, nil)
+	if admin, ok := middleware.GetImpersonator(r); ok {
+		log.Printf("Admin %s stopped impersonating user %s", admin.Email, session.UserID.Hex())
+	}
+	return &session, nil
+}
+
+// impersonationInfo tells the frontend that the signed-in user is being impersonated
+type impersonationInfo struct {
+	By        models.UserSummary `json:"by"`
+	ExpiresAt time.Time          `json:"expiresAt"`
+}
+
+// currentImpersonation describes the request's impersonation session, or returns nil
+func currentImpersonation(r *http.Request) *impersonationInfo {
+	admin, ok := middleware.GetImpersonator(r)
+	if !ok {
+		return nil
+	}
+	info := &impersonationInfo{By: admin.Summary()}
+	var session models.Session
+	if err := database.GetCollectionFromRequest(r, "sessions").FindOne(context.Background(), bson.M{"_id": middleware.GetSessionID(r)}).Decode(&session); err == nil {
+		info.ExpiresAt = session.ExpiresAt
+	}
+	return info
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// impersonate returns the cookie of an admin's impersonation session for the user and
+// queues the lookups the auth middleware makes with it
+func impersonate(mt *mtest.T, admin, user models.User) *http.Cookie {
+	sessionID, parentID := primitive.NewObjectID(), primitive.NewObjectID()
+	token, err := auth.GenerateTenantToken(testTenant, jwt.MapClaims{
+		"userId":         user.ID.Hex(),
+		"email":          user.Email,
+		"role":           user.Role,
+		"sid":            sessionID.Hex(),
+		"impersonatorId": admin.ID.Hex(),
+	}, time.Minute)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	queueFound(mt, models.Session{ID: sessionID, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour),
+		ImpersonatorID: &admin.ID, ParentSessionID: &parentID})
+	queueFound(mt, user)
+	mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.sessions", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+	queueFound(mt, admin)
+	return &http.Cookie{Name: accessTokenCookie, Value: token}
+}
+
+func TestStartImpersonation(t *testing.T) {
+	admin := testUser(models.RoleAdmin)
+
+	runWithMockDB(t, "author", func(mt *mtest.T) {
+		author := testUser(models.RoleAuthor)
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/users/"+author.ID.Hex()+"/impersonate", nil)
+		r.AddCookie(signIn(mt, admin))
+		queueFound(mt, author)
+		queueWrite(mt, 1) // Session
+		queueWrite(mt, 1) // Audit entry
+
+		w := serve(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		cookies := strings.Join(w.Header().Values("Set-Cookie"), ";")
+		if !strings.Contains(cookies, accessTokenCookie+"=") || strings.Contains(cookies, refreshTokenCookie+"=") {
+			t.Errorf("cookies = %s, want only a new access token", cookies)
+		}
+
+		var session bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" && event.Command.Lookup("insert").StringValue() == "sessions" {
+				session = event.Command.Lookup("documents", "0").Document()
+			}
+		}
+		if session == nil {
+			t.Fatal("no impersonation session was stored")
+		}
+		if id := session.Lookup("impersonatorId").ObjectID(); id != admin.ID {
+			t.Errorf("session names impersonator %s, want %s", id.Hex(), admin.ID.Hex())
+		}
+		if expires := session.Lookup("expiresAt").Time(); expires.After(time.Now().Add(impersonationTTL())) {
+			t.Errorf("session lasts until %s, longer than %s", expires, impersonationTTL())
+		}
+	})
+
+	runWithMockDB(t, "admin", func(mt *mtest.T) {
+		other := testUser(models.RoleAdmin)
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/users/"+other.ID.Hex()+"/impersonate", nil)
+		r.AddCookie(signIn(mt, admin))
+		queueFound(mt, other)
+
+		if w := serve(r); w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403: %s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestImpersonationCantChangeCredentials(t *testing.T) {
+	runWithMockDB(t, "update user", func(mt *mtest.T) {
+		user := testUser(models.RoleAuthor)
+		r := httptest.NewRequest(http.MethodPut, "/api/users/"+user.ID.Hex(), strings.NewReader(`{"email":"admin@evil.example"}`))
+		r.AddCookie(impersonate(mt, testUser(models.RoleAdmin), user))
+
+		if w := serve(r); w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403: %s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestAuditNamesTheImpersonator(t *testing.T) {
+	runWithMockDB(t, "audit", func(mt *mtest.T) {
+		admin, user := testUser(models.RoleAdmin), testUser(models.RoleAuthor)
+		ctx := context.WithValue(context.Background(), middleware.UserContextKey, &user)
+		ctx = context.WithValue(ctx, middleware.ImpersonatorContextKey, &admin)
+		r := httptest.NewRequest(http.MethodPut, "/api/posts/x", nil).WithContext(ctx)
+		queueWrite(mt, 1)
+
+		recordAudit(r, models.AuditPostUpdate, "post", "x", nil, nil)
+
+		entry := mt.GetStartedEvent().Command.Lookup("documents", "0").Document()
+		if entry.Lookup("actorEmail").StringValue() != user.Email || entry.Lookup("impersonatorEmail").StringValue() != admin.Email {
+			t.Errorf("entry = %s, want %s acting for %s", entry, admin.Email, user.Email)
+		}
+	})
+}
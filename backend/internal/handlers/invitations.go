@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/mailer"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// invitationTTL is how long an invitation link works
+const invitationTTL = 7 * 24 * time.Hour
+
+// invitationView is an invitation as listed to admins
+type invitationView struct {
+	*models.Invitation
+	Status string `json:"status"`
+}
+
+func viewInvitation(invitation *models.Invitation) invitationView {
+	return invitationView{Invitation: invitation, Status: invitation.Status(time.Now())}
+}
+
+// newInvitationToken gives the invitation a fresh token and expiry. Any link sent
+// earlier stops working once the invitation is saved.
+func newInvitationToken(invitation *models.Invitation) (string, error) {
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	invitation.TokenHash = auth.HashToken(token)
+	invitation.SentAt = now
+	invitation.ExpiresAt = now.Add(invitationTTL)
+	return token, nil
+}
+
+// mailInvitation emails the invitation link
+func mailInvitation(r *http.Request, invitation *models.Invitation, token string) {
+	invitedBy := "An admin"
+	if admin, ok := middleware.GetUserFromContext(r); ok && admin.Name != "" {
+		invitedBy = admin.Name
+	}
+	sendMailAsync(r, invitation.Email, mailer.TemplateInvitation, map[string]interface{}{
+		"Name":      invitation.Name,
+		"Role":      invitation.Role,
+		"InvitedBy": invitedBy,
+		"Link":      siteURL(r) + "/blog/editor/accept-invite?token=" + url.QueryEscape(token),
+		"ExpiresIn": formatTTL(invitationTTL),
+	})
+}
+
+type CreateInvitationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Name  string `json:"name"`
+	Role  string `json:"role" validate:"required,oneof=admin editor author viewer user"`
+}
+
+// CreateInvitation emails someone a link to join the site with the given role
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" || !strings.Contains(req.Email, "@") {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	count, err := database.GetCollectionFromRequest(r, "users").CountDocuments(context.Background(), bson.M{"email": req.Email})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, "User with this email already exists", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	pending, err := database.GetCollectionFromRequest(r, "invitations").CountDocuments(context.Background(), bson.M{
+		"email":      req.Email,
+		"acceptedAt": bson.M{"$exists": false},
+		"revokedAt":  bson.M{"$exists": false},
+		"expiresAt":  bson.M{"$gt": now},
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if pending > 0 {
+		http.Error(w, "This email already has a pending invitation - resend it instead", http.StatusConflict)
+		return
+	}
+
+	invitation := models.Invitation{
+		ID:        primitive.NewObjectID(),
+		Email:     req.Email,
+		Name:      strings.TrimSpace(req.Name),
+		Role:      models.NormalizeRole(req.Role),
+		InvitedBy: admin.ID,
+		CreatedAt: now,
+	}
+	token, err := newInvitationToken(&invitation)
+	if err != nil {
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+	if _, err := database.GetCollectionFromRequest(r, "invitations").InsertOne(context.Background(), invitation); err != nil {
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+	mailInvitation(r, &invitation, token)
+	recordAudit(r, models.AuditInvitationCreate, "invitation", invitation.ID.Hex(), nil, invitation)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(viewInvitation(&invitation))
+}
+
+// GetInvitations lists invitations, newest first. ?status= filters by pending (the
+// default), accepted, revoked, expired or all.
+func GetInvitations(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	query := bson.M{}
+	switch status := r.URL.Query().Get("status"); status {
+	case "", models.InvitationPending:
+		query = bson.M{"acceptedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}, "expiresAt": bson.M{"$gt": now}}
+	case models.InvitationAccepted:
+		query = bson.M{"acceptedAt": bson.M{"$exists": true}}
+	case models.InvitationRevoked:
+		query = bson.M{"revokedAt": bson.M{"$exists": true}}
+	case models.InvitationExpired:
+		query = bson.M{"acceptedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}, "expiresAt": bson.M{"$lte": now}}
+	case "all":
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := database.GetCollectionFromRequest(r, "invitations").Find(context.Background(), query,
+		options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		http.Error(w, "Failed to fetch invitations", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var invitations []models.Invitation
+	if err := cursor.All(context.Background(), &invitations); err != nil {
+		http.Error(w, "Failed to decode invitations", http.StatusInternalServerError)
+		return
+	}
+	views := make([]invitationView, 0, len(invitations))
+	for i := range invitations {
+		views = append(views, viewInvitation(&invitations[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// loadOpenInvitation finds an invitation that hasn't been accepted or revoked, writing
+// the error response if there isn't one
+func loadOpenInvitation(w http.ResponseWriter, r *http.Request) (*models.Invitation, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return nil, false
+	}
+	var invitation models.Invitation
+	if err := database.GetCollectionFromRequest(r, "invitations").FindOne(context.Background(), bson.M{"_id": id}).Decode(&invitation); err != nil {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return nil, false
+	}
+	if status := invitation.Status(time.Now()); status == models.InvitationAccepted || status == models.InvitationRevoked {
+		http.Error(w, "Invitation has already been "+status, http.StatusConflict)
+		return nil, false
+	}
+	return &invitation, true
+}
+
+// ResendInvitation emails a new link for a pending or expired invitation, restarting
+// its expiry
+func ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	invitation, ok := loadOpenInvitation(w, r)
+	if !ok {
+		return
+	}
+	before := *invitation
+	token, err := newInvitationToken(invitation)
+	if err != nil {
+		http.Error(w, "Failed to resend invitation", http.StatusInternalServerError)
+		return
+	}
+	result, err := database.GetCollectionFromRequest(r, "invitations").UpdateOne(context.Background(),
+		bson.M{"_id": invitation.ID, "acceptedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"tokenHash": invitation.TokenHash, "sentAt": invitation.SentAt, "expiresAt": invitation.ExpiresAt}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to resend invitation", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Invitation is no longer open", http.StatusConflict)
+		return
+	}
+	mailInvitation(r, invitation, token)
+	recordAudit(r, models.AuditInvitationResend, "invitation", invitation.ID.Hex(), before, invitation)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewInvitation(invitation))
+}
+
+// RevokeInvitation stops an invitation's link from working
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	invitation, ok := loadOpenInvitation(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	result, err := database.GetCollectionFromRequest(r, "invitations").UpdateOne(context.Background(),
+		bson.M{"_id": invitation.ID, "acceptedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": now}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to revoke invitation", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Invitation has already been accepted", http.StatusConflict)
+		return
+	}
+	before := *invitation
+	invitation.RevokedAt = &now
+	recordAudit(r, models.AuditInvitationRevoke, "invitation", invitation.ID.Hex(), before, invitation)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Invitation revoked",
+	})
+}
+
+// openInvitationFilter matches the invitation a link's token belongs to, if it can
+// still be accepted
+func openInvitationFilter(token string) bson.M {
+	return bson.M{
+		"tokenHash":  auth.HashToken(token),
+		"acceptedAt": bson.M{"$exists": false},
+		"revokedAt":  bson.M{"$exists": false},
+		"expiresAt":  bson.M{"$gt": time.Now()},
+	}
+}
+
+// GetInvitationByToken shows the invitee who invited them and with what role, so the
+// accept page can greet them. Pass the emailed token as ?token=.
+func GetInvitationByToken(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	var invitation models.Invitation
+	if token == "" || database.GetCollectionFromRequest(r, "invitations").FindOne(context.Background(), openInvitationFilter(token)).Decode(&invitation) != nil {
+		http.Error(w, "This invitation is invalid or has expired", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"email":     invitation.Email,
+		"name":      invitation.Name,
+		"role":      invitation.Role,
+		"expiresAt": invitation.ExpiresAt,
+	})
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Name     string `json:"name" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// AcceptInvitation creates the invitee's account with the invited role, already
+// approved and verified, and signs them in
+func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Token == "" || req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	user := models.User{
+		ID:              primitive.NewObjectID(),
+		Name:            req.Name,
+		Password:        req.Password,
+		Approved:        true, // The admin approved them by inviting them
+		EmailVerified:   true, // Following the emailed link proves the address
+		EmailVerifiedAt: &now,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if err := user.HashPassword(); err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	// Accepting marks the invitation used first, so the link only works once
+	invitations := database.GetCollectionFromRequest(r, "invitations")
+	var invitation models.Invitation
+	err := invitations.FindOneAndUpdate(context.Background(),
+		openInvitationFilter(req.Token),
+		bson.M{"$set": bson.M{"acceptedAt": now, "userId": user.ID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&invitation)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "This invitation is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	reopen := func() {
+		invitations.UpdateOne(context.Background(), bson.M{"_id": invitation.ID}, bson.M{"$unset": bson.M{"acceptedAt": "", "userId": ""}})
+	}
+
+	user.Email = invitation.Email
+	user.Role = models.NormalizeRole(invitation.Role)
+	if !checkPasswordPolicy(w, r, req.Password, user.Name, user.Email) {
+		reopen()
+		return
+	}
+
+	users := database.GetCollectionFromRequest(r, "users")
+	count, err := users.CountDocuments(context.Background(), bson.M{"email": user.Email})
+	if err != nil {
+		reopen()
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		reopen()
+		http.Error(w, "An account with this email already exists - sign in instead", http.StatusConflict)
+		return
+	}
+	shared, ok := prepareIdentity(w, r, &user, req.Password, false)
+	if !ok {
+		reopen()
+		return
+	}
+	if _, err := users.InsertOne(context.Background(), user); err != nil {
+		reopen()
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	if !joinIdentity(w, r, &user, shared) {
+		reopen()
+		return
+	}
+
+	// The new user is the actor; nobody is signed in yet
+	ctx := context.WithValue(r.Context(), middleware.UserContextKey, &user)
+	recordAudit(r.WithContext(ctx), models.AuditInvitationAccept, "invitation", invitation.ID.Hex(), nil, user)
+	log.Printf("Invitation %s accepted by %s", invitation.ID.Hex(), user.Email)
+
+	completeLogin(w, r, &user)
+}
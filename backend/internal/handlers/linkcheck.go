@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/linkcheck"
+	"github.com/coders-website/backend/internal/middleware"
+)
+
+// GetLinkReport returns the most recent link-check report for the tenant
+func GetLinkReport(w http.ResponseWriter, r *http.Request) {
+	report, err := linkcheck.LatestReport(context.Background(), database.GetDBFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to fetch link report", http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.Error(w, "No link report yet - run a check first", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// runningLinkChecks holds the tenant databases with a check in progress
+var runningLinkChecks sync.Map
+
+// RunLinkCheck starts checking the tenant's posts in the background, as a run can take
+// longer than a request may. The report is fetched with GetLinkReport once it is done.
+// Pass ?external=true to also fetch external URLs.
+func RunLinkCheck(w http.ResponseWriter, r *http.Request) {
+	dbName := middleware.GetTenantDatabase(r)
+	if _, running := runningLinkChecks.LoadOrStore(dbName, true); running {
+		http.Error(w, "A link check is already running", http.StatusConflict)
+		return
+	}
+
+	hosts := middleware.GetDomainsForDatabase(dbName)
+	hosts = append(hosts, middleware.GetTenantDomain(r))
+	db := database.GetDBFromRequest(r)
+	opts := linkcheck.Options{
+		UploadDir: getUploadDir(),
+		Hosts:     hosts,
+		External:  r.URL.Query().Get("external") == "true",
+	}
+
+	go func() {
+		defer runningLinkChecks.Delete(dbName)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		report, err := linkcheck.Run(ctx, db, opts)
+		if err != nil {
+			log.Printf("[%s] link check failed: %v", dbName, err)
+			return
+		}
+		log.Printf("[%s] link check: %d broken links in %d posts", dbName, report.BrokenCount, len(report.Posts))
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Link check started - fetch the link report when it finishes",
+	})
+}
+
+// StartLinkCheckJob checks internal links for every tenant right away, so there is a
+// report from the start, and then on the given interval
+func StartLinkCheckJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		checkAllTenantLinks()
+		for range ticker.C {
+			checkAllTenantLinks()
+		}
+	}()
+}
+
+func checkAllTenantLinks() {
+	for _, dbName := range middleware.GetAllTenantDatabases() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		report, err := linkcheck.Run(ctx, database.GetTenantDB(dbName), linkcheck.Options{
+			UploadDir: getUploadDir(),
+			Hosts:     middleware.GetDomainsForDatabase(dbName),
+		})
+		cancel()
+		if err != nil {
+			log.Printf("[%s] link check failed: %v", dbName, err)
+			continue
+		}
+		log.Printf("[%s] link check: %d broken links in %d posts", dbName, report.BrokenCount, len(report.Posts))
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// loginAttempts counts recent failed sign-ins for one account or IP
+type loginAttempts struct {
+	Key           string     `bson:"_id"`
+	Failures      int        `bson:"failures"`
+	LastFailureAt time.Time  `bson:"lastFailureAt"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty"`
+}
+
+// blockedUntil is when the next attempt is allowed under the policy
+func (a *loginAttempts) blockedUntil(policy auth.BackoffPolicy) time.Time {
+	until := a.LastFailureAt.Add(policy.Delay(a.Failures))
+	if a.LockedUntil != nil && a.LockedUntil.After(until) {
+		until = *a.LockedUntil
+	}
+	return until
+}
+
+// Unknown addresses get counters too, so throttling doesn't reveal which accounts exist
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(r *http.Request) string {
+	return "ip:" + middleware.GetClientIP(r)
+}
+
+// throttleKeys lists the counters a sign-in attempt is checked against. Without an
+// email only the IP is counted.
+func throttleKeys(r *http.Request, email string) map[string]auth.BackoffPolicy {
+	keys := map[string]auth.BackoffPolicy{ipThrottleKey(r): auth.IPLoginPolicy}
+	if strings.TrimSpace(email) != "" {
+		keys[accountThrottleKey(email)] = auth.AccountLoginPolicy
+	}
+	return keys
+}
+
+const accountLockedMessage = "Too many failed sign-ins. This account is temporarily locked - try again later or ask an admin to unlock it."
+
+// loginThrottled reports whether sign-ins for the email or from the request's IP must
+// wait, writing the response if so
+func loginThrottled(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+	var wait time.Duration
+	locked := false
+
+	for key, policy := range throttleKeys(r, email) {
+		var attempts loginAttempts
+		err := database.GetCollectionFromRequest(r, "login_attempts").FindOne(context.Background(), bson.M{"_id": key}).Decode(&attempts)
+		if err != nil || now.Sub(attempts.LastFailureAt) > policy.Window {
+			continue
+		}
+		if until := attempts.blockedUntil(policy); until.After(now) && until.Sub(now) > wait {
+			wait = until.Sub(now)
+			locked = attempts.LockedUntil != nil && attempts.LockedUntil.After(now)
+		}
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	recordSecurityEvent(r, models.EventLoginThrottled, email, nil, "")
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	if locked {
+		http.Error(w, accountLockedMessage, http.StatusTooManyRequests)
+	} else {
+		http.Error(w, fmt.Sprintf("Too many sign-in attempts - please wait %d seconds and try again", seconds), http.StatusTooManyRequests)
+	}
+	return true
+}
+
+// accountLockedUntil returns when the lockout on an account ends, if it is locked after
+// too many failed sign-ins
+func accountLockedUntil(r *http.Request, email string) (time.Time, bool) {
+	var attempts loginAttempts
+	err := database.GetCollectionFromRequest(r, "login_attempts").FindOne(context.Background(), bson.M{"_id": accountThrottleKey(email)}).Decode(&attempts)
+	if err != nil || attempts.LockedUntil == nil || !attempts.LockedUntil.After(time.Now()) {
+		return time.Time{}, false
+	}
+	return *attempts.LockedUntil, true
+}
+
+// accountLocked reports whether the user's account is locked, writing the response if
+// so. Sign-ins that don't use the password (passkeys, login providers, single sign-on)
+// check it, so they can't be used to get around a lockout.
+func accountLocked(w http.ResponseWriter, r *http.Request, user *models.User, method string) bool {
+	until, locked := accountLockedUntil(r, user.Email)
+	if !locked {
+		return false
+	}
+	recordSecurityEvent(r, models.EventLoginThrottled, user.Email, &user.ID, method)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	http.Error(w, accountLockedMessage, http.StatusTooManyRequests)
+	return true
+}
+
+// recordLoginFailure counts a failed sign-in against the email and the IP, locking the
+// account once it reaches the lockout threshold
+func recordLoginFailure(r *http.Request, email string, userID *primitive.ObjectID) {
+	recordSecurityEvent(r, models.EventLoginFailed, email, userID, "")
+	countLoginAttempt(r, email, userID)
+}
+
+// countLoginAttempt adds an attempt to the email's and the IP's counters (only the IP's
+// if email is empty) and applies the lockout threshold
+func countLoginAttempt(r *http.Request, email string, userID *primitive.ObjectID) {
+	now := time.Now()
+	collection := database.GetCollectionFromRequest(r, "login_attempts")
+	for key, policy := range throttleKeys(r, email) {
+		// Start counting again once earlier failures are outside the window
+		collection.DeleteOne(context.Background(), bson.M{"_id": key, "lastFailureAt": bson.M{"$lt": now.Add(-policy.Window)}})
+
+		var attempts loginAttempts
+		err := collection.FindOneAndUpdate(context.Background(),
+			bson.M{"_id": key},
+			bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"lastFailureAt": now}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&attempts)
+		if err != nil {
+			log.Printf("Failed to record sign-in failure for %s: %v", key, err)
+			continue
+		}
+
+		if policy.Locks(attempts.Failures) && (attempts.LockedUntil == nil || attempts.LockedUntil.Before(now)) {
+			lockedUntil := now.Add(policy.LockoutDuration)
+			collection.UpdateOne(context.Background(), bson.M{"_id": key}, bson.M{"$set": bson.M{"lockedUntil": lockedUntil}})
+			recordSecurityEvent(r, models.EventAccountLocked, email, userID, "locked until "+lockedUntil.Format(time.RFC3339))
+		}
+	}
+}
+
+// clearLoginFailures resets an account's counter after a successful sign-in. The IP's
+// counter is kept, so one valid account can't be used to reset it.
+func clearLoginFailures(r *http.Request, email string) {
+	database.GetCollectionFromRequest(r, "login_attempts").DeleteOne(context.Background(), bson.M{"_id": accountThrottleKey(email)})
+}
+
+// recordSecurityEvent stores a sign-in event for admins to review
+func recordSecurityEvent(r *http.Request, eventType, email string, userID *primitive.ObjectID, detail string) {
+	event := models.SecurityEvent{
+		ID:        primitive.NewObjectID(),
+		Type:      eventType,
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		UserID:    userID,
+		IP:        middleware.GetClientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+	if actor, ok := middleware.GetUserFromContext(r); ok {
+		event.ActorID = &actor.ID
+	}
+	if admin, ok := middleware.GetImpersonator(r); ok {
+		event.ImpersonatorID = &admin.ID
+	}
+	if _, err := database.GetCollectionFromRequest(r, "security_events").InsertOne(context.Background(), event); err != nil {
+		log.Printf("Failed to record security event %s: %v", eventType, err)
+	}
+}
+
+// GetSecurityEvents lists recent sign-in events, newest first. Filter with ?type=,
+// ?email= and ?userId=; ?limit= defaults to 100.
+func GetSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	query := bson.M{}
+	params := r.URL.Query()
+	if eventType := params.Get("type"); eventType != "" {
+		query["type"] = eventType
+	}
+	if email := params.Get("email"); email != "" {
+		query["email"] = strings.ToLower(strings.TrimSpace(email))
+	}
+	if userHex := params.Get("userId"); userHex != "" {
+		userID, err := primitive.ObjectIDFromHex(userHex)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		query["userId"] = userID
+	}
+	limit := int64(100)
+	if value, err := strconv.ParseInt(params.Get("limit"), 10, 64); err == nil && value > 0 && value <= 1000 {
+		limit = value
+	}
+
+	cursor, err := database.GetCollectionFromRequest(r, "security_events").Find(context.Background(), query,
+		options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(limit))
+	if err != nil {
+		http.Error(w, "Failed to fetch events", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	events := []models.SecurityEvent{}
+	if err := cursor.All(context.Background(), &events); err != nil {
+		http.Error(w, "Failed to decode events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// UnlockUser clears a user's failed sign-in counter and any 2FA lockout
+func UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	users := database.GetCollectionFromRequest(r, "users")
+	if err := users.FindOne(context.Background(), bson.M{"_id": id}).Decode(&user); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := database.GetCollectionFromRequest(r, "login_attempts").DeleteOne(context.Background(), bson.M{"_id": accountThrottleKey(user.Email)}); err != nil {
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+	if user.TwoFactor != nil {
+		users.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{
+			"$set":   bson.M{"twoFactor.failedCount": 0},
+			"$unset": bson.M{"twoFactor.lockedUntil": ""},
+		})
+	}
+	recordSecurityEvent(r, models.EventAccountUnlocked, user.Email, &user.ID, "")
+	recordAudit(r, models.AuditUserUnlock, "user", user.ID.Hex(), nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "User unlocked successfully",
+	})
+}
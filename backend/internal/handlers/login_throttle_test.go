@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAccountLocked(t *testing.T) {
+	user := testUser(models.RoleAuthor)
+
+	runWithMockDB(t, "locked", func(mt *mtest.T) {
+		lockedUntil := time.Now().Add(time.Minute)
+		queueFound(mt, loginAttempts{Key: accountThrottleKey(user.Email), Failures: 10, LastFailureAt: time.Now(), LockedUntil: &lockedUntil})
+		w := httptest.NewRecorder()
+		if !accountLocked(w, httptest.NewRequest(http.MethodPost, "/", nil), &user, "passkey") {
+			t.Fatal("locked account was let in")
+		}
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+
+	runWithMockDB(t, "lock expired", func(mt *mtest.T) {
+		lockedUntil := time.Now().Add(-time.Minute)
+		queueFound(mt, loginAttempts{Key: accountThrottleKey(user.Email), Failures: 10, LastFailureAt: time.Now(), LockedUntil: &lockedUntil})
+		if accountLocked(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), &user, "passkey") {
+			t.Error("expired lock still applied")
+		}
+	})
+
+	runWithMockDB(t, "failures without a lock", func(mt *mtest.T) {
+		queueFound(mt, loginAttempts{Key: accountThrottleKey(user.Email), Failures: 2, LastFailureAt: time.Now()})
+		if accountLocked(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), &user, "passkey") {
+			t.Error("account without a lock refused")
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/mailer"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// magicLinkTTL is how long a sign-in link works
+const magicLinkTTL = 15 * time.Minute
+
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// RequestMagicLink emails a one-time sign-in link on sites with the magic-link feature.
+// Requests count towards the same limits as password sign-ins, and the response is the
+// same whether or not the address has an account.
+func RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !middleware.GetTenantConfig(r).HasFeature(middleware.FeatureMagicLink) {
+		http.Error(w, "Sign-in links are not enabled for this site", http.StatusNotFound)
+		return
+	}
+
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+	if database.GetDB() == nil {
+		http.Error(w, "Database unavailable - please contact support", http.StatusServiceUnavailable)
+		return
+	}
+	if loginThrottled(w, r, email) {
+		return
+	}
+
+	var user models.User
+	var userID *primitive.ObjectID
+	err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		userID = &user.ID
+	}
+	recordSecurityEvent(r, models.EventMagicLinkSent, email, userID, "")
+	countLoginAttempt(r, email, userID)
+
+	// Accounts still waiting for approval can't sign in any other way either
+	if err == nil && user.Approved {
+		if link, err := newMagicLink(r, &user); err != nil {
+			log.Printf("Failed to create sign-in link: %v", err)
+		} else {
+			sendMailAsync(r, user.Email, mailer.TemplateMagicLink, map[string]interface{}{
+				"Name":      user.Name,
+				"Link":      link,
+				"ExpiresIn": formatTTL(magicLinkTTL),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for that email, a sign-in link is on its way.",
+	})
+}
+
+// newMagicLink stores a single-use token for the user and returns the link to email.
+// The token travels inside a JWT signed with the site's key, so a link only works on
+// the site that sent it.
+func newMagicLink(r *http.Request, user *models.User) (string, error) {
+	nonce, err := createAuthToken(r, models.TokenPurposeMagicLink, user.ID, user.Email, magicLinkTTL)
+	if err != nil {
+		return "", err
+	}
+	signed, err := auth.GenerateTenantToken(middleware.GetTenantID(r), jwt.MapClaims{
+		"userId":  user.ID.Hex(),
+		"purpose": models.TokenPurposeMagicLink,
+		"nonce":   nonce,
+	}, magicLinkTTL)
+	if err != nil {
+		return "", err
+	}
+	return siteURL(r) + "/blog/editor/magic-link?token=" + url.QueryEscape(signed), nil
+}
+
+type VerifyMagicLinkRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// VerifyMagicLink signs the user in with a token from RequestMagicLink. It's a POST
+// from the landing page rather than the link itself, so mail scanners that open links
+// don't use the token up.
+func VerifyMagicLink(w http.ResponseWriter, r *http.Request) {
+	if !middleware.GetTenantConfig(r).HasFeature(middleware.FeatureMagicLink) {
+		http.Error(w, "Sign-in links are not enabled for this site", http.StatusNotFound)
+		return
+	}
+
+	var req VerifyMagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if loginThrottled(w, r, "") {
+		return
+	}
+
+	invalid := func() {
+		countLoginAttempt(r, "", nil)
+		http.Error(w, "This sign-in link is invalid or has expired", http.StatusUnauthorized)
+	}
+	claims, err := auth.ValidateTenantToken(req.Token, middleware.GetTenantID(r))
+	if err != nil {
+		invalid()
+		return
+	}
+	if purpose, _ := claims["purpose"].(string); purpose != models.TokenPurposeMagicLink {
+		invalid()
+		return
+	}
+	nonce, _ := claims["nonce"].(string)
+	token, err := consumeAuthToken(r, models.TokenPurposeMagicLink, nonce)
+	if err != nil {
+		invalid()
+		return
+	}
+	if userHex, _ := claims["userId"].(string); userHex != token.UserID.Hex() {
+		invalid()
+		return
+	}
+
+	var user models.User
+	users := database.GetCollectionFromRequest(r, "users")
+	if err := users.FindOne(context.Background(), bson.M{"_id": token.UserID}).Decode(&user); err != nil {
+		invalid()
+		return
+	}
+	if !user.Approved {
+		http.Error(w, "Account pending approval - please contact admin", http.StatusForbidden)
+		return
+	}
+	// The link is checked against the account's limits too, like a password sign-in
+	if loginThrottled(w, r, user.Email) {
+		return
+	}
+
+	// Following the emailed link proves the address belongs to the user
+	if !user.EmailVerified {
+		now := time.Now()
+		users.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+			"emailVerified":   true,
+			"emailVerifiedAt": now,
+			"updatedAt":       now,
+		}})
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+	}
+
+	clearLoginFailures(r, user.Email)
+	recordSecurityEvent(r, models.EventLoginSucceeded, user.Email, &user.ID, "magic-link")
+
+	// Users with a second factor still have to complete it
+	completeLogin(w, r, &user)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// testTenant is the site requests are served as: the default site, as no sites config
+// is loaded in tests
+const testTenant = "default"
+
+// runWithMockDB runs fn against a mock MongoDB deployment. Each database call takes the
+// next queued response, so tests queue one per query the code under test makes.
+func runWithMockDB(t *testing.T, name string, fn func(mt *mtest.T)) {
+	t.Helper()
+	auth.SetKeyStore(auth.NewMemoryKeyStore())
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run(name, func(mt *mtest.T) {
+		database.UseClient(mt.Client, "test")
+		fn(mt)
+	})
+}
+
+// toDoc converts a model to the document a query would return
+func toDoc(t testing.TB, v interface{}) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// queueFound queues the documents as the result of the next find
+func queueFound(mt *mtest.T, docs ...interface{}) {
+	batch := make([]bson.D, len(docs))
+	for i, doc := range docs {
+		batch[i] = toDoc(mt, doc)
+	}
+	mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.collection", mtest.FirstBatch, batch...))
+}
+
+// queueWrite queues a successful write acknowledging n documents
+func queueWrite(mt *mtest.T, n int) {
+	mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n}))
+}
+
+// signIn returns the cookie of a signed-in session for the user and queues the session
+// and user lookups the auth middleware makes with it
+func signIn(mt *mtest.T, user models.User) *http.Cookie {
+	sessionID := primitive.NewObjectID()
+	token, err := auth.GenerateTenantToken(testTenant, jwt.MapClaims{
+		"userId": user.ID.Hex(),
+		"email":  user.Email,
+		"role":   user.Role,
+		"sid":    sessionID.Hex(),
+	}, time.Minute)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	queueFound(mt, models.Session{ID: sessionID, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	queueFound(mt, user)
+	return &http.Cookie{Name: accessTokenCookie, Value: token}
+}
+
+func testUser(role string) models.User {
+	return models.User{
+		ID:            primitive.NewObjectID(),
+		Name:          role,
+		Email:         role + "@example.com",
+		Role:          role,
+		Approved:      true,
+		EmailVerified: true,
+	}
+}
+
+// testRouter mounts the handlers under test behind the same middleware as the server
+func testRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(middleware.TenantMiddleware)
+	api := router.PathPrefix("/api").Subrouter()
+	api.Handle("/posts", middleware.OptionalAuthMiddleware(http.HandlerFunc(GetPosts))).Methods("GET")
+	api.Handle("/categories/tree", middleware.OptionalAuthMiddleware(http.HandlerFunc(GetCategoryTree))).Methods("GET")
+	api.HandleFunc("/auth/login", Login).Methods("POST")
+	api.HandleFunc("/auth/login/2fa", VerifyTwoFactorLogin).Methods("POST")
+	api.HandleFunc("/auth/refresh", RefreshSession).Methods("POST")
+
+	api.HandleFunc("/auth/sso", GetSSOTicket).Methods("GET")
+	api.HandleFunc("/auth/sso", RedeemSSOTicket).Methods("POST")
+
+	protected := api.PathPrefix("").Subrouter()
+	protected.Use(middleware.AuthMiddleware)
+	protected.HandleFunc("/auth/me", GetMe).Methods("GET")
+	account := protected.PathPrefix("").Subrouter()
+	account.Use(middleware.RequireSession)
+	account.HandleFunc("/auth/sessions", GetSessions).Methods("GET")
+	credentials := account.PathPrefix("").Subrouter()
+	credentials.Use(middleware.BlockImpersonation)
+	credentials.HandleFunc("/users/{id}", UpdateUser).Methods("PUT")
+
+	drafts := protected.PathPrefix("").Subrouter()
+	drafts.Use(middleware.RequirePermission(models.PermViewDrafts))
+	drafts.HandleFunc("/posts/id/{id}", GetPostByID).Methods("GET")
+
+	writing := protected.PathPrefix("").Subrouter()
+	writing.Use(middleware.RequirePermission(models.PermWritePosts))
+	writing.HandleFunc("/posts/{id}", UpdatePost).Methods("PUT")
+
+	adminUsers := protected.PathPrefix("/admin").Subrouter()
+	adminUsers.Use(middleware.RequirePermission(models.PermManageUsers))
+	adminUsers.HandleFunc("/users/{id}/impersonate", StartImpersonation).Methods("POST")
+
+	categories := protected.PathPrefix("").Subrouter()
+	categories.Use(middleware.RequirePermission(models.PermManageCategories))
+	categories.HandleFunc("/categories/merge", MergeCategories).Methods("POST")
+	categories.HandleFunc("/categories/{id}", DeleteCategory).Methods("DELETE")
+	return router
+}
+
+// serve sends the request through the test router
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	testRouter().ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/ogimage"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GetPostOGImage serves the Open Graph image for a post. Posts with an OG image
+// override or a cover image are redirected there; otherwise a branded image is
+// generated from the title and tenant theme and cached under the tenant's uploads.
+func GetPostOGImage(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	var post models.Post
+	err := database.GetCollectionFromRequest(r, "posts").FindOne(context.Background(), bson.M{"slug": slug, "published": true}).Decode(&post)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch post", http.StatusInternalServerError)
+		return
+	}
+
+	if post.OGImage != "" {
+		http.Redirect(w, r, post.OGImage, http.StatusFound)
+		return
+	}
+	if post.CoverImage != "" {
+		http.Redirect(w, r, post.CoverImage, http.StatusFound)
+		return
+	}
+
+	path, err := generatedOGImagePath(r, &post)
+	if err != nil {
+		log.Printf("Failed to generate OG image for %s: %v", post.Slug, err)
+		http.Error(w, "Failed to generate image", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, path)
+}
+
+// resolveOGImageURL returns the image social networks should show for a post
+func resolveOGImageURL(post *models.Post) string {
+	if post.OGImage != "" {
+		return post.OGImage
+	}
+	if post.CoverImage != "" {
+		return post.CoverImage
+	}
+	return "/api/posts/" + post.Slug + "/og-image"
+}
+
+// generatedOGImagePath renders the post's image if it isn't cached yet. The file name
+// includes a hash of everything drawn, so title or theme changes produce a new image.
+func generatedOGImagePath(r *http.Request, post *models.Post) (string, error) {
+	config := middleware.GetTenantConfig(r)
+	siteName := config.Name
+	if siteName == "" {
+		siteName = middleware.GetTenantDomain(r)
+	}
+
+	sum := sha256.Sum256([]byte(post.Title + "\x00" + siteName + "\x00" + config.Theme))
+	dir := filepath.Join(getUploadDir(), middleware.GetTenantID(r), "og")
+	path := filepath.Join(dir, post.ID.Hex()+"-"+hex.EncodeToString(sum[:6])+".png")
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	data, err := ogimage.Render(post.Title, siteName, ogimage.ThemeFor(config.Theme))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Write to a temp file first so concurrent requests never serve a partial image
+	tmp, err := os.CreateTemp(dir, ".og-*.png")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/oidc"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// oidcLoginTTL is how long the user has to finish signing in at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcBindingCookie ties a login started in one browser to that browser, so an attacker
+// can't send someone a callback link that signs them in to the attacker's account
+const oidcBindingCookie = "oidc-binding"
+
+// oidcLogin is a login in progress at a provider
+type oidcLogin struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Provider    string             `bson:"provider"`
+	StateHash   string             `bson:"stateHash"`
+	BindingHash string             `bson:"bindingHash"`
+	Nonce       string             `bson:"nonce"`
+	Verifier    string             `bson:"verifier"`
+	RedirectURI string             `bson:"redirectUri"`
+	ExpiresAt   time.Time          `bson:"expiresAt"`
+}
+
+// tenantOIDCProvider finds a login provider configured for the request's site
+func tenantOIDCProvider(r *http.Request, id string) (*oidc.Provider, error) {
+	for _, cfg := range middleware.GetTenantConfig(r).OIDC {
+		if cfg.ID == id {
+			return oidc.Get(r.Context(), middleware.GetTenantID(r), cfg)
+		}
+	}
+	return nil, oidc.ErrNotConfigured
+}
+
+// oidcRedirectURI is the page on the site the provider sends the user back to. It
+// posts the code and state to OIDCCallback.
+func oidcRedirectURI(r *http.Request, provider string) string {
+	return siteURL(r) + "/auth/callback/" + provider
+}
+
+// GetOIDCProviders lists the login providers configured for the site
+func GetOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []map[string]string{}
+	tenantID := middleware.GetTenantID(r)
+	for _, cfg := range middleware.GetTenantConfig(r).OIDC {
+		cfg = cfg.Resolve(tenantID)
+		if cfg.ClientID == "" {
+			continue
+		}
+		providers = append(providers, map[string]string{"id": cfg.ID, "name": cfg.Name})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
+}
+
+// StartOIDCLogin begins a login at a provider and returns the URL to send the browser to
+func StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	providerID := mux.Vars(r)["provider"]
+	provider, err := tenantOIDCProvider(r, providerID)
+	if err != nil {
+		if !errors.Is(err, oidc.ErrNotConfigured) {
+			log.Printf("OIDC provider %s unavailable: %v", providerID, err)
+		}
+		http.Error(w, "Login provider not available", http.StatusNotFound)
+		return
+	}
+
+	var secrets [4]string // state, nonce, PKCE verifier, browser binding
+	for i := range secrets {
+		if secrets[i], err = oidc.NewVerifier(); err != nil {
+			http.Error(w, "Failed to start login", http.StatusInternalServerError)
+			return
+		}
+	}
+	state, nonce, verifier, binding := secrets[0], secrets[1], secrets[2], secrets[3]
+
+	collection := database.GetCollectionFromRequest(r, "oidc_logins")
+	now := time.Now()
+	collection.DeleteMany(context.Background(), bson.M{"expiresAt": bson.M{"$lt": now}})
+	login := oidcLogin{
+		ID:          primitive.NewObjectID(),
+		Provider:    providerID,
+		StateHash:   auth.HashToken(state),
+		BindingHash: auth.HashToken(binding),
+		Nonce:       nonce,
+		Verifier:    verifier,
+		RedirectURI: oidcRedirectURI(r, providerID),
+		ExpiresAt:   now.Add(oidcLoginTTL),
+	}
+	if _, err := collection.InsertOne(context.Background(), login); err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		Path:     "/api/auth/oidc",
+		HttpOnly: true,
+		Secure:   os.Getenv("NODE_ENV") == "production",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"authorizationUrl": provider.AuthCodeURL(login.RedirectURI, state, nonce, verifier),
+	})
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+// OIDCCallback finishes a provider login. The provider's user is matched by their linked
+// identity, then by verified email; otherwise a new account is created and, like a
+// registration, waits for admin approval.
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	providerID := mux.Vars(r)["provider"]
+
+	var req OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.State == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// The state can only be used once, from the browser that started the login
+	var login oidcLogin
+	err := database.GetCollectionFromRequest(r, "oidc_logins").FindOneAndDelete(context.Background(), bson.M{
+		"stateHash": auth.HashToken(req.State),
+		"provider":  providerID,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&login)
+	if err != nil {
+		http.Error(w, "This login has expired - please try again", http.StatusBadRequest)
+		return
+	}
+	binding, err := r.Cookie(oidcBindingCookie)
+	if err != nil || auth.HashToken(binding.Value) != login.BindingHash {
+		http.Error(w, "This login was started in a different browser - please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcBindingCookie, Path: "/api/auth/oidc", MaxAge: -1})
+
+	provider, err := tenantOIDCProvider(r, providerID)
+	if err != nil {
+		http.Error(w, "Login provider not available", http.StatusNotFound)
+		return
+	}
+	identity, err := provider.Exchange(r.Context(), login.RedirectURI, req.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", providerID, err)
+		http.Error(w, "Sign-in with "+provider.Name+" failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, created, status, message := userForIdentity(r, provider.Name, identity)
+	if user == nil {
+		http.Error(w, message, status)
+		return
+	}
+	if created {
+		http.Error(w, "Your account has been created and is waiting for admin approval", http.StatusForbidden)
+		return
+	}
+	if !user.Approved {
+		http.Error(w, "Account pending approval - please contact admin", http.StatusForbidden)
+		return
+	}
+	if accountLocked(w, r, user, providerID) {
+		return
+	}
+
+	completeLogin(w, r, user)
+}
+
+// userForIdentity finds or creates the user a provider identity belongs to. On failure
+// it returns a nil user with the status and message to respond with.
+func userForIdentity(r *http.Request, providerName string, identity *oidc.Identity) (*models.User, bool, int, string) {
+	users := database.GetCollectionFromRequest(r, "users")
+
+	var user models.User
+	err := users.FindOne(context.Background(), bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": identity.Provider, "subject": identity.Subject}},
+	}).Decode(&user)
+	if err == nil {
+		return &user, false, 0, ""
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, http.StatusInternalServerError, "Database error"
+	}
+
+	email := strings.TrimSpace(identity.Email)
+	if email == "" || !identity.EmailVerified {
+		return nil, false, http.StatusForbidden, "Your " + providerName + " account doesn't have a verified email address"
+	}
+
+	now := time.Now()
+	link := models.ExternalIdentity{Provider: identity.Provider, Subject: identity.Subject, Email: email, LinkedAt: now}
+
+	// Link to an existing account with the same address. The account's own address must be
+	// verified too, or whoever registered it first could take over the provider login.
+	err = users.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		if !user.EmailVerified {
+			return nil, false, http.StatusConflict,
+				"An account with this email exists but hasn't been verified. Verify it or reset its password, then try again."
+		}
+		_, err := users.UpdateOne(context.Background(),
+			bson.M{"_id": user.ID},
+			bson.M{"$push": bson.M{"identities": link}, "$set": bson.M{"updatedAt": now}},
+		)
+		if err != nil {
+			return nil, false, http.StatusInternalServerError, "Failed to link account"
+		}
+		user.Identities = append(user.Identities, link)
+		return &user, false, 0, ""
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, false, http.StatusInternalServerError, "Database error"
+	}
+
+	// New users have no password; they can set one with the reset flow
+	name := strings.TrimSpace(identity.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	user = models.User{
+		ID:              primitive.NewObjectID(),
+		Name:            name,
+		Email:           email,
+		Role:            models.RoleAuthor,
+		Approved:        false, // Require admin approval
+		EmailVerified:   true,  // The provider verified it
+		EmailVerifiedAt: &now,
+		Identities:      []models.ExternalIdentity{link},
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if _, err := users.InsertOne(context.Background(), user); err != nil {
+		return nil, false, http.StatusInternalServerError, "Failed to create user"
+	}
+	return &user, true, 0, ""
+}
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/passkey"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ceremony purposes; a challenge issued for one can't be answered for another
+const (
+	ceremonyRegister     = "register"
+	ceremonyLogin        = "login"
+	ceremonySecondFactor = "2fa"
+)
+
+// webauthnCeremony is the server half of a registration or login in progress
+type webauthnCeremony struct {
+	ID        primitive.ObjectID   `bson:"_id"`
+	Purpose   string               `bson:"purpose"`
+	UserID    primitive.ObjectID   `bson:"userId,omitempty"`
+	Session   webauthn.SessionData `bson:"session"`
+	ExpiresAt time.Time            `bson:"expiresAt"`
+}
+
+// relyingParty returns the WebAuthn relying party for the request's tenant
+func relyingParty(r *http.Request) (*webauthn.WebAuthn, error) {
+	return passkey.New(middleware.GetTenantDomain(r), middleware.GetTenantConfig(r).Name, r.Header.Get("Origin"))
+}
+
+func loadPasskeys(r *http.Request, userID primitive.ObjectID) ([]models.Passkey, error) {
+	cursor, err := database.GetCollectionFromRequest(r, "passkeys").Find(context.Background(), bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	passkeys := []models.Passkey{}
+	if err := cursor.All(context.Background(), &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func countPasskeys(r *http.Request, userID primitive.ObjectID) int64 {
+	count, err := database.GetCollectionFromRequest(r, "passkeys").CountDocuments(context.Background(), bson.M{"userId": userID})
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+func saveCeremony(r *http.Request, purpose string, userID primitive.ObjectID, session *webauthn.SessionData) (string, error) {
+	ceremony := webauthnCeremony{
+		ID:        primitive.NewObjectID(),
+		Purpose:   purpose,
+		UserID:    userID,
+		Session:   *session,
+		ExpiresAt: time.Now().Add(passkey.CeremonyTimeout),
+	}
+	collection := database.GetCollectionFromRequest(r, "webauthn_ceremonies")
+	if _, err := collection.InsertOne(context.Background(), ceremony); err != nil {
+		return "", err
+	}
+	// Abandoned ceremonies are cleared out as new ones start
+	collection.DeleteMany(context.Background(), bson.M{"expiresAt": bson.M{"$lt": time.Now()}})
+	return ceremony.ID.Hex(), nil
+}
+
+// takeCeremony loads and deletes a ceremony so each challenge can be answered once
+func takeCeremony(r *http.Request, id, purpose string) (*webauthnCeremony, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("invalid ceremony ID")
+	}
+	var ceremony webauthnCeremony
+	err = database.GetCollectionFromRequest(r, "webauthn_ceremonies").FindOneAndDelete(
+		context.Background(),
+		bson.M{"_id": objectID, "purpose": purpose},
+	).Decode(&ceremony)
+	if err != nil {
+		return nil, errors.New("unknown ceremony")
+	}
+	if time.Now().After(ceremony.ExpiresAt) {
+		return nil, errors.New("ceremony expired")
+	}
+	return &ceremony, nil
+}
+
+// markPasskeyUsed stores the new signature counter after a successful assertion
+func markPasskeyUsed(r *http.Request, credential *webauthn.Credential) {
+	_, err := database.GetCollectionFromRequest(r, "passkeys").UpdateOne(
+		context.Background(),
+		bson.M{"credentialId": credential.ID},
+		bson.M{"$set": bson.M{
+			"signCount":   credential.Authenticator.SignCount,
+			"backupState": credential.Flags.BackupState,
+			"lastUsedAt":  time.Now(),
+		}},
+	)
+	if err != nil {
+		log.Printf("Failed to update passkey usage: %v", err)
+	}
+}
+
+type passkeyFinishRequest struct {
+	CeremonyID     string          `json:"ceremonyId"`
+	ChallengeToken string          `json:"challengeToken"`
+	Name           string          `json:"name"`
+	Credential     json.RawMessage `json:"credential"`
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create()
+func BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rp, err := relyingParty(r)
+	if err != nil {
+		log.Printf("WebAuthn configuration error: %v", err)
+		http.Error(w, "Passkeys are not available on this site", http.StatusInternalServerError)
+		return
+	}
+	passkeys, err := loadPasskeys(r, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	webUser := passkey.User{User: user, Passkeys: passkeys}
+	creation, session, err := rp.BeginRegistration(webUser, webauthn.WithExclusions(webUser.Exclusions()))
+	if err != nil {
+		http.Error(w, "Failed to start registration", http.StatusInternalServerError)
+		return
+	}
+	ceremonyID, err := saveCeremony(r, ceremonyRegister, user.ID, session)
+	if err != nil {
+		http.Error(w, "Failed to start registration", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ceremonyId": ceremonyID,
+		"options":    creation,
+	})
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores the passkey
+func FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	var req passkeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ceremony, err := takeCeremony(r, req.CeremonyID, ceremonyRegister)
+	if err != nil || ceremony.UserID != user.ID {
+		http.Error(w, "Registration expired - please try again", http.StatusBadRequest)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		http.Error(w, "Invalid credential", http.StatusBadRequest)
+		return
+	}
+	rp, err := relyingParty(r)
+	if err != nil {
+		http.Error(w, "Passkeys are not available on this site", http.StatusInternalServerError)
+		return
+	}
+	passkeys, err := loadPasskeys(r, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+		return
+	}
+	credential, err := rp.CreateCredential(passkey.User{User: user, Passkeys: passkeys}, ceremony.Session, parsed)
+	if err != nil {
+		log.Printf("Passkey registration failed for user %s: %v", user.ID.Hex(), err)
+		http.Error(w, "Passkey verification failed", http.StatusBadRequest)
+		return
+	}
+
+	record := passkey.FromCredential(credential)
+	record.ID = primitive.NewObjectID()
+	record.UserID = user.ID
+	record.Name = strings.TrimSpace(req.Name)
+	if record.Name == "" {
+		record.Name = "Passkey"
+	}
+	record.CreatedAt = time.Now()
+
+	collection := database.GetCollectionFromRequest(r, "passkeys")
+	if count, _ := collection.CountDocuments(context.Background(), bson.M{"credentialId": record.CredentialID}); count > 0 {
+		http.Error(w, "This passkey is already registered", http.StatusConflict)
+		return
+	}
+	if _, err := collection.InsertOne(context.Background(), record); err != nil {
+		http.Error(w, "Failed to save passkey", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(record)
+}
+
+// GetPasskeys lists the current user's passkeys
+func GetPasskeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	passkeys, err := loadPasskeys(r, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passkeys)
+}
+
+// DeletePasskey removes one of the current user's passkeys
+func DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	// Admins on sites that require 2FA must keep at least one second factor
+	if user.Role == "admin" && middleware.GetTenantConfig(r).HasFeature(middleware.FeatureAdmin2FA) &&
+		!user.HasTwoFactor() && countPasskeys(r, user.ID) <= 1 {
+		http.Error(w, "Two-factor authentication is required for admins on this site", http.StatusForbidden)
+		return
+	}
+
+	result, err := database.GetCollectionFromRequest(r, "passkeys").DeleteOne(context.Background(), bson.M{"_id": id, "userId": user.ID})
+	if err != nil {
+		http.Error(w, "Failed to delete passkey", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Passkey deleted successfully",
+	})
+}
+
+// BeginPasskeyLogin starts a passwordless login. No email is needed: the browser
+// offers the passkeys it holds for this site.
+func BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if database.GetDB() == nil {
+		http.Error(w, "Database unavailable - please contact support", http.StatusServiceUnavailable)
+		return
+	}
+
+	rp, err := relyingParty(r)
+	if err != nil {
+		http.Error(w, "Passkeys are not available on this site", http.StatusInternalServerError)
+		return
+	}
+	// The passkey replaces both password and second factor, so the authenticator must verify the user
+	assertion, session, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	ceremonyID, err := saveCeremony(r, ceremonyLogin, primitive.NilObjectID, session)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ceremonyId": ceremonyID,
+		"options":    assertion,
+	})
+}
+
+// FinishPasskeyLogin verifies a passwordless assertion and starts a session
+func FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req passkeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ceremony, err := takeCeremony(r, req.CeremonyID, ceremonyLogin)
+	if err != nil {
+		http.Error(w, "Login expired - please try again", http.StatusBadRequest)
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		http.Error(w, "Invalid credential", http.StatusBadRequest)
+		return
+	}
+	rp, err := relyingParty(r)
+	if err != nil {
+		http.Error(w, "Passkeys are not available on this site", http.StatusInternalServerError)
+		return
+	}
+
+	var user *models.User
+	credential, err := rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != len(primitive.ObjectID{}) {
+			return nil, errors.New("unknown user handle")
+		}
+		var userID primitive.ObjectID
+		copy(userID[:], userHandle)
+
+		var found models.User
+		if err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&found); err != nil {
+			return nil, err
+		}
+		passkeys, err := loadPasskeys(r, userID)
+		if err != nil {
+			return nil, err
+		}
+		user = &found
+		return passkey.User{User: user, Passkeys: passkeys}, nil
+	}, ceremony.Session, parsed)
+	if err != nil || user == nil {
+		http.Error(w, "Passkey not recognised", http.StatusUnauthorized)
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		log.Printf("Passkey sign counter went backwards for user %s, refusing login", user.ID.Hex())
+		http.Error(w, "Passkey not recognised", http.StatusUnauthorized)
+		return
+	}
+	if !user.Approved {
+		http.Error(w, "Account pending approval - please contact admin", http.StatusForbidden)
+		return
+	}
+	if accountLocked(w, r, user, "passkey") {
+		return
+	}
+
+	markPasskeyUsed(r, credential)
+	respondWithSession(w, r, user)
+}
+
+// BeginPasskeySecondFactor starts a passkey check for a password login that returned
+// a 2FA challenge
+func BeginPasskeySecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req passkeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := userFromChallenge(r, req.ChallengeToken)
+	if !ok {
+		http.Error(w, "Login challenge expired - please sign in again", http.StatusUnauthorized)
+		return
+	}
+	passkeys, err := loadPasskeys(r, user.ID)
+	if err != nil || len(passkeys) == 0 {
+		http.Error(w, "No passkeys registered", http.StatusBadRequest)
+		return
+	}
+
+	rp, err := relyingParty(r)
+	if err != nil {
+		http.Error(w, "Passkeys are not available on this site", http.StatusInternalServerError)
+		return
+	}
+	assertion, session, err := rp.BeginLogin(passkey.User{User: user, Passkeys: passkeys})
+	if err != nil {
+		http.Error(w, "Failed to start verification", http.StatusInternalServerError)
+		return
+	}
+	ceremonyID, err := saveCeremony(r, ceremonySecondFactor, user.ID, session)
+	if err != nil {
+		http.Error(w, "Failed to start verification", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ceremonyId": ceremonyID,
+		"options":    assertion,
+	})
+}
+
+// FinishPasskeySecondFactor completes a password login with a passkey assertion
+func FinishPasskeySecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req passkeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := userFromChallenge(r, req.ChallengeToken)
+	if !ok {
+		http.Error(w, "Login challenge expired - please sign in again", http.StatusUnauthorized)
+		return
+	}
+	ceremony, err := takeCeremony(r, req.CeremonyID, ceremonySecondFactor)
+	if err != nil || ceremony.UserID != user.ID {
+		http.Error(w, "Verification expired - please try again", http.StatusBadRequest)
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		http.Error(w, "Invalid credential", http.StatusBadRequest)
+		return
+	}
+	rp, err := relyingParty(r)
+	if err != nil {
+		http.Error(w, "Passkeys are not available on this site", http.StatusInternalServerError)
+		return
+	}
+	passkeys, err := loadPasskeys(r, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := rp.ValidateLogin(passkey.User{User: user, Passkeys: passkeys}, ceremony.Session, parsed)
+	if err != nil || credential.Authenticator.CloneWarning {
+		http.Error(w, "Passkey verification failed", http.StatusUnauthorized)
+		return
+	}
+
+	markPasskeyUsed(r, credential)
+	respondWithSession(w, r, user)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/identity"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/passwords"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// checkPasswordPolicy writes a 400 and returns false if the password breaks the site's
+// password policy. The site's name and domains and the user's name and email can't
+// appear in it.
+func checkPasswordPolicy(w http.ResponseWriter, r *http.Request, password, name, email string) bool {
+	config := middleware.GetTenantConfig(r)
+	words := append([]string{config.ID, config.Name, name, email}, middleware.GetDomainsForDatabase(config.Database)...)
+	if err := config.PasswordPolicy.Check(password, words...); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// GetPasswordPolicy describes the site's password rules, so forms can explain them
+// before the user submits
+func GetPasswordPolicy(w http.ResponseWriter, r *http.Request) {
+	policy := middleware.GetTenantConfig(r).PasswordPolicy
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"minLength":      policy.MinimumLength(),
+		"requireUpper":   policy.RequireUpper,
+		"requireLower":   policy.RequireLower,
+		"requireDigit":   policy.RequireDigit,
+		"requireSymbol":  policy.RequireSymbol,
+		"checksBreached": !policy.AllowBreached,
+	})
+}
+
+// upgradePasswordHash rehashes a user's password, whose hash is current, at the current
+// bcrypt cost after they sign in with it, if it was hashed at a lower one
+func upgradePasswordHash(r *http.Request, user *models.User, current, password string) {
+	if !passwords.NeedsRehash(current) {
+		return
+	}
+	hash, err := passwords.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	// Matching the old hash leaves alone a password changed in the meantime
+	if user.IdentityID != nil {
+		err = identity.SetPassword(context.Background(), *user.IdentityID, hash, current)
+		if err == identity.ErrNotFound {
+			return
+		}
+	} else {
+		_, err = database.GetCollectionFromRequest(r, "users").UpdateOne(context.Background(),
+			bson.M{"_id": user.ID, "password": current},
+			bson.M{"$set": bson.M{"password": hash}},
+		)
+	}
+	if err != nil {
+		log.Printf("Failed to store rehashed password of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	if user.IdentityID == nil {
+		user.Password = hash
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/mailer"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/passwords"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// passwordResetTTL is how long a reset link works
+const passwordResetTTL = time.Hour
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ForgotPassword emails a reset link. The response is the same whether or not the
+// address has an account.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+	if database.GetDB() == nil {
+		http.Error(w, "Database unavailable - please contact support", http.StatusServiceUnavailable)
+		return
+	}
+
+	var user models.User
+	err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		token, err := createAuthToken(r, models.TokenPurposePasswordReset, user.ID, user.Email, passwordResetTTL)
+		if err != nil {
+			log.Printf("Failed to create password reset token: %v", err)
+		} else {
+			sendMailAsync(r, user.Email, mailer.TemplatePasswordReset, map[string]interface{}{
+				"Name":      user.Name,
+				"Link":      siteURL(r) + "/blog/editor/reset-password?token=" + url.QueryEscape(token),
+				"ExpiresIn": formatTTL(passwordResetTTL),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for that email, a reset link is on its way.",
+	})
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required,min=8"`
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and signs the
+// user out everywhere
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := consumeAuthToken(r, models.TokenPurposePasswordReset, req.Token)
+	if err != nil {
+		http.Error(w, "This reset link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	account := findUser(r, token.UserID)
+	if account == nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	// A rejected password leaves the link usable for another try
+	if !checkPasswordPolicy(w, r, req.NewPassword, account.Name, account.Email) {
+		releaseAuthToken(r, token)
+		return
+	}
+
+	hash, err := passwords.Hash(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	// Following the emailed link also proves the address belongs to the user. A linked
+	// user's new password applies on every site sharing their identity.
+	if err := storePassword(r, account, hash, bson.M{"emailVerified": true}); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := revokeSessions(r, bson.M{"userId": token.UserID}, "password reset"); err != nil {
+		log.Printf("Failed to revoke sessions after password reset: %v", err)
+	}
+	revokeIdentitySessions(r, account, "password reset")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password has been reset. You can now sign in.",
+	})
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -20,10 +22,9 @@ import (
 func GetPosts(w http.ResponseWriter, r *http.Request) {
 	query := bson.M{}
 	
-	// Check if user is authenticated
-	_, authenticated := middleware.GetUserFromContext(r)
-	if !authenticated {
-		// Only show published posts for unauthenticated users
+	// Only show drafts to signed-in users whose role allows it
+	user, authenticated := middleware.GetUserFromContext(r)
+	if !authenticated || !user.Can(models.PermViewDrafts) {
 		query["published"] = true
 	}
 
@@ -48,8 +49,19 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 		}
 		
 		if err == nil {
-			// Use string format for category ID (data is stored as string not ObjectID)
-			query["category"] = category.ID.Hex()
+			categoryIDs := []primitive.ObjectID{category.ID}
+
+			// Optionally include posts from every subcategory
+			if r.URL.Query().Get("includeDescendants") == "true" {
+				if all, err := loadCategories(r, bson.M{}); err == nil {
+					categoryIDs = append(categoryIDs, models.CategoryDescendants(all, category.ID)...)
+				} else {
+					log.Printf("Failed to load subcategories for %s: %v", category.Slug, err)
+				}
+			}
+
+			// Match both stored representations (hex string and ObjectID)
+			query["category"] = bson.M{"$in": categoryMatchValues(categoryIDs...)}
 			log.Printf("Category found: id=%s, slug=%s, type=%s", category.ID.Hex(), category.Slug, category.Type)
 			log.Printf("Query being used: %+v", query)
 		} else {
@@ -58,6 +70,19 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Filter by author, for author pages
+	if authorSlug := r.URL.Query().Get("author"); authorSlug != "" {
+		var author models.User
+		err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(),
+			bson.M{"profile.slug": models.NormalizeSlug(authorSlug)}).Decode(&author)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode([]models.PostWithAuthor{})
+			return
+		}
+		query["author"] = author.ID
+	}
+
 	// Find posts
 	cursor, err := database.GetCollectionFromRequest(r, "posts").Find(
 		context.Background(),
@@ -85,6 +110,13 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 		posts = []models.Post{}
 	}
 
+	// Load categories once so breadcrumbs don't cost a query per post
+	allCategories, err := loadCategories(r, bson.M{})
+	if err != nil {
+		log.Printf("Error loading categories: %v", err)
+	}
+	categoryIndex := indexCategories(allCategories)
+
 	// Populate author and category data
 	var enrichedPosts []models.PostWithAuthor
 	for _, post := range posts {
@@ -93,14 +125,15 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 		// Get author
 		var author models.User
 		if err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": post.Author}).Decode(&author); err == nil {
-			enrichedPost.AuthorData = &author
+			enrichedPost.AuthorData = author.PublicProfile()
 		}
 
 		// Get category
-		var category models.Category
-		if err := database.GetCollectionFromRequest(r, "categories").FindOne(context.Background(), bson.M{"_id": post.Category}).Decode(&category); err == nil {
+		if category, ok := categoryIndex[post.Category]; ok {
 			enrichedPost.CategoryData = &category
+			enrichedPost.Breadcrumbs = models.CategoryBreadcrumbs(categoryIndex, post.Category)
 		}
+		enrichedPost.OGImageURL = resolveOGImageURL(&post)
 
 		enrichedPosts = append(enrichedPosts, enrichedPost)
 	}
@@ -134,13 +167,15 @@ func GetPostBySlug(w http.ResponseWriter, r *http.Request) {
 
 	var author models.User
 	if err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": post.Author}).Decode(&author); err == nil {
-		enrichedPost.AuthorData = &author
+		enrichedPost.AuthorData = author.PublicProfile()
 	}
 
 	var category models.Category
 	if err := database.GetCollectionFromRequest(r, "categories").FindOne(context.Background(), bson.M{"_id": post.Category}).Decode(&category); err == nil {
 		enrichedPost.CategoryData = &category
+		enrichedPost.Breadcrumbs = postBreadcrumbs(r, post.Category)
 	}
+	enrichedPost.OGImageURL = resolveOGImageURL(&post)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(enrichedPost)
@@ -177,13 +212,15 @@ func GetPostByID(w http.ResponseWriter, r *http.Request) {
 
 	var author models.User
 	if err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": post.Author}).Decode(&author); err == nil {
-		enrichedPost.AuthorData = &author
+		enrichedPost.AuthorData = author.PublicProfile()
 	}
 
 	var category models.Category
 	if err := database.GetCollectionFromRequest(r, "categories").FindOne(context.Background(), bson.M{"_id": post.Category}).Decode(&category); err == nil {
 		enrichedPost.CategoryData = &category
+		enrichedPost.Breadcrumbs = postBreadcrumbs(r, post.Category)
 	}
+	enrichedPost.OGImageURL = resolveOGImageURL(&post)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(enrichedPost)
@@ -215,12 +252,18 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 	
 	post.CalculateReadingTime()
 
+	if err := post.ValidateSEO(); err != nil {
+		http.Error(w, "Invalid SEO fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Insert post
 	_, err := database.GetCollectionFromRequest(r, "posts").InsertOne(context.Background(), post)
 	if err != nil {
 		http.Error(w, "Failed to create post", http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, models.AuditPostCreate, "post", post.ID.Hex(), nil, post)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(post)
@@ -234,6 +277,11 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, before, ok := loadEditablePost(w, r, id)
+	if !ok {
+		return
+	}
+
 	var updateData map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -242,6 +290,38 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 
 	// Update timestamp
 	updateData["updatedAt"] = time.Now()
+	delete(updateData, "_id")
+	delete(updateData, "id")
+
+	// Only editors and admins can hand a post to someone else
+	if authorValue, ok := updateData["author"]; ok {
+		authorHex, _ := authorValue.(string)
+		authorID, err := primitive.ObjectIDFromHex(authorHex)
+		switch {
+		case !user.Can(models.PermEditAnyPost):
+			delete(updateData, "author")
+		case err != nil:
+			http.Error(w, "Invalid author ID", http.StatusBadRequest)
+			return
+		default:
+			updateData["author"] = authorID
+		}
+	}
+
+	// Store the category as an ObjectID so it matches posts created via CreatePost
+	if categoryHex, ok := updateData["category"].(string); ok {
+		categoryID, err := primitive.ObjectIDFromHex(categoryHex)
+		if err != nil {
+			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			return
+		}
+		updateData["category"] = categoryID
+	}
+
+	if err := validateSEOUpdate(updateData); err != nil {
+		http.Error(w, "Invalid SEO fields: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Recalculate reading time if content changed
 	if content, ok := updateData["content"].(string); ok {
@@ -267,6 +347,11 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var after models.Post
+	if err := database.GetCollectionFromRequest(r, "posts").FindOne(context.Background(), bson.M{"_id": id}).Decode(&after); err == nil {
+		recordAudit(r, models.AuditPostUpdate, "post", id.Hex(), before, after)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Post updated successfully",
@@ -281,6 +366,11 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, post, ok := loadEditablePost(w, r, id)
+	if !ok {
+		return
+	}
+
 	result, err := database.GetCollectionFromRequest(r, "posts").DeleteOne(context.Background(), bson.M{"_id": id})
 	if err != nil {
 		http.Error(w, "Failed to delete post", http.StatusInternalServerError)
@@ -291,9 +381,84 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Post not found", http.StatusNotFound)
 		return
 	}
+	recordAudit(r, models.AuditPostDelete, "post", id.Hex(), post, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Post deleted successfully",
 	})
-}
\ No newline at end of file
+}
+
+// canEditPost reports whether the user may change or delete the post. Editors and
+// admins can edit any post; authors only their own.
+func canEditPost(user *models.User, post *models.Post) bool {
+	if user.Can(models.PermEditAnyPost) {
+		return true
+	}
+	return user.Can(models.PermWritePosts) && post.Author == user.ID
+}
+
+// loadEditablePost checks that the post exists and the current user may edit it,
+// writing the error response if not
+func loadEditablePost(w http.ResponseWriter, r *http.Request, id primitive.ObjectID) (*models.User, *models.Post, bool) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	var post models.Post
+	err := database.GetCollectionFromRequest(r, "posts").FindOne(context.Background(), bson.M{"_id": id}).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch post", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	if !canEditPost(user, &post) {
+		http.Error(w, "You can only edit your own posts", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return user, &post, true
+}
+
+// postBreadcrumbs returns the category trail for a single post
+func postBreadcrumbs(r *http.Request, categoryID primitive.ObjectID) []models.CategoryCrumb {
+	categories, err := loadCategories(r, bson.M{})
+	if err != nil {
+		log.Printf("Error loading categories for breadcrumbs: %v", err)
+		return nil
+	}
+	return models.CategoryBreadcrumbs(indexCategories(categories), categoryID)
+}
+
+// validateSEOUpdate checks any SEO fields present in a partial post update
+func validateSEOUpdate(updateData map[string]interface{}) error {
+	var seo models.Post
+	stringFields := map[string]*string{
+		"metaTitle":       &seo.MetaTitle,
+		"metaDescription": &seo.MetaDescription,
+		"canonicalUrl":    &seo.CanonicalURL,
+		"ogImage":         &seo.OGImage,
+	}
+	for key, target := range stringFields {
+		value, present := updateData[key]
+		if !present || value == nil {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", key)
+		}
+		*target = str
+	}
+	if value, present := updateData["noindex"]; present {
+		if _, ok := value.(bool); !ok {
+			return errors.New("noindex must be a boolean")
+		}
+	}
+	return seo.ValidateSEO()
+}
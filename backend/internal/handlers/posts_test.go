@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCanEditPost(t *testing.T) {
+	owner := primitive.NewObjectID()
+	cases := []struct {
+		role  string
+		owner bool
+		want  bool
+	}{
+		{models.RoleAuthor, true, true},
+		{models.RoleAuthor, false, false},
+		{models.RoleUser, false, false},
+		{models.RoleViewer, true, false}, // Viewers can't write, even their own posts
+		{models.RoleEditor, false, true},
+		{models.RoleAdmin, false, true},
+	}
+	for _, c := range cases {
+		user := &models.User{ID: primitive.NewObjectID(), Role: c.role}
+		if c.owner {
+			user.ID = owner
+		}
+		if got := canEditPost(user, &models.Post{Author: owner}); got != c.want {
+			t.Errorf("%s editing (own=%v) = %v, want %v", c.role, c.owner, got, c.want)
+		}
+	}
+}
+
+func TestAuthorCannotEditOthersPost(t *testing.T) {
+	runWithMockDB(t, "author", func(mt *mtest.T) {
+		author := testUser(models.RoleAuthor)
+		post := models.Post{ID: primitive.NewObjectID(), Title: "Not yours", Author: primitive.NewObjectID()}
+
+		r := httptest.NewRequest(http.MethodPut, "/api/posts/"+post.ID.Hex(), strings.NewReader(`{"title":"Mine now"}`))
+		r.AddCookie(signIn(mt, author))
+		queueFound(mt, post)
+
+		w := serve(r)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want 403: %s", w.Code, w.Body)
+		}
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "update" {
+				t.Errorf("post was updated: %s", event.Command)
+			}
+		}
+	})
+}
+
+func TestDraftsOnlyForRolesThatCanViewThem(t *testing.T) {
+	// postsFilter returns whether the posts query was limited to published posts
+	postsFilter := func(mt *mtest.T) bool {
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "find" && event.Command.Lookup("find").StringValue() == "posts" {
+				_, published := event.Command.Lookup("filter").Document().Lookup("published").BooleanOK()
+				return published
+			}
+		}
+		mt.Fatal("posts were not queried")
+		return false
+	}
+
+	runWithMockDB(t, "anonymous", func(mt *mtest.T) {
+		queueFound(mt) // posts
+		queueFound(mt) // categories
+		if w := serve(httptest.NewRequest(http.MethodGet, "/api/posts", nil)); w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if !postsFilter(mt) {
+			t.Error("anonymous visitors were shown drafts")
+		}
+	})
+
+	runWithMockDB(t, "viewer", func(mt *mtest.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/posts", nil)
+		r.AddCookie(signIn(mt, testUser(models.RoleViewer)))
+		queueFound(mt)
+		queueFound(mt)
+		if w := serve(r); w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if postsFilter(mt) {
+			t.Error("viewer was only shown published posts")
+		}
+	})
+
+	runWithMockDB(t, "viewer reads a draft", func(mt *mtest.T) {
+		draft := models.Post{ID: primitive.NewObjectID(), Title: "Draft", Author: primitive.NewObjectID()}
+		r := httptest.NewRequest(http.MethodGet, "/api/posts/id/"+draft.ID.Hex(), nil)
+		r.AddCookie(signIn(mt, testUser(models.RoleViewer)))
+		queueFound(mt, draft)
+		if w := serve(r); w.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+	})
+
+	runWithMockDB(t, "no role", func(mt *mtest.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/posts/id/"+primitive.NewObjectID().Hex(), nil)
+		r.AddCookie(signIn(mt, testUser("")))
+		if w := serve(r); w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403: %s", w.Code, w.Body)
+		}
+	})
+}
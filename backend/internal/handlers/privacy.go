@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/privacy"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExportMyData downloads a zip of everything the site stores about the signed-in user
+func ExportMyData(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	writeUserExport(w, r, user.ID)
+}
+
+// ExportUserData downloads the same zip for any user, for requests made to the admins
+func ExportUserData(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	writeUserExport(w, r, id)
+}
+
+func writeUserExport(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) {
+	// Built in memory so a failure halfway can still be reported properly
+	var buf bytes.Buffer
+	if err := privacy.Export(r.Context(), database.GetDBFromRequest(r), userID, &buf); err == privacy.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to export data of user %s: %v", userID.Hex(), err)
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, models.AuditUserExport, "user", userID.Hex(), nil, nil)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+middleware.GetTenantID(r)+`-data-`+time.Now().Format("2006-01-02")+`.zip"`)
+	w.Write(buf.Bytes())
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteMyAccount schedules the signed-in user's account for erasure after the site's
+// grace period, and signs out their other sessions. Users with a password must
+// confirm it.
+func DeleteMyAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req DeleteAccountRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if hasPassword(user) && checkPassword(user, req.Password) != nil {
+		http.Error(w, "Incorrect password", http.StatusUnauthorized)
+		return
+	}
+	if onlyAdmin(w, r, user) {
+		return
+	}
+
+	scheduledAt, err := scheduleDeletion(r, user, false)
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	if scheduledAt == nil {
+		clearAuthCookies(w)
+	} else if _, err := revokeSessions(r, bson.M{"userId": user.ID, "_id": bson.M{"$ne": middleware.GetSessionID(r)}}, "account deletion requested"); err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", user.ID.Hex(), err)
+	}
+	respondDeletion(w, scheduledAt)
+}
+
+// CancelMyDeletion keeps the signed-in user's account
+func CancelMyDeletion(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	cancelDeletion(w, r, user.ID)
+}
+
+type ScheduleDeletionRequest struct {
+	Immediate bool `json:"immediate"` // Skip the grace period
+}
+
+// ScheduleUserDeletion deletes another user's account, after the grace period or
+// straight away
+func ScheduleUserDeletion(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var req ScheduleDeletionRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if current, ok := middleware.GetUserFromContext(r); ok && current.ID == id {
+		http.Error(w, "Use your own account settings to delete your account", http.StatusBadRequest)
+		return
+	}
+	user := findUser(r, id)
+	if user == nil || user.DeletedAt != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if onlyAdmin(w, r, user) {
+		return
+	}
+
+	scheduledAt, err := scheduleDeletion(r, user, req.Immediate)
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	respondDeletion(w, scheduledAt)
+}
+
+// CancelUserDeletion keeps an account that is scheduled for deletion
+func CancelUserDeletion(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	cancelDeletion(w, r, id)
+}
+
+// onlyAdmin refuses, writing the response, to delete the site's last admin
+func onlyAdmin(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	if user.Role != models.RoleAdmin {
+		return false
+	}
+	others, err := database.GetCollectionFromRequest(r, "users").CountDocuments(context.Background(), bson.M{
+		"_id":                 bson.M{"$ne": user.ID},
+		"role":                models.RoleAdmin,
+		"deletedAt":           bson.M{"$exists": false},
+		"deletionScheduledAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return true
+	}
+	if others == 0 {
+		http.Error(w, "This is the site's only admin - make someone else an admin first", http.StatusConflict)
+		return true
+	}
+	return false
+}
+
+// scheduleDeletion sets when the user's account is erased, or erases it now if
+// immediate or the site has no grace period. It returns the scheduled time, or nil if
+// the account is already gone.
+func scheduleDeletion(r *http.Request, user *models.User, immediate bool) (*time.Time, error) {
+	policy := middleware.GetTenantConfig(r).AccountDeletion
+	grace := policy.GracePeriod()
+	if immediate || grace == 0 {
+		if err := privacy.Erase(r.Context(), database.GetDBFromRequest(r), user.ID, policy); err != nil {
+			log.Printf("Failed to erase user %s: %v", user.ID.Hex(), err)
+			return nil, err
+		}
+		recordAudit(r, models.AuditUserErase, "user", user.ID.Hex(), user, nil)
+		return nil, nil
+	}
+
+	now := time.Now()
+	scheduledAt := now.Add(grace)
+	_, err := database.GetCollectionFromRequest(r, "users").UpdateOne(context.Background(),
+		bson.M{"_id": user.ID, "deletedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletionScheduledAt": scheduledAt, "updatedAt": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	recordAudit(r, models.AuditUserDeletionRequest, "user", user.ID.Hex(),
+		map[string]interface{}{"deletionScheduledAt": nil},
+		map[string]interface{}{"deletionScheduledAt": scheduledAt})
+	return &scheduledAt, nil
+}
+
+func respondDeletion(w http.ResponseWriter, scheduledAt *time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	if scheduledAt == nil {
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Account deleted",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":             "Account scheduled for deletion - sign in and cancel before then to keep it",
+		"deletionScheduledAt": scheduledAt,
+	})
+}
+
+func cancelDeletion(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) {
+	result, err := database.GetCollectionFromRequest(r, "users").UpdateOne(context.Background(),
+		bson.M{"_id": userID, "deletionScheduledAt": bson.M{"$exists": true}, "deletedAt": bson.M{"$exists": false}},
+		bson.M{"$unset": bson.M{"deletionScheduledAt": ""}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to cancel deletion", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "No deletion is scheduled for this account", http.StatusNotFound)
+		return
+	}
+	recordAudit(r, models.AuditUserDeletionCancel, "user", userID.Hex(), nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Account deletion cancelled",
+	})
+}
+
+// StartAccountPurgeJob erases accounts whose deletion grace period has ended, for every
+// tenant on the given interval
+func StartAccountPurgeJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, dbName := range middleware.GetAllTenantDatabases() {
+				db := database.GetTenantDB(dbName)
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+				erased, err := privacy.PurgeDue(ctx, db, middleware.GetConfigForDatabase(dbName).AccountDeletion, time.Now())
+				if err != nil {
+					log.Printf("[%s] account purge failed: %v", dbName, err)
+				}
+				for _, id := range erased {
+					recordJobAudit(ctx, db, models.AuditUserErase, "user", id.Hex())
+				}
+				cancel()
+				if len(erased) > 0 {
+					log.Printf("[%s] erased %d accounts after their deletion grace period", dbName, len(erased))
+				}
+			}
+		}
+	}()
+}
+
+// recordJobAudit appends an audit entry for a change made by a background job, which
+// has no actor
+func recordJobAudit(ctx context.Context, db *mongo.Database, action, targetType, targetID string) {
+	entry := models.AuditEntry{
+		ID:         primitive.NewObjectID(),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		CreatedAt:  time.Now(),
+	}
+	if _, err := db.Collection("audit_log").InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s on %s %s: %v", action, targetType, targetID, err)
+	}
+}
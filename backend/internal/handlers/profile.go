@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/coders-website/backend/internal/avatar"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxAvatarUpload is the largest picture accepted for an avatar
+const maxAvatarUpload = 10 << 20
+
+// editableUserID reads the {id} route variable and checks the current user may edit
+// that user's profile: their own, or anyone's with PermManageUsers. It writes the error
+// response if not.
+func editableUserID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	current, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return primitive.NilObjectID, false
+	}
+	if current.ID != id && !current.Can(models.PermManageUsers) {
+		http.Error(w, "Unauthorized", http.StatusForbidden)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// ProfileUpdateRequest changes a user's public profile. Fields left out are kept; the
+// avatar has its own endpoints.
+type ProfileUpdateRequest struct {
+	Slug    *string           `json:"slug"`
+	Bio     *string           `json:"bio"`
+	Website *string           `json:"website"`
+	Social  map[string]string `json:"social"` // Replaces every handle when sent
+}
+
+// profileUpdate validates the request and adds its changes to the update's $set and
+// $unset, writing the error response if it is invalid
+func profileUpdate(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID, req *ProfileUpdateRequest, set, unset bson.M) bool {
+	if req.Slug != nil {
+		slug := models.NormalizeSlug(*req.Slug)
+		if slug == "" {
+			unset["profile.slug"] = ""
+		} else {
+			if err := models.ValidateSlug(slug); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return false
+			}
+			taken, err := database.GetCollectionFromRequest(r, "users").CountDocuments(context.Background(),
+				bson.M{"profile.slug": slug, "_id": bson.M{"$ne": userID}})
+			if err != nil {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return false
+			}
+			if taken > 0 {
+				http.Error(w, "That profile URL is already taken", http.StatusConflict)
+				return false
+			}
+			set["profile.slug"] = slug
+		}
+	}
+	if req.Bio != nil {
+		if err := models.ValidateBio(*req.Bio); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		set["profile.bio"] = *req.Bio
+	}
+	if req.Website != nil {
+		website, err := models.NormalizeWebsite(*req.Website)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		set["profile.website"] = website
+	}
+	if req.Social != nil {
+		handles, err := models.NormalizeSocialHandles(req.Social)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		set["profile.social"] = handles
+	}
+	return true
+}
+
+// UploadAvatar sets a user's profile picture. The uploaded image is cropped square,
+// to the optional x, y and size form fields or else around its centre, and stored at
+// each of models.AvatarSizes.
+func UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := editableUserID(w, r)
+	if !ok {
+		return
+	}
+	user := findUser(r, userID)
+	if user == nil || user.DeletedAt != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarUpload+1<<20)
+	if err := r.ParseMultipartForm(maxAvatarUpload); err != nil {
+		http.Error(w, "Failed to parse form - avatars can be up to 10 MB", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Failed to get file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	crop, err := avatarCrop(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rendered, err := avatar.Process(data, crop, models.AvatarSizes)
+	if errors.Is(err, avatar.ErrUnsupported) {
+		http.Error(w, "Avatars must be JPEG, PNG, GIF or WebP images", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, avatar.ErrTooLarge) {
+		http.Error(w, "Image dimensions are too large", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to process image", http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := storeAvatar(r, userID, header.Filename, rendered)
+	if err != nil {
+		log.Printf("Failed to store avatar of user %s: %v", userID.Hex(), err)
+		http.Error(w, "Failed to save avatar", http.StatusInternalServerError)
+		return
+	}
+	if _, err := database.GetCollectionFromRequest(r, "users").UpdateOne(context.Background(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"profile.avatar": stored, "updatedAt": time.Now()}},
+	); err != nil {
+		deleteUploads(r, stored.UploadIDs)
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+
+	var previous *models.Avatar
+	if user.Profile != nil && user.Profile.Avatar != nil {
+		previous = user.Profile.Avatar
+		deleteUploads(r, previous.UploadIDs)
+	}
+	recordAudit(r, models.AuditUserUpdate, "user", userID.Hex(),
+		map[string]interface{}{"avatar": previous},
+		map[string]interface{}{"avatar": stored})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stored)
+}
+
+// avatarCrop reads the optional square to crop to, in pixels of the uploaded image
+func avatarCrop(r *http.Request) (*image.Rectangle, error) {
+	if r.FormValue("size") == "" {
+		return nil, nil
+	}
+	var values [3]int
+	for i, field := range []string{"x", "y", "size"} {
+		v, err := strconv.Atoi(r.FormValue(field))
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("Invalid crop %s", field)
+		}
+		values[i] = v
+	}
+	if values[2] == 0 {
+		return nil, errors.New("Invalid crop size")
+	}
+	crop := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[2])
+	return &crop, nil
+}
+
+// storeAvatar writes each rendered size to the uploads directory and records them as
+// uploads belonging to the user pictured
+func storeAvatar(r *http.Request, userID primitive.ObjectID, originalName string, rendered map[int][]byte) (*models.Avatar, error) {
+	dir := filepath.Join(getUploadDir(), "avatars")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	stored := &models.Avatar{Sizes: map[string]string{}}
+	base := userID.Hex() + "-" + primitive.NewObjectID().Hex()
+	largest := 0
+	for _, size := range models.AvatarSizes {
+		name := fmt.Sprintf("%s-%d.png", base, size)
+		upload := models.Upload{
+			ID:           primitive.NewObjectID(),
+			UserID:       userID,
+			URL:          "/uploads/avatars/" + name,
+			Path:         filepath.Join(dir, name),
+			OriginalName: originalName,
+			Size:         int64(len(rendered[size])),
+			Kind:         models.UploadKindAvatar,
+			CreatedAt:    time.Now(),
+		}
+		if err := os.WriteFile(upload.Path, rendered[size], 0644); err != nil {
+			deleteUploads(r, stored.UploadIDs)
+			return nil, err
+		}
+		if err := insertUpload(r, &upload); err != nil {
+			os.Remove(upload.Path)
+			deleteUploads(r, stored.UploadIDs)
+			return nil, err
+		}
+		stored.UploadIDs = append(stored.UploadIDs, upload.ID)
+		stored.Sizes[strconv.Itoa(size)] = upload.URL
+		if size > largest {
+			largest = size
+			stored.URL = upload.URL
+		}
+	}
+	return stored, nil
+}
+
+// deleteUploads removes uploaded files and their records
+func deleteUploads(r *http.Request, ids []primitive.ObjectID) {
+	if len(ids) == 0 {
+		return
+	}
+	collection := database.GetCollectionFromRequest(r, "uploads")
+	var uploads []models.Upload
+	cursor, err := collection.Find(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err == nil {
+		err = cursor.All(context.Background(), &uploads)
+	}
+	if err != nil {
+		log.Printf("Failed to find uploads to delete: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		if err := os.Remove(upload.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove uploaded file %s: %v", upload.Path, err)
+		}
+	}
+	if _, err := collection.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		log.Printf("Failed to delete upload records: %v", err)
+	}
+}
+
+// DeleteAvatar removes a user's profile picture
+func DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := editableUserID(w, r)
+	if !ok {
+		return
+	}
+	user := findUser(r, userID)
+	if user == nil || user.Profile == nil || user.Profile.Avatar == nil {
+		http.Error(w, "No avatar to remove", http.StatusNotFound)
+		return
+	}
+
+	if _, err := database.GetCollectionFromRequest(r, "users").UpdateOne(context.Background(),
+		bson.M{"_id": userID},
+		bson.M{"$unset": bson.M{"profile.avatar": ""}, "$set": bson.M{"updatedAt": time.Now()}},
+	); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	deleteUploads(r, user.Profile.Avatar.UploadIDs)
+	recordAudit(r, models.AuditUserUpdate, "user", userID.Hex(),
+		map[string]interface{}{"avatar": user.Profile.Avatar},
+		map[string]interface{}{"avatar": nil})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Avatar removed",
+	})
+}
+
+// GetAuthor returns an author's public profile by its slug, for author pages
+func GetAuthor(w http.ResponseWriter, r *http.Request) {
+	var user models.User
+	err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{
+		"profile.slug": models.NormalizeSlug(mux.Vars(r)["slug"]),
+		"deletedAt":    bson.M{"$exists": false},
+	}).Decode(&user)
+	if err != nil {
+		http.Error(w, "Author not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user.PublicProfile())
+}
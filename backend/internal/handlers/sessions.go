@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	accessTokenCookie  = "auth-token"
+	refreshTokenCookie = "refresh-token"
+
+	// usedHashesKept bounds how many rotated-out refresh tokens are remembered per session
+	usedHashesKept = 50
+)
+
+// accessTokenTTL is how long an access token is valid (ACCESS_TOKEN_TTL, default 15m)
+func accessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// refreshTokenTTL is how long a session lasts without use (REFRESH_TOKEN_TTL, default 30 days)
+func refreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+	}
+	return fallback
+}
+
+// EnsureSessionIndexes has MongoDB delete every tenant's sessions once they expire.
+// Revoked sessions go too, when their refresh token would have expired; until then
+// they catch reuse of their stolen tokens.
+func EnsureSessionIndexes(ctx context.Context) {
+	for _, db := range middleware.GetAllTenantDatabases() {
+		_, err := database.GetTenantCollection(db, "sessions").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		if err != nil {
+			log.Printf("Failed to create the session expiry index in %s: %v", db, err)
+		}
+	}
+}
+
+// issueSession starts a new session for the user, sets the auth cookies and returns
+// the access token. The refresh token is only ever in its HttpOnly cookie, out of reach
+// of scripts. Every login method goes through here.
+func issueSession(w http.ResponseWriter, r *http.Request, user *models.User) (string, error) {
+	secret, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		TokenHash:  auth.HashToken(secret),
+		UserAgent:  r.UserAgent(),
+		IP:         middleware.GetClientIP(r),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL()),
+	}
+	if _, err := database.GetCollectionFromRequest(r, "sessions").InsertOne(context.Background(), session); err != nil {
+		return "", err
+	}
+
+	accessToken, err := signAccessToken(r, user, session.ID)
+	if err != nil {
+		return "", err
+	}
+
+	setAuthCookies(w, accessToken, session.ID.Hex()+"."+secret)
+	// A new session gets a new CSRF token, so one planted before sign-in is useless
+	if _, err := middleware.IssueCSRFToken(w); err != nil {
+		return "", err
+	}
+	return accessToken, nil
+}
+
+// signAccessToken creates a short-lived JWT bound to a session and signed with the
+// request tenant's key, so it is rejected on every other tenant
+func signAccessToken(r *http.Request, user *models.User, sessionID primitive.ObjectID) (string, error) {
+	return auth.GenerateTenantToken(middleware.GetTenantID(r), jwt.MapClaims{
+		"userId": user.ID.Hex(),
+		"email":  user.Email,
+		"role":   user.Role,
+		"sid":    sessionID.Hex(),
+	}, accessTokenTTL())
+}
+
+func setAuthCookies(w http.ResponseWriter, accessToken, refreshToken string) {
+	setAccessTokenCookie(w, accessToken, accessTokenTTL())
+	// The refresh token is only ever sent to the auth endpoints
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refreshToken,
+		Path:     "/api/auth",
+		HttpOnly: true,
+		Secure:   os.Getenv("NODE_ENV") == "production",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(refreshTokenTTL().Seconds()),
+	})
+}
+
+func setAccessTokenCookie(w http.ResponseWriter, accessToken string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    accessToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   os.Getenv("NODE_ENV") == "production", // HTTPS in production
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ttl.Seconds()),
+	})
+}
+
+func clearAuthCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{accessTokenCookie: "/", refreshTokenCookie: "/api/auth"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     path,
+			HttpOnly: true,
+			Secure:   os.Getenv("NODE_ENV") == "production",
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   -1,
+		})
+	}
+}
+
+// GetCSRFToken returns the token pages send in the X-CSRF-Token header, setting the
+// csrf-token cookie if the browser doesn't have one yet
+func GetCSRFToken(w http.ResponseWriter, r *http.Request) {
+	token, err := middleware.CSRFToken(w, r)
+	if err != nil {
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{
+		"token": token,
+	})
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new refresh
+// token. Presenting a refresh token that was already rotated out means it was copied,
+// so the whole session is revoked.
+func RefreshSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, secret, ok := presentedRefreshToken(r)
+	if !ok {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	sessions := database.GetCollectionFromRequest(r, "sessions")
+	var session models.Session
+	if err := sessions.FindOne(context.Background(), bson.M{"_id": sessionID}).Decode(&session); err != nil {
+		clearAuthCookies(w)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	presentedHash := auth.HashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(session.TokenHash)) != 1 {
+		for _, used := range session.UsedHashes {
+			if subtle.ConstantTimeCompare([]byte(presentedHash), []byte(used)) == 1 {
+				log.Printf("Refresh token reuse detected for session %s (user %s), revoking", session.ID.Hex(), session.UserID.Hex())
+				revokeSessions(r, bson.M{"_id": session.ID}, "refresh token reuse")
+				break
+			}
+		}
+		clearAuthCookies(w)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if !session.Active() {
+		clearAuthCookies(w)
+		http.Error(w, "Session expired", http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": session.UserID}).Decode(&user); err != nil || !user.Approved {
+		revokeSessions(r, bson.M{"_id": session.ID}, "user unavailable")
+		clearAuthCookies(w)
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	newSecret, err := auth.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+
+	// Only rotate if the token is still the current one, so two concurrent refreshes
+	// with the same token can't both succeed
+	now := time.Now()
+	result, err := sessions.UpdateOne(
+		context.Background(),
+		bson.M{"_id": session.ID, "tokenHash": session.TokenHash},
+		bson.M{
+			"$set": bson.M{
+				"tokenHash":  auth.HashToken(newSecret),
+				"lastUsedAt": now,
+				"expiresAt":  now.Add(refreshTokenTTL()),
+				"ip":         middleware.GetClientIP(r),
+				"userAgent":  r.UserAgent(),
+			},
+			"$push": bson.M{"usedHashes": bson.M{"$each": []string{session.TokenHash}, "$slice": -usedHashesKept}},
+		},
+	)
+	if err != nil || result.ModifiedCount == 0 {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := signAccessToken(r, &user, session.ID)
+	if err != nil {
+		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+		return
+	}
+	setAuthCookies(w, accessToken, session.ID.Hex()+"."+newSecret)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     accessToken,
+		"expiresIn": int(accessTokenTTL().Seconds()),
+	})
+}
+
+// GetSessions lists the current user's active sessions
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cursor, err := database.GetCollectionFromRequest(r, "sessions").Find(
+		context.Background(),
+		bson.M{"userId": user.ID, "revokedAt": bson.M{"$exists": false}, "expiresAt": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "lastUsedAt", Value: -1}}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var sessions []models.Session
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		http.Error(w, "Failed to decode sessions", http.StatusInternalServerError)
+		return
+	}
+
+	currentID := middleware.GetSessionID(r)
+	result := make([]map[string]interface{}, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, map[string]interface{}{
+			"id":           s.ID,
+			"userAgent":    s.UserAgent,
+			"ip":           s.IP,
+			"createdAt":    s.CreatedAt,
+			"lastUsedAt":   s.LastUsedAt,
+			"expiresAt":    s.ExpiresAt,
+			"current":      s.ID == currentID,
+			"impersonated": s.ImpersonatorID != nil,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// RevokeSession logs out one of the current user's sessions
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := revokeSessions(r, bson.M{"_id": id, "userId": user.ID}, "revoked by user")
+	if err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if revoked == 0 {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if id == middleware.GetSessionID(r) {
+		clearAuthCookies(w)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Session revoked successfully",
+	})
+}
+
+// revokeSessions marks every active session matching the filter as revoked
+func revokeSessions(r *http.Request, filter bson.M, reason string) (int64, error) {
+	filter["revokedAt"] = bson.M{"$exists": false}
+	result, err := database.GetCollectionFromRequest(r, "sessions").UpdateMany(
+		context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"revokedAt": time.Now(), "revokedReason": reason}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// presentedRefreshToken splits the refresh token from its cookie into its session ID
+// and secret. It is never read from the request body, where a script could have put
+// one it stole; clients without cookies use API tokens instead.
+func presentedRefreshToken(r *http.Request) (primitive.ObjectID, string, bool) {
+	cookie, err := r.Cookie(refreshTokenCookie)
+	if err != nil {
+		return primitive.NilObjectID, "", false
+	}
+
+	sessionHex, secret, found := strings.Cut(cookie.Value, ".")
+	sessionID, err := primitive.ObjectIDFromHex(sessionHex)
+	if !found || err != nil || secret == "" {
+		return primitive.NilObjectID, "", false
+	}
+	return sessionID, secret, true
+}
+
+// RotateSigningKey replaces the tenant's token signing key. Existing access tokens
+// stay valid until they expire.
+func RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	kid, err := auth.RotateTenantKey(context.Background(), middleware.GetTenantID(r))
+	if errors.Is(err, auth.ErrEnvSigningKey) {
+		http.Error(w, "This site signs tokens with the TENANT_<ID>_JWT_SECRET environment variable - change that secret and restart to rotate it", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rotate signing key: %v", err)
+		http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Signing key rotated successfully",
+		"kid":     kid,
+	})
+}
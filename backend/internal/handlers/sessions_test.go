@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAPITokensCantUseSessionRoutes(t *testing.T) {
+	runWithMockDB(t, "bearer", func(mt *mtest.T) {
+		user := testUser(models.RoleAdmin)
+		now := time.Now()
+		queueFound(mt, models.APIToken{
+			ID:         primitive.NewObjectID(),
+			UserID:     user.ID,
+			Scopes:     []models.Permission{models.PermManageUsers},
+			ExpiresAt:  now.Add(time.Hour),
+			LastUsedAt: &now, // Recently used, so it isn't touched again
+		})
+		queueFound(mt, user)
+
+		r := httptest.NewRequest(http.MethodGet, "/api/auth/sessions", nil)
+		r.Header.Set("Authorization", "Bearer "+models.APITokenPrefix+"secret")
+		if w := serve(r); w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403: %s", w.Code, w.Body)
+		}
+	})
+
+	runWithMockDB(t, "session", func(mt *mtest.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/auth/sessions", nil)
+		r.AddCookie(signIn(mt, testUser(models.RoleAuthor)))
+		queueFound(mt) // sessions
+		if w := serve(r); w.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestRefreshTokenOnlyFromCookie(t *testing.T) {
+	token := primitive.NewObjectID().Hex() + ".secret"
+
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refreshToken":"`+token+`"}`))
+	if _, _, ok := presentedRefreshToken(r); ok {
+		t.Error("refresh token accepted from the request body")
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+	r.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: token})
+	if _, secret, ok := presentedRefreshToken(r); !ok || secret != "secret" {
+		t.Errorf("refresh token cookie not read: %q, %v", secret, ok)
+	}
+}
+
+func TestSessionsExpireInTheDatabase(t *testing.T) {
+	runWithMockDB(t, "index", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		EnsureSessionIndexes(context.Background())
+
+		events := mt.GetAllStartedEvents()
+		if len(events) != 1 || events[0].CommandName != "createIndexes" {
+			t.Fatalf("commands = %v", events)
+		}
+		index := events[0].Command.Lookup("indexes", "0").Document()
+		if _, err := index.LookupErr("key", "expiresAt"); err != nil {
+			t.Errorf("not indexed on expiresAt: %s", index)
+		}
+		if ttl, ok := index.Lookup("expireAfterSeconds").AsInt64OK(); !ok || ttl != 0 {
+			t.Errorf("sessions don't expire at expiresAt: %s", index)
+		}
+	})
+}
+
+func TestRefreshRotatesTheToken(t *testing.T) {
+	user := testUser(models.RoleAuthor)
+	refresh := func(session models.Session, secret string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		r.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: session.ID.Hex() + "." + secret})
+		return r
+	}
+
+	runWithMockDB(t, "current token", func(mt *mtest.T) {
+		session := models.Session{ID: primitive.NewObjectID(), UserID: user.ID, TokenHash: auth.HashToken("current"), ExpiresAt: time.Now().Add(time.Hour)}
+		queueFound(mt, session)
+		queueFound(mt, user)
+		queueWrite(mt, 1)
+
+		w := serve(refresh(session, "current"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		cookies := strings.Join(w.Header().Values("Set-Cookie"), ";")
+		if !strings.Contains(cookies, refreshTokenCookie+"="+session.ID.Hex()+".") || strings.Contains(cookies, ".current") {
+			t.Errorf("refresh token not rotated: %s", cookies)
+		}
+	})
+
+	runWithMockDB(t, "rotated-out token", func(mt *mtest.T) {
+		session := models.Session{ID: primitive.NewObjectID(), UserID: user.ID, TokenHash: auth.HashToken("current"),
+			UsedHashes: []string{auth.HashToken("stolen")}, ExpiresAt: time.Now().Add(time.Hour)}
+		queueFound(mt, session)
+		queueWrite(mt, 1) // Revocation
+
+		if w := serve(refresh(session, "stolen")); w.Code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want 401: %s", w.Code, w.Body)
+		}
+		var revoked bool
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "update" {
+				_, err := event.Command.Lookup("updates", "0", "u").Document().LookupErr("$set", "revokedAt")
+				revoked = err == nil
+			}
+		}
+		if !revoked {
+			t.Error("reusing a rotated-out token didn't revoke the session")
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/coders-website/backend/internal/setup"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PrepareSetup creates a setup token for every configured site that has no admin yet
+// and prints it, so whoever runs the server can create the first admin
+func PrepareSetup(ctx context.Context) {
+	for _, db := range middleware.GetAllTenantDatabases() {
+		done, err := setup.Completed(ctx, database.GetTenantDB(db))
+		if err != nil {
+			log.Printf("Failed to check setup state of %s: %v", db, err)
+			continue
+		}
+		if done {
+			continue
+		}
+
+		sites := strings.Join(middleware.GetDomainsForDatabase(db), ", ")
+		if os.Getenv("SETUP_TOKEN") != "" {
+			log.Printf("Site %s (%s) has no admin yet - finish setup at /blog/editor/login with SETUP_TOKEN", db, sites)
+			continue
+		}
+		token, err := setup.NewToken(db)
+		if err != nil {
+			log.Printf("Failed to create setup token for %s: %v", db, err)
+			continue
+		}
+		log.Printf("Site %s (%s) has no admin yet - finish setup at /blog/editor/login with setup token %s", db, sites, token)
+	}
+}
+
+// GetSetupStatus reports whether the site still needs its first admin
+func GetSetupStatus(w http.ResponseWriter, r *http.Request) {
+	done, err := setup.Completed(r.Context(), database.GetDBFromRequest(r))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"setupRequired": !done,
+	})
+}
+
+type CompleteSetupRequest struct {
+	SetupToken string `json:"setupToken" validate:"required"`
+	Name       string `json:"name" validate:"required"`
+	Email      string `json:"email" validate:"required,email"`
+	Password   string `json:"password" validate:"required,min=8"`
+}
+
+// CompleteSetup creates the site's first admin. It needs the setup token printed at
+// startup (or SETUP_TOKEN) and only works once per site.
+func CompleteSetup(w http.ResponseWriter, r *http.Request) {
+	var req CompleteSetupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Name == "" || req.Email == "" || req.Password == "" {
+		http.Error(w, "All fields are required", http.StatusBadRequest)
+		return
+	}
+	if !checkPasswordPolicy(w, r, req.Password, req.Name, req.Email) {
+		return
+	}
+
+	db := database.GetDBFromRequest(r)
+	if done, err := setup.Completed(r.Context(), db); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if done {
+		http.Error(w, "This site has already been set up", http.StatusGone)
+		return
+	}
+	if !setup.CheckToken(db.Name(), req.SetupToken) {
+		http.Error(w, "Invalid setup token", http.StatusForbidden)
+		return
+	}
+	count, err := database.GetCollectionFromRequest(r, "users").CountDocuments(context.Background(), bson.M{"email": req.Email})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, "User with this email already exists", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	admin := models.User{
+		ID:              primitive.NewObjectID(),
+		Name:            req.Name,
+		Email:           req.Email,
+		Password:        req.Password,
+		Role:            models.RoleAdmin,
+		Approved:        true,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if err := admin.HashPassword(); err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	// Claiming first means two requests racing with the token can't both create an admin
+	if err := setup.Claim(r.Context(), db, admin.ID); err == setup.ErrCompleted {
+		http.Error(w, "This site has already been set up", http.StatusGone)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := database.GetCollectionFromRequest(r, "users").InsertOne(context.Background(), admin); err != nil {
+		if err := setup.Release(context.Background(), db, admin.ID); err != nil {
+			log.Printf("Failed to release setup of %s: %v", db.Name(), err)
+		}
+		http.Error(w, "Failed to create admin user", http.StatusInternalServerError)
+		return
+	}
+
+	// The actor is the new admin; there's no signed-in user yet
+	ctx := context.WithValue(r.Context(), middleware.UserContextKey, &admin)
+	recordAudit(r.WithContext(ctx), models.AuditUserCreate, "user", admin.ID.Hex(), nil, admin)
+	log.Printf("Setup of %s completed; first admin is %s", db.Name(), admin.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Admin account created - you can now log in",
+		"user":    admin,
+	})
+}
@@ -134,6 +134,9 @@ func SaveSocialCredentials(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
+	var before bson.M
+	database.GetCollection("users").FindOne(context.Background(), bson.M{"_id": objID}).Decode(&before)
+
 	_, err := database.GetCollection("users").UpdateOne(
 		context.Background(),
 		bson.M{"_id": objID},
@@ -144,6 +147,10 @@ func SaveSocialCredentials(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to save credentials", http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, models.AuditSocialCredentials, "user", objID.Hex(),
+		bson.M{"socialMedia": before["socialMedia"]},
+		bson.M{"socialMedia": update["$set"].(bson.M)["socialMedia"]},
+	)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
@@ -537,6 +544,10 @@ func PublishToSocialMedia(w http.ResponseWriter, r *http.Request) {
 		
 		results = append(results, result)
 	}
+	recordAudit(r, models.AuditSocialPublish, "post", postObjID.Hex(), nil, map[string]interface{}{
+		"title":   post.Title,
+		"results": results,
+	})
 	
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
@@ -69,6 +69,10 @@ func TestSocialConnectionSimple(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// facebookGraphURL is the Facebook Graph API the page check calls; tests point it at a
+// local server
+var facebookGraphURL = "https://graph.facebook.com/v18.0"
+
 // TestFacebookPageConnection tests Facebook Page API connection
 func TestFacebookPageConnection(pageID, pageToken string) (bool, string) {
 	if pageID == "" || pageToken == "" {
@@ -77,7 +81,7 @@ func TestFacebookPageConnection(pageID, pageToken string) (bool, string) {
 	
 	// Test with Facebook Graph API - get page details
 	client := &http.Client{Timeout: 10 * time.Second}
-	url := fmt.Sprintf("https://graph.facebook.com/v18.0/%s?fields=name,id,fan_count&access_token=%s", pageID, pageToken)
+	url := fmt.Sprintf("%s/%s?fields=name,id,fan_count&access_token=%s", facebookGraphURL, pageID, pageToken)
 	
 	resp, err := client.Get(url)
 	if err != nil {
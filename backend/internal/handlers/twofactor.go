@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	challengePurpose2FA = "2fa"
+	challengeTTL        = 5 * time.Minute
+
+	recoveryCodeCount = 10
+
+	// After maxSecondFactorFailures wrong codes the second factor is locked for secondFactorLockout
+	maxSecondFactorFailures = 5
+	secondFactorLockout     = 15 * time.Minute
+)
+
+// completeLogin finishes a login once the first factor has been checked. Users with
+// TOTP or a passkey get a short-lived challenge token to exchange at /auth/login/2fa;
+// everyone else gets a session straight away.
+func completeLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	var methods []string
+	if user.HasTwoFactor() {
+		methods = append(methods, "totp")
+	}
+	if countPasskeys(r, user.ID) > 0 {
+		methods = append(methods, "passkey")
+	}
+
+	if len(methods) > 0 {
+		challenge, err := auth.GenerateTenantToken(middleware.GetTenantID(r), jwt.MapClaims{
+			"userId":  user.ID.Hex(),
+			"purpose": challengePurpose2FA,
+		}, challengeTTL)
+		if err != nil {
+			http.Error(w, "Token generation failed", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"twoFactorRequired": true,
+			"methods":           methods,
+			"challengeToken":    challenge,
+			"expiresIn":         int(challengeTTL.Seconds()),
+		})
+		return
+	}
+
+	respondWithSession(w, r, user)
+}
+
+// respondWithSession starts a session for a fully authenticated user and writes the
+// login response with the short-lived access token; the rotating refresh token is only
+// set as a cookie
+func respondWithSession(w http.ResponseWriter, r *http.Request, user *models.User) {
+	tokenString, err := issueSession(w, r, user)
+	if err != nil {
+		http.Error(w, "Token generation failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":      user,
+		"token":     tokenString,
+		"expiresIn": int(accessTokenTTL().Seconds()),
+	})
+}
+
+// userFromChallenge returns the user a login challenge token was issued to
+func userFromChallenge(r *http.Request, challengeToken string) (*models.User, bool) {
+	claims, err := auth.ValidateTenantToken(challengeToken, middleware.GetTenantID(r))
+	if err != nil || claims["purpose"] != challengePurpose2FA {
+		return nil, false
+	}
+	userHex, _ := claims["userId"].(string)
+	userID, err := primitive.ObjectIDFromHex(userHex)
+	if err != nil {
+		return nil, false
+	}
+
+	var user models.User
+	if err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user); err != nil {
+		return nil, false
+	}
+	if !user.Approved {
+		return nil, false
+	}
+	return &user, true
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// VerifyTwoFactorLogin is the second step of a 2FA login
+func VerifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := userFromChallenge(r, req.ChallengeToken)
+	if !ok || !user.HasTwoFactor() {
+		http.Error(w, "Login challenge expired - please sign in again", http.StatusUnauthorized)
+		return
+	}
+
+	if status, message := verifySecondFactor(r, user, req.Code, req.RecoveryCode); status != http.StatusOK {
+		http.Error(w, message, status)
+		return
+	}
+
+	respondWithSession(w, r, user)
+}
+
+// verifySecondFactor checks a TOTP code or recovery code for the user and records the
+// outcome. It returns http.StatusOK on success, otherwise a status and message.
+func verifySecondFactor(r *http.Request, user *models.User, code, recoveryCode string) (int, string) {
+	tf := user.TwoFactor
+	if tf == nil || !tf.Enabled {
+		return http.StatusBadRequest, "Two-factor authentication is not enabled"
+	}
+	if tf.LockedUntil != nil && time.Now().Before(*tf.LockedUntil) {
+		return http.StatusTooManyRequests, "Too many incorrect codes - try again later"
+	}
+
+	users := database.GetCollectionFromRequest(r, "users")
+	now := time.Now()
+
+	switch {
+	case code != "":
+		if step, ok := auth.ValidateTOTP(tf.Secret, code, now, tf.LastStep); ok {
+			// The lastStep guard makes the update fail if the same code raced in twice
+			result, err := users.UpdateOne(context.Background(),
+				bson.M{"_id": user.ID, "twoFactor.lastStep": tf.LastStep},
+				bson.M{
+					"$set":   bson.M{"twoFactor.lastStep": step},
+					"$unset": bson.M{"twoFactor.failedCount": "", "twoFactor.lockedUntil": ""},
+				},
+			)
+			if err == nil && result.ModifiedCount == 1 {
+				return http.StatusOK, ""
+			}
+		}
+	case recoveryCode != "":
+		hash := auth.HashRecoveryCode(recoveryCode)
+		result, err := users.UpdateOne(context.Background(),
+			bson.M{"_id": user.ID, "twoFactor.recoveryCodes": hash},
+			bson.M{
+				"$pull":  bson.M{"twoFactor.recoveryCodes": hash},
+				"$unset": bson.M{"twoFactor.failedCount": "", "twoFactor.lockedUntil": ""},
+			},
+		)
+		if err == nil && result.ModifiedCount == 1 {
+			log.Printf("User %s used a 2FA recovery code (%d left)", user.ID.Hex(), len(tf.RecoveryCodes)-1)
+			return http.StatusOK, ""
+		}
+	default:
+		return http.StatusBadRequest, "A verification code or recovery code is required"
+	}
+
+	update := bson.M{"$inc": bson.M{"twoFactor.failedCount": 1}}
+	if tf.FailedCount+1 >= maxSecondFactorFailures {
+		update = bson.M{
+			"$set":   bson.M{"twoFactor.lockedUntil": now.Add(secondFactorLockout)},
+			"$unset": bson.M{"twoFactor.failedCount": ""},
+		}
+		log.Printf("Locking 2FA for user %s after %d failed codes", user.ID.Hex(), maxSecondFactorFailures)
+	}
+	if _, err := users.UpdateOne(context.Background(), bson.M{"_id": user.ID}, update); err != nil {
+		log.Printf("Failed to record 2FA failure for user %s: %v", user.ID.Hex(), err)
+	}
+	return http.StatusUnauthorized, "Invalid verification code"
+}
+
+// GetTwoFactorStatus reports the current user's 2FA state and whether the site requires it
+func GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	remaining := 0
+	if user.TwoFactor != nil {
+		remaining = len(user.TwoFactor.RecoveryCodes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                user.HasTwoFactor(),
+		"required":               user.Role == "admin" && middleware.GetTenantConfig(r).HasFeature(middleware.FeatureAdmin2FA),
+		"recoveryCodesRemaining": remaining,
+		"passkeys":               countPasskeys(r, user.ID),
+	})
+}
+
+// SetupTwoFactor starts enrolment by generating a secret. It only becomes active once
+// EnableTwoFactor verifies a code from the authenticator.
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if user.HasTwoFactor() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = database.GetCollectionFromRequest(r, "users").UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"twoFactor.pendingSecret": secret, "twoFactor.enabled": false}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to start enrolment", http.StatusInternalServerError)
+		return
+	}
+
+	issuer := middleware.GetTenantConfig(r).Name
+	if issuer == "" {
+		issuer = middleware.GetTenantDomain(r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":          secret,
+		"provisioningUri": auth.TOTPProvisioningURI(issuer, user.Email, secret),
+	})
+}
+
+// EnableTwoFactor verifies the first code from the authenticator, activates 2FA and
+// returns the recovery codes. This is the only time they are shown.
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if user.HasTwoFactor() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TwoFactor == nil || user.TwoFactor.PendingSecret == "" {
+		http.Error(w, "Start two-factor setup first", http.StatusBadRequest)
+		return
+	}
+
+	step, valid := auth.ValidateTOTP(user.TwoFactor.PendingSecret, req.Code, time.Now(), 0)
+	if !valid {
+		http.Error(w, "Invalid verification code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	_, err = database.GetCollectionFromRequest(r, "users").UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"twoFactor": models.TwoFactor{
+				Enabled:       true,
+				Secret:        user.TwoFactor.PendingSecret,
+				LastStep:      step,
+				RecoveryCodes: hashes,
+				EnabledAt:     &now,
+			},
+			"updatedAt": now,
+		}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	// Other devices signed in with just a password
+	if _, err := revokeSessions(r, bson.M{"userId": user.ID, "_id": bson.M{"$ne": middleware.GetSessionID(r)}}, "2fa enabled"); err != nil {
+		log.Printf("Failed to revoke sessions after enabling 2FA: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+type TwoFactorConfirmRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// DisableTwoFactor turns 2FA off after checking the password and a second factor
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// With the policy on, an admin may only drop TOTP if a passkey remains
+	if user.Role == "admin" && middleware.GetTenantConfig(r).HasFeature(middleware.FeatureAdmin2FA) && countPasskeys(r, user.ID) == 0 {
+		http.Error(w, "Two-factor authentication is required for admins on this site", http.StatusForbidden)
+		return
+	}
+	if err := checkPassword(user, req.Password); err != nil {
+		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+		return
+	}
+	if status, message := verifySecondFactor(r, user, req.Code, req.RecoveryCode); status != http.StatusOK {
+		http.Error(w, message, status)
+		return
+	}
+
+	_, err := database.GetCollectionFromRequest(r, "users").UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$unset": bson.M{"twoFactor": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a second factor
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if status, message := verifySecondFactor(r, user, req.Code, req.RecoveryCode); status != http.StatusOK {
+		http.Error(w, message, status)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	_, err = database.GetCollectionFromRequest(r, "users").UpdateOne(
+		context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"twoFactor.recoveryCodes": hashes, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to save recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recoveryCodes": codes,
+	})
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = auth.HashRecoveryCode(c)
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := testUser(models.RoleAuthor)
+	user.TwoFactor = &models.TwoFactor{Enabled: true, Secret: secret}
+
+	// challenge returns the challenge token a correct password is answered with
+	challenge := func(t *testing.T) string {
+		w := httptest.NewRecorder()
+		completeLogin(w, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil), &user)
+		var login struct {
+			TwoFactorRequired bool     `json:"twoFactorRequired"`
+			Methods           []string `json:"methods"`
+			ChallengeToken    string   `json:"challengeToken"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&login); err != nil {
+			t.Fatal(err)
+		}
+		if !login.TwoFactorRequired || login.ChallengeToken == "" || len(login.Methods) != 1 || login.Methods[0] != "totp" {
+			t.Fatalf("password step answered %+v, want a TOTP challenge", login)
+		}
+		if w.Header().Get("Set-Cookie") != "" {
+			t.Fatal("password step started a session")
+		}
+		return login.ChallengeToken
+	}
+	secondStep := func(challengeToken, code string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(TwoFactorLoginRequest{ChallengeToken: challengeToken, Code: code})
+		return serve(httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(string(body))))
+	}
+
+	runWithMockDB(t, "correct code", func(mt *mtest.T) {
+		token := challenge(t)
+		code, err := auth.TOTPCode(secret, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		queueFound(mt, user)
+		queueWrite(mt, 1) // lastStep
+		queueWrite(mt, 1) // session
+		w := secondStep(token, code)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		cookies := strings.Join(w.Header().Values("Set-Cookie"), ";")
+		if !strings.Contains(cookies, accessTokenCookie+"=") || !strings.Contains(cookies, refreshTokenCookie+"=") {
+			t.Error("no session cookies were set")
+		}
+		if strings.Contains(w.Body.String(), "refreshToken") {
+			t.Errorf("refresh token readable by scripts: %s", w.Body)
+		}
+	})
+
+	runWithMockDB(t, "wrong code", func(mt *mtest.T) {
+		token := challenge(t)
+		code, _ := auth.TOTPCode(secret, time.Now())
+		wrong := string('0'+(code[0]-'0'+1)%10) + code[1:]
+		queueFound(mt, user)
+		if w := secondStep(token, wrong); w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401: %s", w.Code, w.Body)
+		}
+	})
+
+	runWithMockDB(t, "token without the 2fa purpose", func(mt *mtest.T) {
+		token, err := auth.GenerateTenantToken(testTenant, jwt.MapClaims{"userId": user.ID.Hex()}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := auth.TOTPCode(secret, time.Now())
+		if w := secondStep(token, code); w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401: %s", w.Code, w.Body)
+		}
+	})
+}
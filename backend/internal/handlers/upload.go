@@ -1,13 +1,25 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func UploadFile(w http.ResponseWriter, r *http.Request) {
@@ -18,6 +30,12 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Files attached to a post need permission to edit that post
+	postID, ok := uploadPostID(w, r)
+	if !ok {
+		return
+	}
+
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "Failed to get file", http.StatusBadRequest)
@@ -41,10 +59,7 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create upload directory if it doesn't exist
-	uploadDir := os.Getenv("UPLOAD_DIR")
-	if uploadDir == "" {
-		uploadDir = "./uploads"
-	}
+	uploadDir := getUploadDir()
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
 		http.Error(w, "Failed to create upload directory", http.StatusInternalServerError)
 		return
@@ -63,14 +78,158 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 	defer dst.Close()
 
 	// Copy file
-	if _, err := io.Copy(dst, file); err != nil {
+	size, err := io.Copy(dst, file)
+	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
 
+	upload, err := recordUpload(r, postID, "/uploads/"+filename, filepath, header.Filename, size)
+	if err != nil {
+		os.Remove(filepath)
+		http.Error(w, "Failed to record upload", http.StatusInternalServerError)
+		return
+	}
+
 	// Return URL
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(fmt.Sprintf(`{"url": "/uploads/%s"}`, filename)))
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":  upload.ID.Hex(),
+		"url": upload.URL,
+	})
+}
+
+// uploadPostID reads the optional postId form field and checks the current user may
+// edit that post, writing the error response if not
+func uploadPostID(w http.ResponseWriter, r *http.Request) (*primitive.ObjectID, bool) {
+	hex := r.FormValue("postId")
+	if hex == "" {
+		return nil, true
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return nil, false
+	}
+	if _, _, ok := loadEditablePost(w, r, id); !ok {
+		return nil, false
+	}
+	return &id, true
+}
+
+// recordUpload stores who uploaded a file so ownership can be checked later, and audits
+// the upload
+func recordUpload(r *http.Request, postID *primitive.ObjectID, url, path, originalName string, size int64) (*models.Upload, error) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		return nil, errors.New("no user in request context")
+	}
+	upload := models.Upload{
+		ID:           primitive.NewObjectID(),
+		UserID:       user.ID,
+		PostID:       postID,
+		URL:          url,
+		Path:         path,
+		OriginalName: originalName,
+		Size:         size,
+		CreatedAt:    time.Now(),
+	}
+	if err := insertUpload(r, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// insertUpload stores and audits an upload record
+func insertUpload(r *http.Request, upload *models.Upload) error {
+	if _, err := database.GetCollectionFromRequest(r, "uploads").InsertOne(context.Background(), upload); err != nil {
+		return err
+	}
+	recordAudit(r, models.AuditUploadCreate, "upload", upload.ID.Hex(), nil, upload)
+	return nil
+}
+
+// GetUploads lists uploaded files. Authors see their own; editors and admins see all.
+func GetUploads(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Avatars are managed from the profile, not the media library
+	query := bson.M{"kind": bson.M{"$ne": models.UploadKindAvatar}}
+	if !user.Can(models.PermEditAnyPost) {
+		query["userId"] = user.ID
+	}
+	if hex := r.URL.Query().Get("postId"); hex != "" {
+		postID, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+		query["postId"] = postID
+	}
+
+	cursor, err := database.GetCollectionFromRequest(r, "uploads").Find(context.Background(), query,
+		options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		http.Error(w, "Failed to fetch uploads", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	uploads := []models.Upload{}
+	if err := cursor.All(context.Background(), &uploads); err != nil {
+		http.Error(w, "Failed to decode uploads", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploads)
+}
+
+// DeleteUpload removes an uploaded file. Authors can only delete their own uploads.
+func DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+
+	collection := database.GetCollectionFromRequest(r, "uploads")
+	var upload models.Upload
+	if err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&upload); err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if upload.UserID != user.ID && !user.Can(models.PermEditAnyPost) {
+		http.Error(w, "You can only delete your own uploads", http.StatusForbidden)
+		return
+	}
+	if upload.Kind == models.UploadKindAvatar {
+		http.Error(w, "Remove the avatar from the profile instead", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := collection.DeleteOne(context.Background(), bson.M{"_id": id}); err != nil {
+		http.Error(w, "Failed to delete upload", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Remove(upload.Path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove uploaded file %s: %v", upload.Path, err)
+	}
+	recordAudit(r, models.AuditUploadDelete, "upload", id.Hex(), upload, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Upload deleted successfully",
+	})
 }
 
 func generateRandomString(length int) string {
@@ -80,4 +239,13 @@ func generateRandomString(length int) string {
 		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
 	}
 	return string(b)
-}
\ No newline at end of file
+}
+
+// getUploadDir returns the root directory uploaded files are stored under
+func getUploadDir() string {
+	uploadDir := os.Getenv("UPLOAD_DIR")
+	if uploadDir == "" {
+		uploadDir = "./uploads"
+	}
+	return uploadDir
+}
@@ -23,6 +23,12 @@ func UploadFileMultiTenant(w http.ResponseWriter, r *http.Request) {
 	// Parse multipart form (10MB max)
 	r.ParseMultipartForm(10 << 20)
 
+	// Files attached to a post need permission to edit that post
+	postID, ok := uploadPostID(w, r)
+	if !ok {
+		return
+	}
+
 	file, handler, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, "Error retrieving file", http.StatusBadRequest)
@@ -63,13 +69,19 @@ func UploadFileMultiTenant(w http.ResponseWriter, r *http.Request) {
 	defer dst.Close()
 
 	// Copy file contents
-	if _, err := io.Copy(dst, file); err != nil {
+	size, err := io.Copy(dst, file)
+	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
 
 	// Return file URL (relative to tenant)
 	fileURL := fmt.Sprintf("/uploads/%s/%s/%s", tenantID, time.Now().Format("2006/01"), filename)
+	if _, err := recordUpload(r, postID, fileURL, filepath, handler.Filename, size); err != nil {
+		os.Remove(filepath)
+		http.Error(w, "Failed to record upload", http.StatusInternalServerError)
+		return
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -3,15 +3,21 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"log"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/identity"
 	"github.com/coders-website/backend/internal/middleware"
 	"github.com/coders-website/backend/internal/models"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Helper function to safely get string from BSON map
@@ -24,8 +30,84 @@ func getString(m bson.M, key string) string {
 	return ""
 }
 
+// findUser loads a user by ID, or returns nil if there isn't one
+func findUser(r *http.Request, id primitive.ObjectID) *models.User {
+	var user models.User
+	if err := database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": id}).Decode(&user); err != nil {
+		return nil
+	}
+	return &user
+}
+
+// userSummaryFields are the only user fields admin listings read, so credentials never
+// leave the database
+var userSummaryFields = bson.M{
+	"name":                1,
+	"email":               1,
+	"role":                1,
+	"approved":            1,
+	"emailVerified":       1,
+	"twoFactor.enabled":   1,
+	"identities.provider": 1,
+	"createdAt":           1,
+	"updatedAt":           1,
+	"deletionScheduledAt": 1,
+}
+
+// GetUsers lists users, newest first. Search names and emails with ?q=; filter with
+// ?role=, ?approved=true|false and ?from= / ?to= on the creation date; page with ?page=
+// and ?limit= (default 50).
 func GetUsers(w http.ResponseWriter, r *http.Request) {
-	cursor, err := database.GetCollectionFromRequest(r, "users").Find(context.Background(), bson.M{})
+	params := r.URL.Query()
+	// Erased accounts only remain as anonymous authors of their posts
+	query := bson.M{"deletedAt": bson.M{"$exists": false}}
+	if q := strings.TrimSpace(params.Get("q")); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		query["$or"] = bson.A{bson.M{"name": pattern}, bson.M{"email": pattern}}
+	}
+	if role := params.Get("role"); role != "" {
+		query["role"] = role
+	}
+	if approved := params.Get("approved"); approved != "" {
+		value, err := strconv.ParseBool(approved)
+		if err != nil {
+			http.Error(w, "approved must be true or false", http.StatusBadRequest)
+			return
+		}
+		query["approved"] = value
+	}
+	createdAt := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value, param == "to")
+		if err != nil {
+			http.Error(w, "Invalid "+param+" date", http.StatusBadRequest)
+			return
+		}
+		createdAt[op] = t
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	limit := int64(50)
+	if value, err := strconv.ParseInt(params.Get("limit"), 10, 64); err == nil && value > 0 && value <= 500 {
+		limit = value
+	}
+	page := int64(1)
+	if value, err := strconv.ParseInt(params.Get("page"), 10, 64); err == nil && value > 0 {
+		page = value
+	}
+
+	collection := database.GetCollectionFromRequest(r, "users")
+	cursor, err := collection.Find(context.Background(), query, options.Find().
+		SetProjection(userSummaryFields).
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit).
+		SetSkip((page-1)*limit))
 	if err != nil {
 		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
 		return
@@ -37,9 +119,39 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to decode users", http.StatusInternalServerError)
 		return
 	}
+	summaries := make([]models.UserSummary, 0, len(users))
+	for i := range users {
+		summaries = append(summaries, users[i].Summary())
+	}
+
+	total, err := collection.CountDocuments(context.Background(), query)
+	if err != nil {
+		http.Error(w, "Failed to count users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": summaries,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// GetPendingUserCount returns how many users are waiting for approval, for the admin
+// dashboard badge
+func GetPendingUserCount(w http.ResponseWriter, r *http.Request) {
+	count, err := database.GetCollectionFromRequest(r, "users").CountDocuments(context.Background(), bson.M{"approved": false, "deletedAt": bson.M{"$exists": false}})
+	if err != nil {
+		http.Error(w, "Failed to count users", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(map[string]int64{
+		"pending": count,
+	})
 }
 
 func GetUserByID(w http.ResponseWriter, r *http.Request) {
@@ -67,104 +179,113 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-// UpdateUser handles updating user profile including social media settings
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := primitive.ObjectIDFromHex(vars["id"])
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
-	}
+// socialCredentialFields are the credentials each network stored in models.SocialCredentials
+// takes, as named in requests and in the database
+var socialCredentialFields = map[string][]string{
+	"reddit":   {"client_id", "client_secret", "username", "password", "subreddits"},
+	"devto":    {"api_key"},
+	"linkedin": {"access_token"},
+	"facebook": {"page_id", "page_access_token"},
+	"twitter":  {"api_key", "api_secret", "access_token", "access_token_secret"},
+}
 
-	// Check if user is updating their own profile or is admin
-	currentUser, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
-	if !ok || currentUser == nil {
-		http.Error(w, "Authentication required", http.StatusUnauthorized)
-		return
-	}
-	
-	currentUserID := currentUser.ID.Hex()
-	currentUserRole := currentUser.Role
-	
-	if currentUserID != vars["id"] && currentUserRole != "admin" {
-		http.Error(w, "Unauthorized", http.StatusForbidden)
+// UpdateUser handles updating a user's account, public profile and social media settings.
+// Every field is optional and only those sent are changed, so the profile can be edited
+// without resending the social credentials, and a single network's credentials without
+// the others'.
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := editableUserID(w, r)
+	if !ok {
 		return
 	}
+	currentUser, _ := middleware.GetUserFromContext(r)
+	canManageUsers := currentUser.Can(models.PermManageUsers)
 
 	// Parse request body
 	var req struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
-		Role  string `json:"role"`
-		Social struct {
-			Reddit struct {
-				ClientID     string `json:"client_id"`
-				ClientSecret string `json:"client_secret"`
-				Username     string `json:"username"`
-				Password     string `json:"password"`
-				Subreddits   string `json:"subreddits"`
-			} `json:"reddit"`
-			Devto struct {
-				APIKey string `json:"api_key"`
-			} `json:"devto"`
-			LinkedIn struct {
-				AccessToken string `json:"access_token"`
-			} `json:"linkedin"`
-			Facebook struct {
-				PageID          string `json:"page_id"`
-				PageAccessToken string `json:"page_access_token"`
-			} `json:"facebook"`
-			Twitter struct {
-				APIKey            string `json:"api_key"`
-				APISecret         string `json:"api_secret"`
-				AccessToken       string `json:"access_token"`
-				AccessTokenSecret string `json:"access_token_secret"`
-			} `json:"twitter"`
-		} `json:"social"`
+		Email   *string                      `json:"email"`
+		Name    *string                      `json:"name"`
+		Role    string                       `json:"role"`
+		Profile *ProfileUpdateRequest        `json:"profile"`
+		Social  map[string]map[string]string `json:"social"` // Network -> credential -> value
 	}
-
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	before := findUser(r, userID)
+
 	// Prepare update document
-	update := bson.M{
-		"$set": bson.M{
-			"email": req.Email,
-			"name":  req.Name,
-			"social": bson.M{
-				"reddit": bson.M{
-					"client_id":     req.Social.Reddit.ClientID,
-					"client_secret": req.Social.Reddit.ClientSecret,
-					"username":      req.Social.Reddit.Username,
-					"password":      req.Social.Reddit.Password,
-					"subreddits":    req.Social.Reddit.Subreddits,
-				},
-				"devto": bson.M{
-					"api_key": req.Social.Devto.APIKey,
-				},
-				"linkedin": bson.M{
-					"access_token": req.Social.LinkedIn.AccessToken,
-				},
-				"facebook": bson.M{
-					"page_id":           req.Social.Facebook.PageID,
-					"page_access_token": req.Social.Facebook.PageAccessToken,
-				},
-				"twitter": bson.M{
-					"api_key":              req.Social.Twitter.APIKey,
-					"api_secret":           req.Social.Twitter.APISecret,
-					"access_token":         req.Social.Twitter.AccessToken,
-					"access_token_secret":  req.Social.Twitter.AccessTokenSecret,
-				},
-			},
-			"updatedAt": time.Now(),
-		},
+	set := bson.M{"updatedAt": time.Now()}
+	unset := bson.M{}
+	emailChanged := false
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return
+		}
+		set["name"] = name
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email == "" {
+			http.Error(w, "Email is required", http.StatusBadRequest)
+			return
+		}
+		// A shared identity's email is the same on every site it belongs to
+		if before != nil && before.IdentityID != nil && !strings.EqualFold(email, before.Email) {
+			http.Error(w, "This account is shared with other sites, so its email can't be changed here", http.StatusBadRequest)
+			return
+		}
+		count, err := database.GetCollectionFromRequest(r, "users").CountDocuments(context.Background(),
+			bson.M{"email": email, "_id": bson.M{"$ne": userID}})
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if count > 0 {
+			http.Error(w, "User with this email already exists", http.StatusConflict)
+			return
+		}
+		set["email"] = email
+
+		// A new address has to be confirmed before the account can sign in with it again
+		if before != nil && !strings.EqualFold(email, before.Email) {
+			emailChanged = true
+			set["emailVerified"] = false
+			unset["emailVerifiedAt"] = ""
+		}
+	}
+	for network, credentials := range req.Social {
+		fields, ok := socialCredentialFields[network]
+		if !ok {
+			http.Error(w, "Unknown social network: "+network, http.StatusBadRequest)
+			return
+		}
+		for _, field := range fields {
+			if value, ok := credentials[field]; ok {
+				set["social."+network+"."+field] = value
+			}
+		}
+	}
+	if req.Profile != nil && !profileUpdate(w, r, userID, req.Profile, set, unset) {
+		return
 	}
 
 	// Only admin can change roles
-	if currentUserRole == "admin" && req.Role != "" {
-		update["$set"].(bson.M)["role"] = req.Role
+	if canManageUsers && req.Role != "" {
+		if !models.ValidRole(req.Role) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		set["role"] = models.NormalizeRole(req.Role)
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
 	}
 
 	// Update user
@@ -183,9 +304,16 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	after := findUser(r, userID)
+	recordAudit(r, models.AuditUserUpdate, "user", userID.Hex(), before, after)
+	if emailChanged && after != nil {
+		if err := sendVerificationEmail(r, after); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(map[string]bool{"success": true, "verificationSent": emailChanged})
 }
 
 func ApproveUser(w http.ResponseWriter, r *http.Request) {
@@ -196,6 +324,7 @@ func ApproveUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := findUser(r, id)
 	result, err := database.GetCollectionFromRequest(r, "users").UpdateOne(
 		context.Background(),
 		bson.M{"_id": id},
@@ -211,6 +340,7 @@ func ApproveUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	recordAudit(r, models.AuditUserApprove, "user", id.Hex(), before, findUser(r, id))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -227,17 +357,22 @@ func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Role string `json:"role" validate:"required,oneof=admin user"`
+		Role string `json:"role" validate:"required,oneof=admin editor author viewer user"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !models.ValidRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
 
+	before := findUser(r, id)
 	result, err := database.GetCollectionFromRequest(r, "users").UpdateOne(
 		context.Background(),
 		bson.M{"_id": id},
-		bson.M{"$set": bson.M{"role": req.Role}},
+		bson.M{"$set": bson.M{"role": models.NormalizeRole(req.Role), "updatedAt": time.Now()}},
 	)
 
 	if err != nil {
@@ -249,6 +384,7 @@ func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	recordAudit(r, models.AuditUserRoleChange, "user", id.Hex(), before, findUser(r, id))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -260,7 +396,7 @@ type CreateUserRequest struct {
 	Name     string `json:"name" validate:"required"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
-	Role     string `json:"role" validate:"required,oneof=admin user"`
+	Role     string `json:"role" validate:"required,oneof=admin editor author viewer user"`
 }
 
 func CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -269,6 +405,13 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !models.ValidRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	if !checkPasswordPolicy(w, r, req.Password, req.Name, req.Email) {
+		return
+	}
 
 	// Check if user already exists
 	count, err := database.GetCollectionFromRequest(r, "users").CountDocuments(context.Background(), bson.M{"email": req.Email})
@@ -283,11 +426,12 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// Create new user
 	user := models.User{
-		Name:     req.Name,
-		Email:    req.Email,
-		Password: req.Password,
-		Role:     req.Role,
-		Approved: true, // Admin-created users are pre-approved
+		Name:          req.Name,
+		Email:         req.Email,
+		Password:      req.Password,
+		Role:          models.NormalizeRole(req.Role),
+		Approved:      true, // Admin-created users are pre-approved
+		EmailVerified: true,
 	}
 
 	// Hash password
@@ -298,22 +442,36 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	// Set timestamps
 	now := time.Now()
+	user.ID = primitive.NewObjectID()
 	user.CreatedAt = now
 	user.UpdatedAt = now
 
+	// Someone with an account on another site sharing identities keeps its password
+	shared, ok := prepareIdentity(w, r, &user, req.Password, true)
+	if !ok {
+		return
+	}
+
 	// Insert user
-	result, err := database.GetCollectionFromRequest(r, "users").InsertOne(context.Background(), user)
+	_, err = database.GetCollectionFromRequest(r, "users").InsertOne(context.Background(), user)
 	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
+	if !joinIdentity(w, r, &user, shared) {
+		return
+	}
 
-	user.ID = result.InsertedID.(primitive.ObjectID)
 	user.Password = "" // Don't send password back
+	recordAudit(r, models.AuditUserCreate, "user", user.ID.Hex(), nil, user)
 
+	message := "User created successfully"
+	if shared != nil && len(shared.Memberships) > 0 {
+		message = "User created successfully - they already have an account on another site and sign in with that password"
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "User created successfully",
+		"message": message,
 		"user":    user,
 	})
 }
@@ -327,13 +485,18 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get current user from context to prevent self-deletion
-	currentUser := r.Context().Value("user").(*models.User)
+	currentUser, ok := middleware.GetUserFromContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 	if currentUser.ID == id {
 		http.Error(w, "Cannot delete your own account", http.StatusBadRequest)
 		return
 	}
 
 	// Delete the user
+	before := findUser(r, id)
 	result, err := database.GetCollectionFromRequest(r, "users").DeleteOne(
 		context.Background(),
 		bson.M{"_id": id},
@@ -349,6 +512,19 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Their shared identity stays for the other sites they belong to
+	if before != nil && before.IdentityID != nil {
+		if err := identity.Leave(context.Background(), *before.IdentityID, middleware.GetTenantDatabase(r)); err != nil {
+			log.Printf("Failed to remove user %s from identity %s: %v", id.Hex(), before.IdentityID.Hex(), err)
+		}
+	}
+
+	// Their API tokens would no longer resolve to a user, but don't keep them around
+	if _, err := database.GetCollectionFromRequest(r, "api_tokens").DeleteMany(context.Background(), bson.M{"userId": id}); err != nil {
+		log.Printf("Failed to delete API tokens of user %s: %v", id.Hex(), err)
+	}
+	recordAudit(r, models.AuditUserDelete, "user", id.Hex(), before, nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "User deleted successfully",
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/mailer"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestChangingEmailNeedsVerification(t *testing.T) {
+	mailer.SetDefault(&mailer.Mailer{Driver: &mailer.MemoryDriver{}})
+	t.Cleanup(func() { mailer.SetDefault(nil) })
+
+	runWithMockDB(t, "own email", func(mt *mtest.T) {
+		user := testUser(models.RoleAuthor)
+		verifiedAt := time.Now()
+		user.EmailVerifiedAt = &verifiedAt
+		changed := user
+		changed.Email = "new@example.com"
+		changed.EmailVerified = false
+		changed.EmailVerifiedAt = nil
+
+		r := httptest.NewRequest(http.MethodPut, "/api/users/"+user.ID.Hex(), strings.NewReader(`{"email":"new@example.com"}`))
+		r.AddCookie(signIn(mt, user))
+		queueFound(mt, user) // Before
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, bson.D{{Key: "n", Value: 0}}))
+		queueWrite(mt, 1)       // Update
+		queueFound(mt, changed) // After
+		queueWrite(mt, 1)       // Audit entry
+		queueWrite(mt, 0)       // Earlier verification tokens
+		queueWrite(mt, 1)       // New verification token
+
+		w := serve(r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"verificationSent":true`) {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+
+		var updated, tokenSent bool
+		for _, event := range mt.GetAllStartedEvents() {
+			switch event.CommandName {
+			case "update":
+				update := event.Command.Lookup("updates", "0", "u").Document()
+				verified, err := update.LookupErr("$set", "emailVerified")
+				if err != nil {
+					continue
+				}
+				updated = true
+				if v, ok := verified.BooleanOK(); !ok || v {
+					t.Errorf("email still verified: %s", update)
+				}
+				if _, err := update.LookupErr("$unset", "emailVerifiedAt"); err != nil {
+					t.Errorf("verification time kept: %s", update)
+				}
+			case "insert":
+				if event.Command.Lookup("insert").StringValue() == "auth_tokens" {
+					tokenSent = true
+				}
+			}
+		}
+		if !updated {
+			t.Error("the email change didn't clear verification")
+		}
+		if !tokenSent {
+			t.Error("no verification link was sent to the new address")
+		}
+	})
+}
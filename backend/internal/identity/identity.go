@@ -0,0 +1,217 @@
+// Package identity is the optional global identity store. Sites that share it keep
+// their own users collections, but each user there can be linked to one identity in
+// the main database, so a person has one email and password across those sites and
+// can move between them without signing in again.
+package identity
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"github.com/coders-website/backend/internal/database"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TicketTTL is how long a single sign-on ticket can be redeemed
+const TicketTTL = time.Minute
+
+// ErrNotFound is returned when there is no such identity or ticket
+var ErrNotFound = errors.New("identity: not found")
+
+// collection returns one of the identity store's collections in the main database
+func collection(name string) (*mongo.Collection, error) {
+	db := database.GetDB()
+	if db == nil {
+		return nil, errors.New("database unavailable")
+	}
+	return db.Collection(name), nil
+}
+
+// NormalizeEmail is the form identities are stored and looked up by
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// FindByEmail returns the identity with the email address
+func FindByEmail(ctx context.Context, email string) (*models.Identity, error) {
+	return find(ctx, bson.M{"email": NormalizeEmail(email)})
+}
+
+// Get returns the identity with the ID
+func Get(ctx context.Context, id primitive.ObjectID) (*models.Identity, error) {
+	return find(ctx, bson.M{"_id": id})
+}
+
+func find(ctx context.Context, filter bson.M) (*models.Identity, error) {
+	identities, err := collection("identities")
+	if err != nil {
+		return nil, err
+	}
+	var identity models.Identity
+	err = identities.FindOne(ctx, filter).Decode(&identity)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// New returns an identity for the email and password hash. It is stored when its first
+// membership is added by Join.
+func New(email, passwordHash string) *models.Identity {
+	now := time.Now()
+	return &models.Identity{
+		ID:        primitive.NewObjectID(),
+		Email:     NormalizeEmail(email),
+		Password:  passwordHash,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Join records that the user on the site using db is a member through the identity,
+// storing the identity first if it is new. A site has at most one membership per
+// identity; joining again keeps the first. The user's email must be verified, which
+// marks the identity verified.
+func Join(ctx context.Context, identity *models.Identity, db string, userID primitive.ObjectID) error {
+	identities, err := collection("identities")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = identities.UpdateOne(ctx, bson.M{"_id": identity.ID}, bson.M{
+		"$setOnInsert": bson.M{
+			"email":       identity.Email,
+			"password":    identity.Password,
+			"memberships": bson.A{},
+			"createdAt":   identity.CreatedAt,
+		},
+		"$set": bson.M{"verified": true, "updatedAt": now},
+	}, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	_, err = identities.UpdateOne(ctx,
+		bson.M{"_id": identity.ID, "memberships.database": bson.M{"$ne": db}},
+		bson.M{"$push": bson.M{"memberships": models.Membership{Database: db, UserID: userID, JoinedAt: now}}},
+	)
+	return err
+}
+
+// Leave removes the identity's membership of the site using db, deleting the identity
+// once it belongs to no site, so an erased user's email and password don't outlive
+// their last account
+func Leave(ctx context.Context, id primitive.ObjectID, db string) error {
+	identities, err := collection("identities")
+	if err != nil {
+		return err
+	}
+	if _, err := identities.UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$pull": bson.M{"memberships": bson.M{"database": db}}, "$set": bson.M{"updatedAt": time.Now()}},
+	); err != nil {
+		return err
+	}
+	_, err = identities.DeleteOne(ctx, bson.M{"_id": id, "memberships": bson.M{"$size": 0}})
+	return err
+}
+
+// SetPassword stores a new password hash for the identity. With a previous hash given,
+// the update only applies if the password hasn't changed since it was read.
+func SetPassword(ctx context.Context, id primitive.ObjectID, hash, previous string) error {
+	identities, err := collection("identities")
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": id}
+	if previous != "" {
+		filter["password"] = previous
+	}
+	result, err := identities.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"password": hash, "updatedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IssueTicket creates a single sign-on ticket for the identity to redeem on the site
+// using db
+func IssueTicket(ctx context.Context, id primitive.ObjectID, sourceDB, db string) (string, error) {
+	tickets, err := collection("sso_tickets")
+	if err != nil {
+		return "", err
+	}
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	_, err = tickets.InsertOne(ctx, models.SSOTicket{
+		ID:             auth.HashToken(token),
+		IdentityID:     id,
+		Database:       db,
+		SourceDatabase: sourceDB,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(TicketTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	// Expired tickets are never redeemed; clear them out as new ones are issued
+	tickets.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	return token, nil
+}
+
+// ticketFilter matches an unexpired ticket issued for the site using db
+func ticketFilter(token, db string) bson.M {
+	return bson.M{
+		"_id":       auth.HashToken(token),
+		"database":  db,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+}
+
+// FindTicket returns a ticket issued for the site using db without using it up
+func FindTicket(ctx context.Context, token, db string) (*models.SSOTicket, error) {
+	tickets, err := collection("sso_tickets")
+	if err != nil {
+		return nil, err
+	}
+	var ticket models.SSOTicket
+	err = tickets.FindOne(ctx, ticketFilter(token, db)).Decode(&ticket)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ticket, nil
+}
+
+// RedeemTicket uses up a ticket issued for the site using db and returns it
+func RedeemTicket(ctx context.Context, token, db string) (*models.SSOTicket, error) {
+	tickets, err := collection("sso_tickets")
+	if err != nil {
+		return nil, err
+	}
+	var ticket models.SSOTicket
+	err = tickets.FindOneAndDelete(ctx, ticketFilter(token, db)).Decode(&ticket)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ticket, nil
+}
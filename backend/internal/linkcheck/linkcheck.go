@@ -0,0 +1,290 @@
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coders-website/backend/internal/models"
+)
+
+// Link is a URL referenced from post content
+type Link struct {
+	URL  string
+	Kind string // link or image
+}
+
+var (
+	// [text](url) and ![alt](url), with optional <> around the URL and an optional title
+	markdownLinkPattern = regexp.MustCompile(`(!?)\[[^\]]*\]\(\s*<?([^)\s>]+)>?(?:\s+["'][^"']*["'])?\s*\)`)
+	// href="..." and src="..." on HTML elements
+	htmlLinkPattern = regexp.MustCompile(`(?i)<(a|img|source|video|audio|iframe)\b[^>]*?\s(href|src)\s*=\s*["']([^"']+)["']`)
+)
+
+// reservedBlogPaths are /blog/<segment> routes served by the app rather than posts
+var reservedBlogPaths = map[string]bool{
+	"docs": true, "editor": true, "category": true, "login": true, "register": true, "tag": true,
+}
+
+// PostPath returns the public path of a post
+func PostPath(postType, slug string) string {
+	if postType == "docs" {
+		return "/blog/docs/" + slug
+	}
+	return "/blog/" + slug
+}
+
+// ExtractLinks finds markdown and HTML links and images in post content. Anchors,
+// mailto:, tel:, javascript: and data: URLs are skipped, and duplicates are removed.
+func ExtractLinks(content string) []Link {
+	var links []Link
+	seen := make(map[string]bool)
+	add := func(raw, kind string) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			return
+		}
+		lower := strings.ToLower(raw)
+		for _, scheme := range []string{"mailto:", "tel:", "javascript:", "data:"} {
+			if strings.HasPrefix(lower, scheme) {
+				return
+			}
+		}
+		if seen[kind+raw] {
+			return
+		}
+		seen[kind+raw] = true
+		links = append(links, Link{URL: raw, Kind: kind})
+	}
+
+	for _, m := range markdownLinkPattern.FindAllStringSubmatch(content, -1) {
+		kind := "link"
+		if m[1] == "!" {
+			kind = "image"
+		}
+		add(m[2], kind)
+	}
+	for _, m := range htmlLinkPattern.FindAllStringSubmatch(content, -1) {
+		kind := "link"
+		if !strings.EqualFold(m[1], "a") {
+			kind = "image"
+		}
+		add(m[3], kind)
+	}
+	return links
+}
+
+// Checker decides whether links found in posts resolve
+type Checker struct {
+	// Posts maps public post paths to whether the post is published
+	Posts map[string]bool
+	// UploadDir is the directory /uploads/ URLs are served from
+	UploadDir string
+	// Hosts are the tenant's own domains; absolute URLs on them are checked as internal
+	Hosts []string
+	// CheckExternal enables fetching external URLs with Client
+	CheckExternal bool
+	Client        *http.Client
+
+	mu       sync.Mutex
+	external map[string]string // URL -> failure reason ("" when reachable)
+}
+
+// Check returns the broken links in a post's content and how many links were checked
+func (c *Checker) Check(ctx context.Context, content string) ([]models.BrokenLink, int) {
+	var broken []models.BrokenLink
+	links := ExtractLinks(content)
+	for _, link := range links {
+		if reason := c.checkLink(ctx, link.URL); reason != "" {
+			broken = append(broken, models.BrokenLink{URL: link.URL, Kind: link.Kind, Reason: reason})
+		}
+	}
+	return broken, len(links)
+}
+
+func (c *Checker) checkLink(ctx context.Context, raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "malformed URL"
+	}
+
+	switch {
+	case u.Scheme == "" && u.Host == "":
+		if !strings.HasPrefix(u.Path, "/") {
+			// Relative paths depend on where the post is rendered; don't guess
+			return ""
+		}
+		return c.checkInternal(u.Path)
+	case u.Scheme == "http" || u.Scheme == "https" || (u.Scheme == "" && u.Host != ""):
+		if c.isOwnHost(u.Hostname()) {
+			return c.checkInternal(u.Path)
+		}
+		if !c.CheckExternal {
+			return ""
+		}
+		if u.Scheme == "" {
+			u.Scheme = "https"
+		}
+		return c.checkExternal(ctx, u.String())
+	}
+	return ""
+}
+
+func (c *Checker) isOwnHost(host string) bool {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	for _, h := range c.Hosts {
+		if host == strings.TrimPrefix(strings.ToLower(h), "www.") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checker) checkInternal(p string) string {
+	if p == "" {
+		p = "/"
+	}
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+
+	if strings.HasPrefix(p, "/uploads/") {
+		rel := path.Clean(strings.TrimPrefix(p, "/uploads/"))
+		if strings.HasPrefix(rel, "..") {
+			return "upload path escapes the uploads directory"
+		}
+		if _, err := os.Stat(filepath.Join(c.UploadDir, filepath.FromSlash(rel))); err != nil {
+			return "upload file not found"
+		}
+		return ""
+	}
+
+	if !isPostPath(p) {
+		// Pages outside the blog can't be verified from the backend
+		return ""
+	}
+	published, exists := c.Posts[p]
+	if !exists {
+		return "no post with this slug"
+	}
+	if !published {
+		return "post is not published"
+	}
+	return ""
+}
+
+// isPostPath reports whether a path has the shape of a blog or docs post URL
+func isPostPath(p string) bool {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	if len(segments) == 0 || segments[0] != "blog" {
+		return false
+	}
+	switch len(segments) {
+	case 2:
+		return !reservedBlogPaths[segments[1]]
+	case 3:
+		return segments[1] == "docs"
+	}
+	return false
+}
+
+func (c *Checker) checkExternal(ctx context.Context, target string) string {
+	c.mu.Lock()
+	if c.external == nil {
+		c.external = make(map[string]string)
+	}
+	if reason, ok := c.external[target]; ok {
+		c.mu.Unlock()
+		return reason
+	}
+	c.mu.Unlock()
+
+	reason := c.fetch(ctx, target)
+
+	c.mu.Lock()
+	c.external[target] = reason
+	c.mu.Unlock()
+	return reason
+}
+
+func (c *Checker) fetch(ctx context.Context, target string) string {
+	client := c.Client
+	if client == nil {
+		client = publicClient
+	}
+
+	status, err := request(ctx, client, http.MethodHead, target)
+	// Some servers don't implement HEAD; retry those with GET
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = request(ctx, client, http.MethodGet, target)
+	}
+	if err != nil {
+		return "request failed: " + err.Error()
+	}
+	if status >= 400 {
+		return fmt.Sprintf("HTTP %d", status)
+	}
+	return ""
+}
+
+// publicClient fetches external links. Links come from post content, so it only
+// connects to public addresses: the check runs on each connection, after DNS and on
+// every redirect, so a post can't point the server at its own network.
+var publicClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: refusePrivateAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which net.IP doesn't count as private
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
+
+// isPublicIP reports whether the address is reachable on the public internet rather
+// than loopback, private, link-local or otherwise reserved
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+func request(ctx context.Context, client *http.Client, method, target string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "CodersInFlow-LinkChecker/1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
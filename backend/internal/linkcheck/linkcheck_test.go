@@ -0,0 +1,162 @@
+package linkcheck
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubTransport answers requests from a fixed table of URL -> status code
+type stubTransport map[string]int
+
+func (s stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, ok := s[req.URL.String()]
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+func TestExtractLinks(t *testing.T) {
+	content := `See [the intro](/blog/intro) and ![diagram](/uploads/a.png "Diagram").
+<a href="https://example.com/page">external</a> <img src='/uploads/b.png'>
+[anchor](#top) [mail](mailto:hi@example.com) [again](/blog/intro)`
+
+	links := ExtractLinks(content)
+	want := []Link{
+		{URL: "/blog/intro", Kind: "link"},
+		{URL: "/uploads/a.png", Kind: "image"},
+		{URL: "https://example.com/page", Kind: "link"},
+		{URL: "/uploads/b.png", Kind: "image"},
+	}
+	if len(links) != len(want) {
+		t.Fatalf("got %d links %v, want %d", len(links), links, len(want))
+	}
+	for i := range want {
+		if links[i] != want[i] {
+			t.Errorf("link %d = %v, want %v", i, links[i], want[i])
+		}
+	}
+}
+
+func TestCheckInternalLinks(t *testing.T) {
+	uploads := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(uploads, "codersinflow"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(uploads, "codersinflow", "present.png"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := &Checker{
+		Posts:     map[string]bool{"/blog/live": true, "/blog/draft": false, "/blog/docs/setup": true},
+		UploadDir: uploads,
+		Hosts:     []string{"codersinflow.com"},
+	}
+
+	content := `[a](/blog/live) [b](/blog/draft) [c](/blog/gone) [d](/blog/old-slug)
+[e](https://www.codersinflow.com/blog/docs/setup/) [f](/blog/docs/missing) [g](/about)
+![h](/uploads/codersinflow/present.png) ![i](/uploads/codersinflow/missing.png)
+[j](/uploads/../secrets) [k](https://elsewhere.com/x)`
+
+	broken, checked := checker.Check(context.Background(), content)
+	if checked != 11 {
+		t.Errorf("checked %d links, want 11", checked)
+	}
+
+	reasons := map[string]string{}
+	for _, b := range broken {
+		reasons[b.URL] = b.Reason
+	}
+	expected := map[string]string{
+		"/blog/draft":                       "post is not published",
+		"/blog/gone":                        "no post with this slug",
+		"/blog/old-slug":                    "no post with this slug",
+		"/blog/docs/missing":                "no post with this slug",
+		"/uploads/codersinflow/missing.png": "upload file not found",
+		"/uploads/../secrets":               "upload path escapes the uploads directory",
+	}
+	if len(reasons) != len(expected) {
+		t.Errorf("got broken links %v, want %v", reasons, expected)
+	}
+	for url, reason := range expected {
+		if reasons[url] != reason {
+			t.Errorf("%s: got reason %q, want %q", url, reasons[url], reason)
+		}
+	}
+}
+
+func TestCheckExternalLinksUsesClient(t *testing.T) {
+	checker := &Checker{
+		CheckExternal: true,
+		Client: &http.Client{Transport: stubTransport{
+			"https://ok.example/":      http.StatusOK,
+			"https://moved.example/":   http.StatusMovedPermanently,
+			"https://gone.example/old": http.StatusGone,
+		}},
+	}
+
+	content := `[a](https://ok.example/) [b](https://gone.example/old) [c](https://missing.example/) [d](https://moved.example/)`
+	broken, _ := checker.Check(context.Background(), content)
+
+	got := map[string]string{}
+	for _, b := range broken {
+		got[b.URL] = b.Reason
+	}
+	if got["https://gone.example/old"] != "HTTP 410" || got["https://missing.example/"] != "HTTP 404" || len(got) != 2 {
+		t.Errorf("unexpected broken links: %v", got)
+	}
+
+	// External checks are skipped unless enabled
+	checker = &Checker{Client: checker.Client}
+	if broken, _ := checker.Check(context.Background(), content); len(broken) != 0 {
+		t.Errorf("external links checked while disabled: %v", broken)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false, // Cloud metadata
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::ffff:127.0.0.1": false,
+	}
+	for addr, want := range cases {
+		if got := isPublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestExternalChecksRefusePrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("link check reached a loopback server")
+	}))
+	defer server.Close()
+
+	checker := &Checker{CheckExternal: true}
+	broken, _ := checker.Check(context.Background(), "[internal]("+server.URL+"/admin)")
+	if len(broken) != 1 || !strings.Contains(broken[0].Reason, "non-public address") {
+		t.Errorf("expected the loopback link to be refused, got %v", broken)
+	}
+}
@@ -0,0 +1,156 @@
+package linkcheck
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReportsCollection stores the results of each run per tenant
+const ReportsCollection = "link_reports"
+
+// reportsKept is how many past reports are retained per tenant
+const reportsKept = 10
+
+// Options configure a link-check run for one tenant
+type Options struct {
+	UploadDir string
+	Hosts     []string
+	External  bool
+	Client    *http.Client // Used for external checks; tests can supply a stub transport
+}
+
+// Run checks every post in the tenant database and stores the report
+func Run(ctx context.Context, db *mongo.Database, opts Options) (*models.LinkReport, error) {
+	report := &models.LinkReport{
+		StartedAt: time.Now(),
+		External:  opts.External,
+		Posts:     []models.PostLinkReport{},
+	}
+
+	checker, err := newChecker(ctx, db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := db.Collection("posts").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{
+		"title": 1, "slug": 1, "type": 1, "content": 1, "coverImage": 1, "ogImage": 1,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var post models.Post
+		if err := cursor.Decode(&post); err != nil {
+			continue
+		}
+
+		// Cover and OG images are references too, so check them alongside the content
+		content := post.Content
+		for _, image := range []string{post.CoverImage, post.OGImage} {
+			if image != "" {
+				content += "\n![](" + image + ")"
+			}
+		}
+
+		broken, checked := checker.Check(ctx, content)
+		report.PostsChecked++
+		report.LinksChecked += checked
+		if len(broken) > 0 {
+			report.BrokenCount += len(broken)
+			report.Posts = append(report.Posts, models.PostLinkReport{
+				PostID: post.ID,
+				Title:  post.Title,
+				Slug:   post.Slug,
+				Type:   post.Type,
+				Broken: broken,
+			})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	report.FinishedAt = time.Now()
+	if err := saveReport(ctx, db, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// LatestReport returns the most recent stored report, or nil if none exists
+func LatestReport(ctx context.Context, db *mongo.Database) (*models.LinkReport, error) {
+	var report models.LinkReport
+	err := db.Collection(ReportsCollection).FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "startedAt", Value: -1}})).Decode(&report)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func newChecker(ctx context.Context, db *mongo.Database, opts Options) (*Checker, error) {
+	checker := &Checker{
+		Posts:         make(map[string]bool),
+		UploadDir:     opts.UploadDir,
+		Hosts:         opts.Hosts,
+		CheckExternal: opts.External,
+		Client:        opts.Client,
+	}
+
+	cursor, err := db.Collection("posts").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"slug": 1, "type": 1, "published": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var posts []models.Post
+	if err := cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		checker.Posts[PostPath(p.Type, p.Slug)] = p.Published
+	}
+
+	return checker, nil
+}
+
+func saveReport(ctx context.Context, db *mongo.Database, report *models.LinkReport) error {
+	collection := db.Collection(ReportsCollection)
+	result, err := collection.InsertOne(ctx, report)
+	if err != nil {
+		return err
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		report.ID = id
+	}
+
+	// Trim old reports
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().
+		SetSort(bson.D{{Key: "startedAt", Value: -1}}).
+		SetSkip(reportsKept).
+		SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil // The report itself was saved; trimming can wait for the next run
+	}
+	var old []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &old); err != nil || len(old) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(old))
+	for i, o := range old {
+		ids[i] = o.ID
+	}
+	collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return nil
+}
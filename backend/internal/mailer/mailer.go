@@ -0,0 +1,152 @@
+// Package mailer renders per-tenant email templates and hands the result to a
+// delivery driver (SMTP, .eml files or the log)
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Message is a rendered email ready for delivery
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Driver delivers rendered messages
+type Driver interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Tenant identifies the site an email is sent on behalf of
+type Tenant struct {
+	ID     string
+	Name   string
+	Domain string
+	From   string // Optional; see Mailer.fromAddress
+}
+
+// Mailer renders templates for a tenant and sends them with its driver
+type Mailer struct {
+	Driver Driver
+	// TemplateDir holds per-tenant overrides as <TemplateDir>/<tenant ID>/<template>.tmpl
+	TemplateDir string
+	// DefaultFrom is used when the tenant has no from-address of its own
+	DefaultFrom string
+}
+
+var (
+	defaultMailer   *Mailer
+	defaultMailerMu sync.Mutex
+)
+
+// Default returns the process-wide mailer, configured from the environment on first use
+func Default() *Mailer {
+	defaultMailerMu.Lock()
+	defer defaultMailerMu.Unlock()
+	if defaultMailer == nil {
+		defaultMailer = FromEnv()
+	}
+	return defaultMailer
+}
+
+// SetDefault replaces the process-wide mailer, e.g. with a MemoryDriver in tests
+func SetDefault(m *Mailer) {
+	defaultMailerMu.Lock()
+	defer defaultMailerMu.Unlock()
+	defaultMailer = m
+}
+
+// FromEnv builds a mailer from MAIL_DRIVER (smtp, file or log), the SMTP_* settings,
+// MAIL_FILE_DIR, MAIL_TEMPLATES_DIR and MAIL_FROM
+func FromEnv() *Mailer {
+	m := &Mailer{
+		TemplateDir: os.Getenv("MAIL_TEMPLATES_DIR"),
+		DefaultFrom: os.Getenv("MAIL_FROM"),
+	}
+	if m.TemplateDir == "" {
+		m.TemplateDir = "/app/email-templates"
+	}
+
+	switch driver := strings.ToLower(os.Getenv("MAIL_DRIVER")); driver {
+	case "smtp":
+		m.Driver = SMTPDriverFromEnv()
+	case "file":
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+		m.Driver = &FileDriver{Dir: dir}
+	case "", "log":
+		// Without SMTP configured nothing leaves the server; links show up in the log
+		if os.Getenv("SMTP_HOST") != "" && driver == "" {
+			m.Driver = SMTPDriverFromEnv()
+		} else {
+			m.Driver = LogDriver{}
+		}
+	default:
+		log.Printf("Unknown MAIL_DRIVER %q, logging emails instead", driver)
+		m.Driver = LogDriver{}
+	}
+	return m
+}
+
+// Send renders the named template for the tenant and delivers it to one recipient
+func (m *Mailer) Send(ctx context.Context, tenant Tenant, to, template string, data map[string]interface{}) error {
+	if m.Driver == nil {
+		return errors.New("mailer has no driver")
+	}
+	if to == "" {
+		return errors.New("no recipient")
+	}
+
+	vars := map[string]interface{}{
+		"SiteName":   tenant.Name,
+		"SiteDomain": tenant.Domain,
+		"To":         to,
+	}
+	if tenant.Name == "" {
+		vars["SiteName"] = tenant.Domain
+	}
+	for k, v := range data {
+		vars[k] = v
+	}
+
+	msg, err := m.render(tenant.ID, template, vars)
+	if err != nil {
+		return err
+	}
+	msg.From = m.fromAddress(tenant)
+	msg.To = to
+	return m.Driver.Send(ctx, msg)
+}
+
+// fromAddress picks the tenant's configured address, then TENANT_<ID>_MAIL_FROM,
+// then MAIL_FROM, then no-reply@<tenant domain>
+func (m *Mailer) fromAddress(tenant Tenant) string {
+	if tenant.From != "" {
+		return tenant.From
+	}
+	if tenant.ID != "" {
+		envKey := "TENANT_" + strings.ToUpper(strings.ReplaceAll(tenant.ID, "-", "_")) + "_MAIL_FROM"
+		if from := os.Getenv(envKey); from != "" {
+			return from
+		}
+	}
+	if m.DefaultFrom != "" {
+		return m.DefaultFrom
+	}
+	domain := strings.TrimPrefix(tenant.Domain, "www.")
+	if domain == "" || domain == "localhost" {
+		domain = "localhost.localdomain"
+	}
+	return (&mail.Address{Name: tenant.Name, Address: "no-reply@" + domain}).String()
+}
@@ -0,0 +1,224 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a minimal SMTP server that accepts one message per session and
+// records what it received
+type smtpStandIn struct {
+	listener net.Listener
+	received chan receivedMail
+}
+
+type receivedMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+func startSMTPStandIn(t *testing.T) *smtpStandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{listener: l, received: make(chan receivedMail, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var current receivedMail
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			current = receivedMail{From: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			current.To = append(current.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			current.Data = data.String()
+			s.received <- current
+			reply("250 OK queued")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *smtpStandIn) driver() *SMTPDriver {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &SMTPDriver{Host: host, Port: p, Timeout: 5 * time.Second}
+}
+
+func TestSendOverSMTP(t *testing.T) {
+	server := startSMTPStandIn(t)
+	m := &Mailer{Driver: server.driver()}
+
+	err := m.Send(context.Background(), Tenant{ID: "codersinflow", Name: "CodersInFlow", Domain: "codersinflow.com"},
+		"jane@example.com", TemplatePasswordReset, map[string]interface{}{
+			"Name":      "Jane <script>",
+			"Link":      "https://codersinflow.com/blog/editor/reset-password?token=abc",
+			"ExpiresIn": "1 hour",
+		})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var got receivedMail
+	select {
+	case got = <-server.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	if got.From != "no-reply@codersinflow.com" || len(got.To) != 1 || got.To[0] != "jane@example.com" {
+		t.Errorf("envelope = %+v", got)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(got.Data))
+	if err != nil {
+		t.Fatalf("received message doesn't parse: %v", err)
+	}
+	if subject := msg.Header.Get("Subject"); subject != "Reset your CodersInFlow password" {
+		t.Errorf("subject = %q", subject)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if !strings.Contains(string(body), "token=3Dabc") { // quoted-printable "="
+		t.Errorf("reset link missing from body:\n%s", body)
+	}
+	if strings.Contains(string(body), "<script>") && !strings.Contains(string(body), "Jane &lt;script&gt;") {
+		t.Errorf("HTML part not escaped:\n%s", body)
+	}
+}
+
+func TestTenantTemplateOverrideAndFrom(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "darkflows"), 0755)
+	os.WriteFile(filepath.Join(dir, "darkflows", TemplateVerifyEmail+".tmpl"),
+		[]byte(`{{define "subject"}}Welcome to the dark side{{end}}{{define "text"}}Click {{.Link}}{{end}}`), 0644)
+	t.Setenv("TENANT_DARKFLOWS_MAIL_FROM", "Dark Flows <hello@darkflows.com>")
+
+	mem := &MemoryDriver{}
+	m := &Mailer{Driver: mem, TemplateDir: dir}
+	data := map[string]interface{}{"Link": "https://x/y"}
+
+	if err := m.Send(context.Background(), Tenant{ID: "darkflows", Domain: "darkflows.com"}, "a@b.c", TemplateVerifyEmail, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), Tenant{ID: "codersinflow", Name: "CodersInFlow", Domain: "codersinflow.com"}, "a@b.c", TemplateVerifyEmail, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if mem.Sent()[0].Subject != "Welcome to the dark side" || mem.Sent()[0].From != "Dark Flows <hello@darkflows.com>" || mem.Sent()[0].HTML != "" {
+		t.Errorf("override not used: %+v", mem.Sent()[0])
+	}
+	if mem.Sent()[1].Subject != "Confirm your email for CodersInFlow" || !strings.Contains(mem.Sent()[1].From, "no-reply@codersinflow.com") {
+		t.Errorf("built-in template not used: %+v", mem.Sent()[1])
+	}
+}
+
+func TestSubjectCannotInjectHeaders(t *testing.T) {
+	mem := &MemoryDriver{}
+	m := &Mailer{Driver: mem}
+	err := m.Send(context.Background(), Tenant{Name: "Evil\r\nBcc: victim@example.com", Domain: "x.com"}, "a@b.c", TemplateVerifyEmail, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := mem.Sent()[0].Bytes()
+	headers, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Errorf("header injection in:\n%s", headers)
+	}
+}
+
+func TestInvitationTemplate(t *testing.T) {
+	mem := &MemoryDriver{}
+	m := &Mailer{Driver: mem}
+	err := m.Send(context.Background(), Tenant{ID: "codersinflow", Name: "CodersInFlow", Domain: "codersinflow.com"},
+		"new@example.com", TemplateInvitation, map[string]interface{}{
+			"Role":      "editor",
+			"InvitedBy": "Ada",
+			"Link":      "https://codersinflow.com/blog/editor/accept-invite?token=abc",
+			"ExpiresIn": "7 days",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := mem.Sent()[0]
+	if msg.Subject != "You're invited to join CodersInFlow" {
+		t.Errorf("subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Ada has invited you to join CodersInFlow as editor") || !strings.Contains(msg.Text, "token=abc") {
+		t.Errorf("text = %q", msg.Text)
+	}
+}
+
+func TestMagicLinkTemplate(t *testing.T) {
+	mem := &MemoryDriver{}
+	m := &Mailer{Driver: mem}
+	err := m.Send(context.Background(), Tenant{ID: "prestongarrison", Name: "Preston Garrison", Domain: "prestongarrison.com"},
+		"guest@example.com", TemplateMagicLink, map[string]interface{}{
+			"Name":      "Guest",
+			"Link":      "https://prestongarrison.com/blog/editor/magic-link?token=abc",
+			"ExpiresIn": "15 minutes",
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := mem.Sent()[0]
+	if msg.Subject != "Your sign-in link for Preston Garrison" {
+		t.Errorf("subject = %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "magic-link?token=abc") || !strings.Contains(msg.Text, "expires in 15 minutes") {
+		t.Errorf("text = %q", msg.Text)
+	}
+}
@@ -0,0 +1,86 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Built-in templates. A tenant can override any of them by placing a file with the
+// same name in its own template directory.
+//
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// Template names used by the auth flows
+const (
+	TemplatePasswordReset = "password_reset"
+	TemplateVerifyEmail   = "verify_email"
+	TemplateInvitation    = "invitation"
+	TemplateMagicLink     = "magic_link"
+)
+
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// render executes a template's "subject", "text" and optional "html" blocks
+func (m *Mailer) render(tenantID, name string, vars map[string]interface{}) (Message, error) {
+	if !templateNamePattern.MatchString(name) {
+		return Message{}, fmt.Errorf("invalid template name %q", name)
+	}
+	source, err := m.templateSource(tenantID, name)
+	if err != nil {
+		return Message{}, err
+	}
+
+	textTmpl, err := texttemplate.New(name).Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return Message{}, fmt.Errorf("template %s: %v", name, err)
+	}
+
+	var msg Message
+	var buf bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&buf, "subject", vars); err != nil {
+		return Message{}, fmt.Errorf("template %s subject: %v", name, err)
+	}
+	// Header injection guard: subjects are a single line
+	msg.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	if err := textTmpl.ExecuteTemplate(&buf, "text", vars); err != nil {
+		return Message{}, fmt.Errorf("template %s text: %v", name, err)
+	}
+	msg.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	// The HTML part goes through html/template so values are escaped
+	if textTmpl.Lookup("html") != nil {
+		htmlTmpl, err := htmltemplate.New(name).Option("missingkey=zero").Parse(source)
+		if err != nil {
+			return Message{}, fmt.Errorf("template %s: %v", name, err)
+		}
+		buf.Reset()
+		if err := htmlTmpl.ExecuteTemplate(&buf, "html", vars); err != nil {
+			return Message{}, fmt.Errorf("template %s html: %v", name, err)
+		}
+		msg.HTML = strings.TrimSpace(buf.String())
+	}
+	return msg, nil
+}
+
+func (m *Mailer) templateSource(tenantID, name string) (string, error) {
+	if m.TemplateDir != "" && tenantID != "" && !strings.ContainsAny(tenantID, `/\.`) {
+		if data, err := os.ReadFile(filepath.Join(m.TemplateDir, tenantID, name+".tmpl")); err == nil {
+			return string(data), nil
+		}
+	}
+	data, err := builtinTemplates.ReadFile("templates/" + name + ".tmpl")
+	if err != nil {
+		return "", fmt.Errorf("unknown email template %q", name)
+	}
+	return string(data), nil
+}
@@ -2,12 +2,14 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
+	"github.com/coders-website/backend/internal/auth"
 	"github.com/coders-website/backend/internal/database"
 	"github.com/coders-website/backend/internal/models"
-	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -16,81 +18,266 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 
+// SessionContextKey holds the ID of the session the access token belongs to
+const SessionContextKey contextKey = "session"
+
+// ImpersonatorContextKey holds the admin acting as the user, on impersonation sessions
+const ImpersonatorContextKey contextKey = "impersonator"
+
+// identity is who a request is authenticated as
+type identity struct {
+	user         *models.User
+	sessionID    primitive.ObjectID
+	impersonator *models.User
+}
+
+func (id identity) withContext(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), UserContextKey, id.user)
+	ctx = context.WithValue(ctx, SessionContextKey, id.sessionID)
+	if id.impersonator != nil {
+		ctx = context.WithValue(ctx, ImpersonatorContextKey, id.impersonator)
+	}
+	return r.WithContext(ctx)
+}
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get token from cookie
-		cookie, err := r.Cookie("auth-token")
+		id, err := authenticate(r)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		tokenString := cookie.Value
-		if tokenString == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		// Add user to context
+		next.ServeHTTP(w, id.withContext(r))
+	})
+}
 
-		// Parse token
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			jwtSecret = "default-dev-secret-change-in-production"
+// OptionalAuthMiddleware adds the user to the context when the request carries a valid
+// access token, and otherwise lets it through anonymously. Public routes use it to show
+// signed-in users more, such as drafts.
+func OptionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, err := authenticate(r); err == nil {
+			r = id.withContext(r)
 		}
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecret), nil
-		})
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+var (
+	errUnauthorized   = errors.New("Unauthorized")
+	errSessionExpired = errors.New("Session expired")
+)
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+// authenticate resolves the user and session from the request's access token. Scripts
+// can instead send a personal access token as "Authorization: Bearer"; those requests
+// have no session.
+func authenticate(r *http.Request) (identity, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return identity{}, errUnauthorized
 		}
+		user, err := authenticateAPIToken(r, strings.TrimSpace(token))
+		return identity{user: user}, err
+	}
 
-		// Get user ID from claims
-		userID, ok := claims["userId"].(string)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+	// Get token from cookie
+	cookie, err := r.Cookie("auth-token")
+	if err != nil || cookie.Value == "" {
+		return identity{}, errUnauthorized
+	}
 
-		// Convert to ObjectID
-		objectID, err := primitive.ObjectIDFromHex(userID)
+	// Tokens are signed per tenant, so one minted for another site fails here
+	claims, err := auth.ValidateTenantToken(cookie.Value, GetTenantID(r))
+	if err != nil {
+		return identity{}, errUnauthorized
+	}
+
+	// Challenge and other purpose-specific tokens are not access tokens
+	if purpose, _ := claims["purpose"].(string); purpose != "" {
+		return identity{}, errUnauthorized
+	}
+
+	// Get user ID from claims
+	userID, _ := claims["userId"].(string)
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return identity{}, errUnauthorized
+	}
+
+	// Access tokens are bound to a session so logging out or revoking a device takes
+	// effect immediately rather than when the token expires
+	sessionHex, _ := claims["sid"].(string)
+	sessionID, err := primitive.ObjectIDFromHex(sessionHex)
+	if err != nil {
+		return identity{}, errUnauthorized
+	}
+	var session models.Session
+	err = database.GetCollectionFromRequest(r, "sessions").FindOne(context.Background(), bson.M{
+		"_id":       sessionID,
+		"userId":    objectID,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err != nil {
+		return identity{}, errSessionExpired
+	}
+
+	// Get user from database
+	var user models.User
+	err = database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&user)
+	if err != nil {
+		return identity{}, errUnauthorized
+	}
+
+	id := identity{user: &user, sessionID: sessionID}
+	if session.ImpersonatorID != nil {
+		impersonator, err := authenticateImpersonator(r, &session)
 		if err != nil {
-			http.Error(w, "Invalid user ID", http.StatusUnauthorized)
-			return
+			return identity{}, err
 		}
+		id.impersonator = impersonator
+	}
+	return id, nil
+}
 
-		// Get user from database
-		var user models.User
-		err = database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&user)
-		if err != nil {
-			http.Error(w, "User not found", http.StatusUnauthorized)
+// authenticateImpersonator checks that the admin behind an impersonation session is
+// still signed in and still allowed to manage users
+func authenticateImpersonator(r *http.Request, session *models.Session) (*models.User, error) {
+	if session.ParentSessionID == nil {
+		return nil, errSessionExpired
+	}
+	parents, err := database.GetCollectionFromRequest(r, "sessions").CountDocuments(context.Background(), bson.M{
+		"_id":       *session.ParentSessionID,
+		"userId":    *session.ImpersonatorID,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	})
+	if err != nil || parents == 0 {
+		return nil, errSessionExpired
+	}
+
+	var admin models.User
+	err = database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": *session.ImpersonatorID}).Decode(&admin)
+	if err != nil || !admin.Can(models.PermManageUsers) {
+		return nil, errSessionExpired
+	}
+	return &admin, nil
+}
+
+// apiTokenTouchInterval limits how often a token's last-used time is written
+const apiTokenTouchInterval = time.Minute
+
+// authenticateAPIToken resolves the owner of a personal access token
+func authenticateAPIToken(r *http.Request, token string) (*models.User, error) {
+	if !strings.HasPrefix(token, models.APITokenPrefix) {
+		return nil, errUnauthorized
+	}
+
+	now := time.Now()
+	tokens := database.GetCollectionFromRequest(r, "api_tokens")
+	var record models.APIToken
+	err := tokens.FindOne(context.Background(), bson.M{
+		"tokenHash": auth.HashToken(token),
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}).Decode(&record)
+	if err != nil {
+		return nil, errUnauthorized
+	}
+
+	var user models.User
+	err = database.GetCollectionFromRequest(r, "users").FindOne(context.Background(), bson.M{"_id": record.UserID}).Decode(&user)
+	if err != nil || !user.Approved {
+		return nil, errUnauthorized
+	}
+
+	// Record use, at most once a minute per token
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) >= apiTokenTouchInterval {
+		tokens.UpdateOne(context.Background(),
+			bson.M{"_id": record.ID},
+			bson.M{"$set": bson.M{"lastUsedAt": now, "lastUsedIp": GetClientIP(r)}},
+		)
+	}
+
+	user.APIToken = &record
+	return &user, nil
+}
+
+// RequireSession rejects requests authenticated with a personal access token, for
+// account routes a leaked token must not be able to use (passwords, 2FA, more tokens)
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetSessionID(r).IsZero() {
+			http.Error(w, "API tokens can't be used for this endpoint", http.StatusForbidden)
 			return
 		}
-
-		// Add user to context
-		ctx := context.WithValue(r.Context(), UserContextKey, &user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
 	})
 }
 
-func AdminMiddleware(next http.Handler) http.Handler {
+// BlockImpersonation rejects requests from impersonation sessions, for routes that
+// change the user's credentials or security settings
+func BlockImpersonation(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, ok := r.Context().Value(UserContextKey).(*models.User)
-		if !ok || user.Role != "admin" {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+		if _, ok := GetImpersonator(r); ok {
+			http.Error(w, "Not available while impersonating a user", http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// RequirePermission only lets through users whose role grants perm. It must run after
+// AuthMiddleware.
+func RequirePermission(perm models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUserFromContext(r)
+			if !ok || !user.Can(perm) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			// Sites can require admins to enrol in 2FA before using admin routes
+			if isAdminPermission(perm) && GetTenantConfig(r).HasFeature(FeatureAdmin2FA) && !HasSecondFactor(r, user) {
+				http.Error(w, "Two-factor authentication required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isAdminPermission reports whether perm is reserved for admins
+func isAdminPermission(perm models.Permission) bool {
+	return perm == models.PermManageUsers || perm == models.PermManageSettings
+}
+
+// HasSecondFactor reports whether the user has TOTP or at least one passkey
+func HasSecondFactor(r *http.Request, user *models.User) bool {
+	if user.HasTwoFactor() {
+		return true
+	}
+	passkeys, err := database.GetCollectionFromRequest(r, "passkeys").CountDocuments(context.Background(), bson.M{"userId": user.ID})
+	return err == nil && passkeys > 0
+}
+
 func GetUserFromContext(r *http.Request) (*models.User, bool) {
 	user, ok := r.Context().Value(UserContextKey).(*models.User)
 	return user, ok
-}
\ No newline at end of file
+}
+
+// GetImpersonator returns the admin acting as the signed-in user, if the request comes
+// from an impersonation session
+func GetImpersonator(r *http.Request) (*models.User, bool) {
+	admin, ok := r.Context().Value(ImpersonatorContextKey).(*models.User)
+	return admin, ok
+}
+
+// GetSessionID returns the session the request was authenticated with
+func GetSessionID(r *http.Request) primitive.ObjectID {
+	id, _ := r.Context().Value(SessionContextKey).(primitive.ObjectID)
+	return id
+}
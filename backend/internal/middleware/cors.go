@@ -2,98 +2,93 @@ package middleware
 
 import (
 	"net/http"
-	"strings"
+	"net/url"
 	"os"
-	"encoding/json"
-	"io/ioutil"
+	"strings"
 )
 
-// AllowedDomains holds the list of allowed domains for CORS
-type AllowedDomains struct {
-	Domains []string `json:"domains"`
-}
-
 // DynamicCORSMiddleware handles CORS for multiple tenant domains
 func DynamicCORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-		
+		w.Header().Add("Vary", "Origin")
+
 		// Check if origin is allowed
 		if isAllowedOrigin(origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cookie, X-Tenant-Domain, X-Tenant-Id, X-Site-Database")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cookie, X-Tenant-Domain, X-Tenant-Id, X-Site-Database, "+CSRFHeader)
 			w.Header().Set("Access-Control-Max-Age", "300")
 		}
-		
+
 		// Handle preflight
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-		
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-// isAllowedOrigin checks if the origin is in our allowed list
+// isAllowedOrigin checks the origin against every configured site domain and
+// ALLOWED_DOMAINS
 func isAllowedOrigin(origin string) bool {
 	if origin == "" {
 		return false
 	}
-	
-	// Always allow localhost and 127.0.0.1 for development
-	if strings.Contains(origin, "localhost") || strings.Contains(origin, "127.0.0.1") {
-		return true
-	}
-	
-	// Extract domain from origin (remove protocol and port)
-	originDomain := origin
-	originDomain = strings.TrimPrefix(originDomain, "https://")
-	originDomain = strings.TrimPrefix(originDomain, "http://")
-	if idx := strings.Index(originDomain, ":"); idx != -1 {
-		originDomain = originDomain[:idx]
+
+	var domains []string
+	for domain := range sitesConfig {
+		if domain != "default" {
+			domains = append(domains, domain)
+		}
 	}
-	
-	// Load allowed domains from environment or config
-	allowedDomainsStr := os.Getenv("ALLOWED_DOMAINS")
-	if allowedDomainsStr != "" {
-		allowedDomains := strings.Split(allowedDomainsStr, ",")
-		for _, domain := range allowedDomains {
-			domain = strings.TrimSpace(domain)
-			// Check exact match or subdomain match
-			if origin == "https://"+domain || origin == "http://"+domain ||
-			   origin == "https://www."+domain || origin == "http://www."+domain {
-				return true
-			}
+	for _, domain := range strings.Split(os.Getenv("ALLOWED_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
 		}
 	}
-	
-	// Try loading from sites-config.json
-	configPath := os.Getenv("SITES_CONFIG_PATH")
-	if configPath == "" {
-		configPath = "/app/sites-config.json"
+	return originMatches(origin, domains)
+}
+
+// originMatches reports whether an Origin or Referer belongs to one of the domains or
+// its www. subdomain, on the default port (and HTTPS in production). Outside
+// production, local development hosts (localhost, *.localhost, 127.0.0.1) match on any
+// port, since the dev frontend serves every site from them; in production they never
+// do.
+func originMatches(origin string, domains []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
 	}
-	
-	data, err := ioutil.ReadFile(configPath)
-	if err == nil {
-		var sites map[string]interface{}
-		if err := json.Unmarshal(data, &sites); err == nil {
-			for domain := range sites {
-				if origin == "https://"+domain || origin == "http://"+domain ||
-				   origin == "https://www."+domain || origin == "http://www."+domain {
-					return true
-				}
-			}
-		}
+	host := strings.ToLower(u.Hostname())
+	production := IsProduction()
+	if IsLocalHost(host) {
+		return !production
 	}
-	
-	// In production, be strict - no wildcard allowed
-	if os.Getenv("ENV") == "production" {
+	if u.Port() != "" || (production && u.Scheme != "https") {
 		return false
 	}
-	
-	// In development, be more permissive
-	return true
-}
\ No newline at end of file
+
+	host = strings.TrimPrefix(host, "www.")
+	for _, domain := range domains {
+		if strings.TrimPrefix(strings.ToLower(domain), "www.") == host {
+			return true
+		}
+	}
+	return false
+}
+
+// IsProduction follows NODE_ENV like the rest of the backend; ENV is still honoured
+// for older deployments
+func IsProduction() bool {
+	return os.Getenv("NODE_ENV") == "production" || os.Getenv("ENV") == "production"
+}
+
+// IsLocalHost reports whether the host name only reaches this machine, so plain http
+// is acceptable for it even in production
+func IsLocalHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || strings.HasSuffix(host, ".localhost")
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withSites(t *testing.T, sites map[string]SiteConfig) {
+	saved := sitesConfig
+	sitesConfig = sites
+	t.Cleanup(func() { sitesConfig = saved })
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	withSites(t, map[string]SiteConfig{
+		"example.com":     {ID: "example", Database: "example_db"},
+		"www.example.com": {ID: "example", Database: "example_db"},
+		"other.com":       {ID: "other", Database: "other_db"},
+		"default":         {ID: "default", Database: "default_db"},
+	})
+	t.Setenv("NODE_ENV", "production")
+	handler := TenantMiddleware(CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	session := &http.Cookie{Name: "auth-token", Value: "jwt"}
+	csrf := &http.Cookie{Name: CSRFCookie, Value: "token"}
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		referer string
+		bearer  bool
+		cookies []*http.Cookie
+		token   string
+		want    int
+	}{
+		{"reads are never checked", "GET", "https://evil.com", "", false, []*http.Cookie{session}, "", 200},
+		{"write with session and token", "POST", "https://example.com", "", false, []*http.Cookie{session, csrf}, "token", 200},
+		{"write from www", "PUT", "https://www.example.com", "", false, []*http.Cookie{session, csrf}, "token", 200},
+		{"write without token", "POST", "https://example.com", "", false, []*http.Cookie{session, csrf}, "", 403},
+		{"write with wrong token", "DELETE", "https://example.com", "", false, []*http.Cookie{session, csrf}, "guess", 403},
+		{"write without csrf cookie", "POST", "https://example.com", "", false, []*http.Cookie{session}, "token", 403},
+		{"write from another site", "POST", "https://evil.com", "", false, []*http.Cookie{session, csrf}, "token", 403},
+		{"write from another tenant", "POST", "https://other.com", "", false, []*http.Cookie{session, csrf}, "token", 403},
+		{"referer checked without origin", "POST", "", "https://evil.com/page", false, []*http.Cookie{session, csrf}, "token", 403},
+		{"plain http in production", "POST", "http://example.com", "", false, []*http.Cookie{session, csrf}, "token", 403},
+		{"localhost in production", "POST", "http://localhost:4321", "", false, []*http.Cookie{session, csrf}, "token", 403},
+		{"anonymous write needs no token", "POST", "https://example.com", "", false, nil, "", 200},
+		{"anonymous write from another site", "POST", "https://evil.com", "", false, nil, "", 403},
+		{"bearer is exempt", "POST", "https://evil.com", "", true, []*http.Cookie{session}, "", 200},
+		{"script without origin", "POST", "", "", false, []*http.Cookie{session, csrf}, "token", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "https://example.com/api/posts", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if tt.bearer {
+				r.Header.Set("Authorization", "Bearer cw_token")
+			}
+			for _, c := range tt.cookies {
+				r.AddCookie(c)
+			}
+			if tt.token != "" {
+				r.Header.Set(CSRFHeader, tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAllowedOrigin(t *testing.T) {
+	withSites(t, map[string]SiteConfig{
+		"example.com":       {ID: "example"},
+		"example.localhost": {ID: "example"},
+	})
+	t.Setenv("ALLOWED_DOMAINS", "partner.org")
+
+	tests := []struct {
+		origin     string
+		production bool
+		want       bool
+	}{
+		{"https://example.com", true, true},
+		{"https://www.example.com", true, true},
+		{"https://partner.org", true, true},
+		{"https://example.com.evil.com", true, false},
+		{"https://evil-localhost.com", false, false},
+		{"http://localhost.evil.com", false, false},
+		{"https://unknown.com", false, false},
+		{"null", false, false},
+		{"http://example.localhost:4321", false, true},
+		{"http://localhost:4321", false, true},
+		{"http://localhost:4321", true, false},
+	}
+	for _, tt := range tests {
+		env := ""
+		if tt.production {
+			env = "production"
+		}
+		t.Setenv("NODE_ENV", env)
+		if got := isAllowedOrigin(tt.origin); got != tt.want {
+			t.Errorf("isAllowedOrigin(%q) production=%v = %v, want %v", tt.origin, tt.production, got, tt.want)
+		}
+	}
+}
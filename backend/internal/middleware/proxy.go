@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// defaultTrustedProxies are where the site's own reverse proxy connects from when
+// TRUSTED_PROXIES isn't set: the same host, or a Docker or private network
+const defaultTrustedProxies = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []*net.IPNet
+)
+
+// parseTrustedProxies reads a comma-separated list of IPs and CIDR ranges, skipping
+// entries it can't parse
+func parseTrustedProxies(value string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isTrusted(ip string, proxies []*net.IPNet) bool {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClientIP returns the caller's IP. X-Forwarded-For and X-Real-IP are only believed
+// when the connection comes from a trusted proxy (TRUSTED_PROXIES), as anyone else can
+// set them to whatever they like.
+func GetClientIP(r *http.Request) string {
+	trustedProxiesOnce.Do(func() {
+		value, ok := os.LookupEnv("TRUSTED_PROXIES")
+		if !ok {
+			value = defaultTrustedProxies
+		}
+		trustedProxies = parseTrustedProxies(value)
+	})
+	return clientIP(r, trustedProxies)
+}
+
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrusted(remote, proxies) {
+		return remote
+	}
+
+	// Each proxy appends the address it was connected from, so the client is the last
+	// entry that isn't one of our proxies; anything before it is the client's own claim
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			if !isTrusted(hop, proxies) || i == 0 {
+				return hop
+			}
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return remote
+}
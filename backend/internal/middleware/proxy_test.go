@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies := parseTrustedProxies("127.0.0.1, 10.0.0.0/8, bogus")
+	if len(proxies) != 2 {
+		t.Fatalf("parsed %d proxies, want 2", len(proxies))
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"direct client forging headers", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"through the proxy", "127.0.0.1:5000", "203.0.113.7", "", "203.0.113.7"},
+		{"client prepending a fake hop", "127.0.0.1:5000", "198.51.100.1, 203.0.113.7", "", "203.0.113.7"},
+		{"through two proxies", "127.0.0.1:5000", "203.0.113.7, 10.0.0.5", "", "203.0.113.7"},
+		{"only proxies", "127.0.0.1:5000", "10.0.0.6, 10.0.0.5", "", "10.0.0.6"},
+		{"garbage hop", "127.0.0.1:5000", "203.0.113.7, not-an-ip", "", "127.0.0.1"},
+		{"real IP from the proxy", "10.1.2.3:5000", "", "203.0.113.7", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := clientIP(r, proxies); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -6,21 +6,63 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+
+	"github.com/coders-website/backend/internal/oidc"
+	"github.com/coders-website/backend/internal/passwords"
+	"github.com/coders-website/backend/internal/privacy"
 )
 
 type SiteConfig struct {
 	ID       string   `json:"id"`
+	Name     string   `json:"name"`
 	Directory string  `json:"directory"`
 	Database string   `json:"database"`
 	Theme    string   `json:"theme"`
 	Features []string `json:"features"`
+	MailFrom string   `json:"mailFrom,omitempty"` // From-address for emails sent on behalf of the site
+	OIDC     []oidc.Config `json:"oidc,omitempty"` // Social and single sign-on login providers
+	AccountDeletion privacy.Policy `json:"accountDeletion,omitempty"` // Grace period and what happens to a deleted user's posts
+	PasswordPolicy passwords.Policy `json:"passwordPolicy,omitempty"` // Length, character classes and banned words for new passwords
+	Identity string `json:"identity,omitempty"` // IdentityGlobal to share accounts with other sites; isolated local users otherwise
+}
+
+// IdentityGlobal puts a site's users in the global identity store, so one account can
+// be a member of several sites and move between them with single sign-on
+const IdentityGlobal = "global"
+
+// SharesIdentity reports whether the site uses the global identity store
+func (c SiteConfig) SharesIdentity() bool {
+	return c.Identity == IdentityGlobal
+}
+
+// FeatureAdmin2FA makes two-factor authentication mandatory for admins on a site
+const FeatureAdmin2FA = "admin-2fa"
+
+// FeatureMagicLink lets users of a site sign in with a link sent to their email
+const FeatureMagicLink = "magic-link"
+
+// HasFeature reports whether the site has the named feature enabled
+func (c SiteConfig) HasFeature(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
 }
 
 var sitesConfig map[string]SiteConfig
 
 func init() {
-	// Load sites configuration
+	LoadSitesConfig()
+}
+
+// LoadSitesConfig reads the sites configuration from SITES_CONFIG_PATH. It runs when
+// the package loads, before commands have read their .env file, so they call it again
+// once they have.
+func LoadSitesConfig() {
 	configPath := os.Getenv("SITES_CONFIG_PATH")
 	if configPath == "" {
 		configPath = "/app/sites-config.json"
@@ -113,6 +155,14 @@ func GetTenantID(r *http.Request) string {
 	return "default"
 }
 
+// GetTenantDomain retrieves the tenant domain from the request context
+func GetTenantDomain(r *http.Request) string {
+	if domain, ok := r.Context().Value("tenant_domain").(string); ok {
+		return domain
+	}
+	return ""
+}
+
 // GetTenantDatabase retrieves the tenant database from the request context
 func GetTenantDatabase(r *http.Request) string {
 	if tenantDB, ok := r.Context().Value("tenant_database").(string); ok {
@@ -127,4 +177,58 @@ func GetTenantConfig(r *http.Request) SiteConfig {
 		return config
 	}
 	return sitesConfig["default"]
-}
\ No newline at end of file
+}
+
+// GetAllTenantDatabases returns the distinct tenant databases from the sites configuration
+func GetAllTenantDatabases() []string {
+	seen := make(map[string]bool)
+	var databases []string
+	for _, config := range sitesConfig {
+		if config.Database != "" && !seen[config.Database] {
+			seen[config.Database] = true
+			databases = append(databases, config.Database)
+		}
+	}
+	sort.Strings(databases)
+	return databases
+}
+
+// GetConfigForDatabase returns the configuration of a site using the given tenant
+// database, for work done outside a request
+func GetConfigForDatabase(database string) SiteConfig {
+	for _, domain := range GetDomainsForDatabase(database) {
+		return sitesConfig[domain]
+	}
+	return sitesConfig["default"]
+}
+
+// GetDomainsForDatabase returns every configured domain that uses the given tenant database
+func GetDomainsForDatabase(database string) []string {
+	var domains []string
+	for domain, config := range sitesConfig {
+		if config.Database == database && domain != "default" {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// GetSiteURLForDatabase returns the public base URL of a site using the given tenant
+// database, for sending users to another site. It is empty if the site has no domain
+// reachable from a browser.
+func GetSiteURLForDatabase(database string) string {
+	for _, domain := range GetDomainsForDatabase(database) {
+		if strings.HasPrefix(domain, "www.") {
+			continue
+		}
+		if IsLocalHost(domain) {
+			if IsProduction() {
+				continue
+			}
+			return "http://" + domain
+		}
+		return "https://" + domain
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import "testing"
+
+func TestGetSiteURLForDatabase(t *testing.T) {
+	withSites(t, map[string]SiteConfig{
+		"www.example.com":  {ID: "example", Database: "example_db"},
+		"example.com":      {ID: "example", Database: "example_db"},
+		"blog.localhost":   {ID: "local", Database: "local_db"},
+		"www.only-www.com": {ID: "www", Database: "www_db"},
+		"default":          {ID: "default", Database: "default_db"},
+	})
+
+	tests := []struct {
+		database   string
+		production bool
+		want       string
+	}{
+		{"example_db", true, "https://example.com"},
+		{"local_db", false, "http://blog.localhost"},
+		{"local_db", true, ""},
+		{"www_db", false, ""},
+		{"default_db", false, ""},
+		{"missing_db", false, ""},
+	}
+	for _, tt := range tests {
+		env := ""
+		if tt.production {
+			env = "production"
+		}
+		t.Setenv("NODE_ENV", env)
+		if got := GetSiteURLForDatabase(tt.database); got != tt.want {
+			t.Errorf("GetSiteURLForDatabase(%q) in production=%v = %q, want %q", tt.database, tt.production, got, tt.want)
+		}
+	}
+}
+
+func TestSharesIdentity(t *testing.T) {
+	if (SiteConfig{}).SharesIdentity() {
+		t.Error("sites keep their own users by default")
+	}
+	if !(SiteConfig{Identity: IdentityGlobal}).SharesIdentity() {
+		t.Error(`"identity": "global" should share identities`)
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APITokenPrefix starts every personal access token, so leaked tokens are easy to
+// recognise and scan for
+const APITokenPrefix = "dhw_"
+
+// APIToken is a personal access token for scripts and CI. It acts as its owner, limited
+// to its scopes, and only its hash is stored.
+type APIToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	Name       string             `bson:"name" json:"name"`
+	Hint       string             `bson:"hint" json:"hint"` // First characters of the token, to tell tokens apart
+	TokenHash  string             `bson:"tokenHash" json:"-"`
+	Scopes     []Permission       `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	LastUsedIP string             `bson:"lastUsedIp,omitempty" json:"lastUsedIp,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// HasScope reports whether the token was granted perm
+func (t *APIToken) HasScope(perm Permission) bool {
+	for _, scope := range t.Scopes {
+		if scope == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited actions
+const (
+	AuditUserCreate          = "user.create"
+	AuditUserUpdate          = "user.update"
+	AuditUserDelete          = "user.delete"
+	AuditUserApprove         = "user.approve"
+	AuditUserRoleChange      = "user.role_change"
+	AuditUserUnlock          = "user.unlock"
+	AuditUserExport          = "user.export"
+	AuditUserDeletionRequest = "user.deletion_request"
+	AuditUserDeletionCancel  = "user.deletion_cancel"
+	AuditUserErase           = "user.erase"
+	AuditUserIdentityLink    = "user.identity_link"
+	AuditImpersonationStart  = "impersonation.start"
+	AuditImpersonationStop   = "impersonation.stop"
+	AuditInvitationCreate    = "invitation.create"
+	AuditInvitationResend    = "invitation.resend"
+	AuditInvitationRevoke    = "invitation.revoke"
+	AuditInvitationAccept    = "invitation.accept"
+	AuditPostCreate          = "post.create"
+	AuditPostUpdate          = "post.update"
+	AuditPostDelete          = "post.delete"
+	AuditCategoryCreate      = "category.create"
+	AuditCategoryUpdate      = "category.update"
+	AuditCategoryDelete      = "category.delete"
+	AuditCategoryMerge       = "category.merge"
+	AuditUploadCreate        = "upload.create"
+	AuditUploadDelete        = "upload.delete"
+	AuditSocialPublish       = "social.publish"
+	AuditSocialCredentials   = "social.credentials_update"
+	AuditComponentUpdate     = "component_data.update"
+	AuditComponentReorder    = "component_data.reorder"
+)
+
+// AuditEntry records who changed what. The audit log is append-only: entries are never
+// updated or deleted through the API.
+type AuditEntry struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ActorID    *primitive.ObjectID `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	ActorEmail string              `bson:"actorEmail,omitempty" json:"actorEmail,omitempty"`
+	ActorRole  string              `bson:"actorRole,omitempty" json:"actorRole,omitempty"`
+	// Set when an admin acted as the actor through impersonation
+	ImpersonatorID    *primitive.ObjectID `bson:"impersonatorId,omitempty" json:"impersonatorId,omitempty"`
+	ImpersonatorEmail string              `bson:"impersonatorEmail,omitempty" json:"impersonatorEmail,omitempty"`
+	IP                string              `bson:"ip" json:"ip"`
+	UserAgent         string              `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	Action            string              `bson:"action" json:"action"`
+	TargetType        string              `bson:"targetType" json:"targetType"`
+	TargetID          string              `bson:"targetId,omitempty" json:"targetId,omitempty"`
+	Changes           []FieldChange       `bson:"changes,omitempty" json:"changes,omitempty"`
+	CreatedAt         time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+// FieldChange is one field's value before and after a change. Field is a dotted path
+// such as "social.reddit.username".
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// auditRedacted replaces the values of fields that hold credentials
+const auditRedacted = "[redacted]"
+
+// auditMaxString caps how much of a long value, such as a post body, is kept
+const auditMaxString = 500
+
+// auditSecretFields are field names whose values never go into the audit log, lower
+// case without underscores so both "api_key" and "apiKey" match
+var auditSecretFields = map[string]bool{
+	"password":          true,
+	"clientsecret":      true,
+	"apikey":            true,
+	"apisecret":         true,
+	"accesstoken":       true,
+	"accesstokensecret": true,
+	"pageaccesstoken":   true,
+	"secret":            true,
+	"token":             true,
+	"tokenhash":         true,
+}
+
+// auditIgnoredFields change on every write and would only add noise
+var auditIgnoredFields = map[string]bool{
+	"updatedAt": true,
+}
+
+// DiffFields compares two values by their JSON form and lists the fields that differ.
+// Either may be nil, for creations and deletions. Credentials are redacted and long
+// strings truncated.
+func DiffFields(before, after interface{}) []FieldChange {
+	beforeFields := flattenForAudit(before)
+	afterFields := flattenForAudit(after)
+
+	names := make([]string, 0, len(beforeFields)+len(afterFields))
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		oldValue, newValue := beforeFields[name], afterFields[name]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		change := FieldChange{Field: name, Before: auditValue(oldValue), After: auditValue(newValue)}
+		if isAuditSecret(name) {
+			change.Before, change.After = redactAuditValue(oldValue), redactAuditValue(newValue)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// flattenForAudit turns a value into a map of dotted paths to JSON values. Arrays are
+// kept whole.
+func flattenForAudit(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded == nil {
+		return fields
+	}
+	object, ok := decoded.(map[string]interface{})
+	if !ok {
+		fields["value"] = decoded
+		return fields
+	}
+	flattenInto(fields, "", object)
+	return fields
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, object map[string]interface{}) {
+	for key, value := range object {
+		if prefix == "" && auditIgnoredFields[key] {
+			continue
+		}
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenInto(fields, name, nested)
+			continue
+		}
+		fields[name] = value
+	}
+}
+
+// isAuditSecret reports whether a dotted field path ends in a credential
+func isAuditSecret(name string) bool {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return auditSecretFields[strings.ToLower(strings.ReplaceAll(name, "_", ""))]
+}
+
+func auditValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok && len(s) > auditMaxString {
+		return s[:auditMaxString] + "…"
+	}
+	if list, ok := v.([]interface{}); ok {
+		data, _ := json.Marshal(list)
+		if len(data) > auditMaxString {
+			return string(data[:auditMaxString]) + "…"
+		}
+	}
+	return v
+}
+
+// redactAuditValue hides a credential but keeps whether it was set
+func redactAuditValue(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return auditRedacted
+}
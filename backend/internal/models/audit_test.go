@@ -0,0 +1,64 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	before := User{
+		Name:  "Ada",
+		Email: "ada@example.com",
+		Role:  RoleAuthor,
+		Social: &SocialCredentials{
+			Reddit: &RedditCredentials{Username: "ada", Password: "old-secret"},
+		},
+	}
+	after := before
+	after.Role = RoleEditor
+	after.Social = &SocialCredentials{
+		Reddit: &RedditCredentials{Username: "ada", Password: "new-secret"},
+	}
+
+	want := []FieldChange{
+		{Field: "role", Before: RoleAuthor, After: RoleEditor},
+		{Field: "social.reddit.password", Before: auditRedacted, After: auditRedacted},
+	}
+	if got := DiffFields(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffFields = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffFieldsCreateAndDelete(t *testing.T) {
+	post := map[string]interface{}{"title": "Hello", "content": strings.Repeat("x", 2000), "tags": []string{"go"}}
+
+	created := DiffFields(nil, post)
+	if len(created) != 3 {
+		t.Fatalf("created = %+v", created)
+	}
+	for _, change := range created {
+		if change.Before != nil {
+			t.Errorf("%s has a before value on create", change.Field)
+		}
+		if s, ok := change.After.(string); ok && len(s) > auditMaxString+len("…") {
+			t.Errorf("%s was not truncated", change.Field)
+		}
+	}
+
+	deleted := DiffFields(post, nil)
+	if len(deleted) != 3 || deleted[0].After != nil {
+		t.Errorf("deleted = %+v", deleted)
+	}
+
+	credentials := DiffFields(nil, map[string]interface{}{"twitter": map[string]string{"apiKey": "k", "accessTokenSecret": "s"}})
+	for _, change := range credentials {
+		if change.After != auditRedacted {
+			t.Errorf("%s was not redacted: %v", change.Field, change.After)
+		}
+	}
+
+	if changes := DiffFields(post, post); len(changes) != 0 {
+		t.Errorf("unchanged value produced %+v", changes)
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes of single-use tokens sent by email
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeMagicLink     = "magic_link"
+)
+
+// AuthToken is a single-use, expiring token delivered out of band (usually by email).
+// Only the hash of the token is stored.
+type AuthToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Purpose   string             `bson:"purpose" json:"purpose"`
+	UserID    primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"`
+	Email     string             `bson:"email" json:"email"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+}
@@ -1,19 +1,61 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Category struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name      string             `bson:"name" json:"name" validate:"required"`
-	Slug      string             `bson:"slug" json:"slug" validate:"required"`
-	Type      string             `bson:"type" json:"type" validate:"required,oneof=blog docs"`
-	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ID              primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name            string               `bson:"name" json:"name" validate:"required"`
+	Slug            string               `bson:"slug" json:"slug" validate:"required"`
+	Type            string               `bson:"type" json:"type" validate:"required,oneof=blog docs"`
+	ParentID        *primitive.ObjectID  `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	Order           int                  `bson:"order" json:"order"`
+	Description     string               `bson:"description,omitempty" json:"description,omitempty"`
+	CoverImage      string               `bson:"coverImage,omitempty" json:"coverImage,omitempty"`
+	SEOTitle        string               `bson:"seoTitle,omitempty" json:"seoTitle,omitempty" validate:"max=70"`
+	MetaDescription string               `bson:"metaDescription,omitempty" json:"metaDescription,omitempty" validate:"max=160"`
+	Color           string               `bson:"color,omitempty" json:"color,omitempty"`
+	Icon            string               `bson:"icon,omitempty" json:"icon,omitempty"`
+	FeaturedPostIDs []primitive.ObjectID `bson:"featuredPostIds,omitempty" json:"featuredPostIds,omitempty"`
+	PostCount       int64                `bson:"-" json:"postCount"` // Live count, never stored
+	CreatedAt       time.Time            `bson:"createdAt" json:"createdAt"`
+}
+
+const (
+	MaxCategoryDescriptionLength = 2000
+	MaxSEOTitleLength            = 70
+	MaxMetaDescriptionLength     = 160
+	MaxFeaturedPosts             = 12
+)
+
+var (
+	categorySlugPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+	hexColorPattern     = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	iconPattern         = regexp.MustCompile(`^[a-zA-Z0-9:_-]{1,64}$`)
+)
+
+// CategoryNode is a category with its nested children, as returned by the tree endpoint
+type CategoryNode struct {
+	Category
+	TotalPosts int64           `json:"totalPosts"` // Includes posts in all descendants
+	Children   []*CategoryNode `json:"children"`
+}
+
+// CategoryCrumb is one step of a post's category breadcrumb trail
+type CategoryCrumb struct {
+	ID   primitive.ObjectID `json:"id"`
+	Name string             `json:"name"`
+	Slug string             `json:"slug"`
 }
 
 func (c *Category) GenerateSlug() {
@@ -23,4 +65,157 @@ func (c *Category) GenerateSlug() {
 	reg := regexp.MustCompile("[^a-z0-9-]+")
 	slug = reg.ReplaceAllString(slug, "")
 	c.Slug = slug
-}
\ No newline at end of file
+}
+
+// Validate checks the category's fields, returning a user-facing error for the first problem found
+func (c *Category) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return errors.New("name is required")
+	}
+	if !categorySlugPattern.MatchString(c.Slug) {
+		return errors.New("slug may only contain lowercase letters, numbers and hyphens")
+	}
+	if c.Type != "blog" && c.Type != "docs" {
+		return errors.New("type must be blog or docs")
+	}
+	if utf8.RuneCountInString(c.Description) > MaxCategoryDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters", MaxCategoryDescriptionLength)
+	}
+	if c.CoverImage != "" && !isImageReference(c.CoverImage) {
+		return errors.New("coverImage must be an /uploads/ path or an http(s) URL")
+	}
+	if utf8.RuneCountInString(c.SEOTitle) > MaxSEOTitleLength {
+		return fmt.Errorf("seoTitle must be at most %d characters", MaxSEOTitleLength)
+	}
+	if utf8.RuneCountInString(c.MetaDescription) > MaxMetaDescriptionLength {
+		return fmt.Errorf("metaDescription must be at most %d characters", MaxMetaDescriptionLength)
+	}
+	if c.Color != "" && !hexColorPattern.MatchString(c.Color) {
+		return errors.New("color must be a hex colour like #1e40af")
+	}
+	if c.Icon != "" && !iconPattern.MatchString(c.Icon) {
+		return errors.New("icon must be an icon name of letters, numbers, ':', '_' or '-'")
+	}
+	if len(c.FeaturedPostIDs) > MaxFeaturedPosts {
+		return fmt.Errorf("at most %d featured posts are allowed", MaxFeaturedPosts)
+	}
+	return nil
+}
+
+func isImageReference(ref string) bool {
+	if strings.HasPrefix(ref, "/uploads/") && !strings.Contains(ref, "..") {
+		return true
+	}
+	u, err := url.Parse(ref)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// BuildCategoryTree nests categories under their parents, sorted by order then name.
+// Categories whose parent is missing are treated as roots so nothing is hidden.
+func BuildCategoryTree(categories []Category, postCounts map[primitive.ObjectID]int64) []*CategoryNode {
+	nodes := make(map[primitive.ObjectID]*CategoryNode, len(categories))
+	for _, c := range categories {
+		c.PostCount = postCounts[c.ID]
+		nodes[c.ID] = &CategoryNode{Category: c, Children: []*CategoryNode{}}
+	}
+
+	roots := []*CategoryNode{}
+	for _, c := range categories {
+		node := nodes[c.ID]
+		if c.ParentID != nil {
+			if parent, ok := nodes[*c.ParentID]; ok && parent != node {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	sortCategoryNodes(roots)
+	for _, root := range roots {
+		sumCategoryPosts(root)
+	}
+	return roots
+}
+
+func sortCategoryNodes(nodes []*CategoryNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Order != nodes[j].Order {
+			return nodes[i].Order < nodes[j].Order
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	for _, n := range nodes {
+		sortCategoryNodes(n.Children)
+	}
+}
+
+func sumCategoryPosts(node *CategoryNode) int64 {
+	node.TotalPosts = node.PostCount
+	for _, child := range node.Children {
+		node.TotalPosts += sumCategoryPosts(child)
+	}
+	return node.TotalPosts
+}
+
+// CategoryBreadcrumbs walks from the given category up to its root and returns the
+// trail root-first. Walking stops if a cycle or a missing parent is found.
+func CategoryBreadcrumbs(index map[primitive.ObjectID]Category, id primitive.ObjectID) []CategoryCrumb {
+	var trail []CategoryCrumb
+	seen := make(map[primitive.ObjectID]bool)
+	current, ok := index[id]
+	for ok && !seen[current.ID] {
+		seen[current.ID] = true
+		trail = append([]CategoryCrumb{{ID: current.ID, Name: current.Name, Slug: current.Slug}}, trail...)
+		if current.ParentID == nil {
+			break
+		}
+		current, ok = index[*current.ParentID]
+	}
+	return trail
+}
+
+// CategoryDescendants returns the IDs of every category below the given one
+func CategoryDescendants(categories []Category, id primitive.ObjectID) []primitive.ObjectID {
+	children := make(map[primitive.ObjectID][]primitive.ObjectID)
+	for _, c := range categories {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+
+	var result []primitive.ObjectID
+	seen := map[primitive.ObjectID]bool{id: true}
+	queue := []primitive.ObjectID{id}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for _, child := range children[next] {
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+			result = append(result, child)
+			queue = append(queue, child)
+		}
+	}
+	return result
+}
+
+// CreatesCategoryCycle reports whether making parentID the parent of id would
+// introduce a cycle, i.e. whether id is parentID itself or one of its ancestors.
+func CreatesCategoryCycle(index map[primitive.ObjectID]Category, id, parentID primitive.ObjectID) bool {
+	seen := make(map[primitive.ObjectID]bool)
+	current := parentID
+	for {
+		if current == id || seen[current] {
+			return true
+		}
+		seen[current] = true
+		c, ok := index[current]
+		if !ok || c.ParentID == nil {
+			return false
+		}
+		current = *c.ParentID
+	}
+}
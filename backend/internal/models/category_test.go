@@ -0,0 +1,220 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// categoryIDs are fixed IDs so test tables can refer to categories by number
+var categoryIDs = func() []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 6)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	return ids
+}()
+
+// testCategory returns category n, under parent p, or a root when p is negative
+func testCategory(n, p int, name string, order int) Category {
+	c := Category{ID: categoryIDs[n], Name: name, Slug: name, Order: order}
+	if p >= 0 {
+		c.ParentID = &categoryIDs[p]
+	}
+	return c
+}
+
+func categoryIndex(categories ...Category) map[primitive.ObjectID]Category {
+	index := make(map[primitive.ObjectID]Category, len(categories))
+	for _, c := range categories {
+		index[c.ID] = c
+	}
+	return index
+}
+
+func TestBuildCategoryTree(t *testing.T) {
+	missing := primitive.NewObjectID()
+	orphan := testCategory(3, -1, "orphan", 0)
+	orphan.ParentID = &missing
+	self := testCategory(4, 4, "self", 5)
+
+	tree := BuildCategoryTree([]Category{
+		testCategory(0, -1, "zeta", 1),
+		testCategory(1, 0, "child", 0),
+		testCategory(2, 1, "grandchild", 0),
+		orphan,
+		self,
+		testCategory(5, -1, "alpha", 1),
+	}, map[primitive.ObjectID]int64{categoryIDs[0]: 1, categoryIDs[1]: 2, categoryIDs[2]: 4, categoryIDs[3]: 8})
+
+	// Roots sort by order then name; orphans and self-parented categories stay visible
+	var roots []string
+	for _, n := range tree {
+		roots = append(roots, n.Name)
+	}
+	if want := []string{"orphan", "alpha", "zeta", "self"}; !equalStrings(roots, want) {
+		t.Fatalf("roots = %v, want %v", roots, want)
+	}
+
+	zeta := tree[2]
+	if len(zeta.Children) != 1 || len(zeta.Children[0].Children) != 1 {
+		t.Fatalf("zeta should have one child with one grandchild, got %+v", zeta.Children)
+	}
+	if zeta.PostCount != 1 || zeta.TotalPosts != 7 {
+		t.Errorf("zeta posts = %d, total = %d, want 1 and 7", zeta.PostCount, zeta.TotalPosts)
+	}
+	if tree[0].TotalPosts != 8 || tree[1].TotalPosts != 0 {
+		t.Errorf("orphan total = %d, alpha total = %d, want 8 and 0", tree[0].TotalPosts, tree[1].TotalPosts)
+	}
+	if len(BuildCategoryTree(nil, nil)) != 0 {
+		t.Error("no categories should build an empty tree")
+	}
+}
+
+func TestCategoryBreadcrumbs(t *testing.T) {
+	missing := primitive.NewObjectID()
+	dangling := testCategory(3, -1, "dangling", 0)
+	dangling.ParentID = &missing
+	index := categoryIndex(
+		testCategory(0, -1, "root", 0),
+		testCategory(1, 0, "child", 0),
+		testCategory(2, 1, "leaf", 0),
+		dangling,
+		testCategory(4, 5, "loop-a", 0),
+		testCategory(5, 4, "loop-b", 0),
+	)
+
+	cases := []struct {
+		name string
+		id   primitive.ObjectID
+		want []string
+	}{
+		{"root", categoryIDs[0], []string{"root"}},
+		{"leaf", categoryIDs[2], []string{"root", "child", "leaf"}},
+		{"missing parent", categoryIDs[3], []string{"dangling"}},
+		{"cycle", categoryIDs[4], []string{"loop-b", "loop-a"}},
+		{"unknown", primitive.NewObjectID(), nil},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, crumb := range CategoryBreadcrumbs(index, c.id) {
+			got = append(got, crumb.Name)
+		}
+		if !equalStrings(got, c.want) {
+			t.Errorf("%s: breadcrumbs = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCategoryDescendants(t *testing.T) {
+	categories := []Category{
+		testCategory(0, -1, "root", 0),
+		testCategory(1, 0, "child", 0),
+		testCategory(2, 1, "grandchild", 0),
+		testCategory(3, 0, "sibling", 0),
+		testCategory(4, 5, "loop-a", 0),
+		testCategory(5, 4, "loop-b", 0),
+	}
+
+	cases := []struct {
+		name string
+		id   int
+		want int
+	}{
+		{"root", 0, 3},
+		{"child", 1, 1},
+		{"leaf", 2, 0},
+		{"cycle", 4, 1}, // The loop back to itself isn't counted
+	}
+	for _, c := range cases {
+		got := CategoryDescendants(categories, categoryIDs[c.id])
+		if len(got) != c.want {
+			t.Errorf("%s: %d descendants, want %d", c.name, len(got), c.want)
+		}
+		for _, id := range got {
+			if id == categoryIDs[c.id] {
+				t.Errorf("%s: category is its own descendant", c.name)
+			}
+		}
+	}
+}
+
+func TestCreatesCategoryCycle(t *testing.T) {
+	index := categoryIndex(
+		testCategory(0, -1, "root", 0),
+		testCategory(1, 0, "child", 0),
+		testCategory(2, 1, "grandchild", 0),
+		testCategory(3, -1, "other", 0),
+		testCategory(4, 5, "loop-a", 0),
+		testCategory(5, 4, "loop-b", 0),
+	)
+
+	cases := []struct {
+		name          string
+		id, newParent int
+		want          bool
+	}{
+		{"own parent", 1, 1, true},
+		{"under own child", 0, 1, true},
+		{"under own grandchild", 0, 2, true},
+		{"under a sibling tree", 3, 2, false},
+		{"deeper in own branch", 2, 0, false},
+		{"under existing loop", 3, 4, true},
+	}
+	for _, c := range cases {
+		if got := CreatesCategoryCycle(index, categoryIDs[c.id], categoryIDs[c.newParent]); got != c.want {
+			t.Errorf("%s: CreatesCategoryCycle = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if CreatesCategoryCycle(index, categoryIDs[3], primitive.NewObjectID()) {
+		t.Error("an unknown parent can't create a cycle")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCategoryValidate(t *testing.T) {
+	valid := func() Category {
+		return Category{Name: "Guides", Slug: "guides", Type: "blog"}
+	}
+	cases := []struct {
+		name   string
+		change func(c *Category)
+		ok     bool
+	}{
+		{"valid", func(c *Category) {}, true},
+		{"no name", func(c *Category) { c.Name = " " }, false},
+		{"bad slug", func(c *Category) { c.Slug = "Guides!" }, false},
+		{"bad type", func(c *Category) { c.Type = "wiki" }, false},
+		{"non-ASCII description at the limit", func(c *Category) { c.Description = strings.Repeat("é", MaxCategoryDescriptionLength) }, true},
+		{"description too long", func(c *Category) { c.Description = strings.Repeat("a", MaxCategoryDescriptionLength+1) }, false},
+		{"non-ASCII SEO title at the limit", func(c *Category) { c.SEOTitle = strings.Repeat("ü", MaxSEOTitleLength) }, true},
+		{"meta description too long", func(c *Category) { c.MetaDescription = strings.Repeat("a", MaxMetaDescriptionLength+1) }, false},
+		{"uploaded cover", func(c *Category) { c.CoverImage = "/uploads/site/cover.png" }, true},
+		{"cover escaping uploads", func(c *Category) { c.CoverImage = "/uploads/../secret.png" }, false},
+		{"cover with another scheme", func(c *Category) { c.CoverImage = "javascript:alert(1)" }, false},
+		{"hex colour", func(c *Category) { c.Color = "#1e40af" }, true},
+		{"named colour", func(c *Category) { c.Color = "red" }, false},
+		{"icon name", func(c *Category) { c.Icon = "mdi:book-open" }, true},
+		{"icon markup", func(c *Category) { c.Icon = "<svg>" }, false},
+		{"too many featured posts", func(c *Category) { c.FeaturedPostIDs = make([]primitive.ObjectID, MaxFeaturedPosts+1) }, false},
+	}
+	for _, tc := range cases {
+		c := valid()
+		tc.change(&c)
+		if err := c.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identity is an account in the global identity store, shared by every site that opts
+// in. Each site it belongs to still has its own user record, linked by IdentityID and
+// holding the role, profile and settings there; the identity holds what is the same on
+// every site: the email address and password. Identities are only created and joined by
+// users whose email is verified; ones stored before that was checked may not be.
+type Identity struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email       string             `bson:"email" json:"email"` // Lower case
+	Password    string             `bson:"password" json:"-"`
+	Verified    bool               `bson:"verified" json:"verified"` // A member confirmed owning the email
+	Memberships []Membership       `bson:"memberships" json:"memberships"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Membership records that an identity has a user on a site, by the site's database
+type Membership struct {
+	Database string             `bson:"database" json:"-"`
+	UserID   primitive.ObjectID `bson:"userId" json:"-"`
+	JoinedAt time.Time          `bson:"joinedAt" json:"joinedAt"`
+}
+
+// Membership returns the identity's membership of the site using the database
+func (i *Identity) Membership(database string) *Membership {
+	for k := range i.Memberships {
+		if i.Memberships[k].Database == database {
+			return &i.Memberships[k]
+		}
+	}
+	return nil
+}
+
+// ComparePassword checks a password against the identity's
+func (i *Identity) ComparePassword(password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(i.Password), []byte(password))
+}
+
+// SSOTicket lets an identity signed in on one site start a session on another it is a
+// member of. It is used once, within a minute, and only on the site it was issued for.
+type SSOTicket struct {
+	ID             string             `bson:"_id"` // Hash of the ticket
+	IdentityID     primitive.ObjectID `bson:"identityId"`
+	Database       string             `bson:"database"`       // Site the ticket signs in to
+	SourceDatabase string             `bson:"sourceDatabase"` // Site that issued it
+	CreatedAt      time.Time          `bson:"createdAt"`
+	ExpiresAt      time.Time          `bson:"expiresAt"`
+}
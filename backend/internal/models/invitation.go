@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invitation states
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// Invitation lets someone join a site with a role an admin picked for them. The emailed
+// link works once; only the hash of its token is stored.
+type Invitation struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Email      string              `bson:"email" json:"email"`
+	Name       string              `bson:"name,omitempty" json:"name,omitempty"`
+	Role       string              `bson:"role" json:"role"`
+	TokenHash  string              `bson:"tokenHash" json:"-"`
+	InvitedBy  primitive.ObjectID  `bson:"invitedBy" json:"invitedBy"`
+	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
+	SentAt     time.Time           `bson:"sentAt" json:"sentAt"`
+	ExpiresAt  time.Time           `bson:"expiresAt" json:"expiresAt"`
+	AcceptedAt *time.Time          `bson:"acceptedAt,omitempty" json:"acceptedAt,omitempty"`
+	UserID     *primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"` // Account created on acceptance
+	RevokedAt  *time.Time          `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// Status reports whether the invitation can still be accepted, and if not, why
+func (i *Invitation) Status(now time.Time) string {
+	switch {
+	case i.AcceptedAt != nil:
+		return InvitationAccepted
+	case i.RevokedAt != nil:
+		return InvitationRevoked
+	case !now.Before(i.ExpiresAt):
+		return InvitationExpired
+	default:
+		return InvitationPending
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LinkReport is the result of one link-check run over a tenant's posts
+type LinkReport struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StartedAt    time.Time          `bson:"startedAt" json:"startedAt"`
+	FinishedAt   time.Time          `bson:"finishedAt" json:"finishedAt"`
+	External     bool               `bson:"external" json:"external"` // Whether external URLs were fetched
+	PostsChecked int                `bson:"postsChecked" json:"postsChecked"`
+	LinksChecked int                `bson:"linksChecked" json:"linksChecked"`
+	BrokenCount  int                `bson:"brokenCount" json:"brokenCount"`
+	Posts        []PostLinkReport   `bson:"posts" json:"posts"` // Only posts with broken links
+}
+
+type PostLinkReport struct {
+	PostID primitive.ObjectID `bson:"postId" json:"postId"`
+	Title  string             `bson:"title" json:"title"`
+	Slug   string             `bson:"slug" json:"slug"`
+	Type   string             `bson:"type" json:"type"`
+	Broken []BrokenLink       `bson:"broken" json:"broken"`
+}
+
+type BrokenLink struct {
+	URL    string `bson:"url" json:"url"`
+	Kind   string `bson:"kind" json:"kind"` // link or image
+	Reason string `bson:"reason" json:"reason"`
+}
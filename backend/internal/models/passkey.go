@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Passkey is a WebAuthn credential registered by a user. Stored in the tenant
+// database, so a passkey only works on the site it was created for.
+type Passkey struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID          primitive.ObjectID `bson:"userId" json:"userId"`
+	Name            string             `bson:"name" json:"name"`
+	CredentialID    []byte             `bson:"credentialId" json:"-"`
+	PublicKey       []byte             `bson:"publicKey" json:"-"`
+	AttestationType string             `bson:"attestationType" json:"-"`
+	Transports      []string           `bson:"transports,omitempty" json:"transports,omitempty"`
+	AAGUID          []byte             `bson:"aaguid,omitempty" json:"-"`
+	SignCount       uint32             `bson:"signCount" json:"-"`
+	UserVerified    bool               `bson:"userVerified" json:"-"`
+	BackupEligible  bool               `bson:"backupEligible" json:"-"`
+	BackupState     bool               `bson:"backupState" json:"synced"` // Synced passkeys live in a password manager or cloud keychain
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	LastUsedAt      *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+}
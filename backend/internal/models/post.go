@@ -1,10 +1,14 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -25,12 +29,21 @@ type Post struct {
 	Published   bool                `bson:"published" json:"published"`
 	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   time.Time           `bson:"updatedAt" json:"updatedAt"`
+
+	// SEO overrides; empty values fall back to the title, description and cover image
+	MetaTitle       string `bson:"metaTitle,omitempty" json:"metaTitle,omitempty" validate:"max=70"`
+	MetaDescription string `bson:"metaDescription,omitempty" json:"metaDescription,omitempty" validate:"max=160"`
+	CanonicalURL    string `bson:"canonicalUrl,omitempty" json:"canonicalUrl,omitempty"`
+	NoIndex         bool   `bson:"noindex,omitempty" json:"noindex,omitempty"`
+	OGImage         string `bson:"ogImage,omitempty" json:"ogImage,omitempty"`
 }
 
 type PostWithAuthor struct {
 	Post
-	AuthorData   *User     `json:"author_data,omitempty"`
-	CategoryData *Category `json:"category_data,omitempty"`
+	AuthorData   *AuthorProfile  `json:"author_data,omitempty"`
+	CategoryData *Category       `json:"category_data,omitempty"`
+	Breadcrumbs  []CategoryCrumb `json:"breadcrumbs,omitempty"`
+	OGImageURL   string          `json:"ogImageUrl,omitempty"` // Override, cover image or generated image
 }
 
 func (p *Post) GenerateSlug() {
@@ -68,4 +81,24 @@ func (p *Post) CalculateReadingTime() {
 	// Estimate ~200 words per minute
 	words := len(strings.Fields(p.Content))
 	p.ReadingTime = int(math.Ceil(float64(words) / 200.0))
-}
\ No newline at end of file
+}
+
+// ValidateSEO checks the post's SEO override fields
+func (p *Post) ValidateSEO() error {
+	if utf8.RuneCountInString(p.MetaTitle) > MaxSEOTitleLength {
+		return fmt.Errorf("metaTitle must be at most %d characters", MaxSEOTitleLength)
+	}
+	if utf8.RuneCountInString(p.MetaDescription) > MaxMetaDescriptionLength {
+		return fmt.Errorf("metaDescription must be at most %d characters", MaxMetaDescriptionLength)
+	}
+	if p.CanonicalURL != "" {
+		u, err := url.Parse(p.CanonicalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("canonicalUrl must be an absolute http(s) URL")
+		}
+	}
+	if p.OGImage != "" && !isImageReference(p.OGImage) {
+		return errors.New("ogImage must be an /uploads/ path or an http(s) URL")
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Profile is what readers see about a user: on author boxes, author pages and anywhere
+// else the site credits them. Unlike Social, it holds no credentials.
+type Profile struct {
+	Slug    string            `bson:"slug,omitempty" json:"slug,omitempty"` // Unique per site; the author page lives at /blog/author/<slug>
+	Bio     string            `bson:"bio,omitempty" json:"bio,omitempty"`
+	Website string            `bson:"website,omitempty" json:"website,omitempty"`
+	Avatar  *Avatar           `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	Social  map[string]string `bson:"social,omitempty" json:"social,omitempty"` // Public handles by network, e.g. "github": "ada"
+}
+
+// Avatar is a profile picture, cropped square and stored at each of AvatarSizes
+type Avatar struct {
+	URL       string               `bson:"url" json:"url"`     // The largest size
+	Sizes     map[string]string    `bson:"sizes" json:"sizes"` // Width in pixels -> URL
+	UploadIDs []primitive.ObjectID `bson:"uploadIds" json:"-"`
+}
+
+// AvatarSizes are the square sizes, in pixels, avatars are stored at
+var AvatarSizes = []int{64, 128, 256}
+
+// ProfileNetworks are the networks a profile can link to, with how a handle becomes a
+// link. Mastodon handles carry their own server (user@server).
+var ProfileNetworks = map[string]func(handle string) string{
+	"twitter":   func(h string) string { return "https://x.com/" + url.PathEscape(h) },
+	"github":    func(h string) string { return "https://github.com/" + url.PathEscape(h) },
+	"linkedin":  func(h string) string { return "https://www.linkedin.com/in/" + url.PathEscape(h) },
+	"youtube":   func(h string) string { return "https://www.youtube.com/@" + url.PathEscape(h) },
+	"instagram": func(h string) string { return "https://www.instagram.com/" + url.PathEscape(h) },
+	"devto":     func(h string) string { return "https://dev.to/" + url.PathEscape(h) },
+	"reddit":    func(h string) string { return "https://www.reddit.com/user/" + url.PathEscape(h) },
+	"bluesky":   func(h string) string { return "https://bsky.app/profile/" + url.PathEscape(h) },
+	"mastodon": func(h string) string {
+		user, server, _ := strings.Cut(h, "@")
+		return "https://" + server + "/@" + url.PathEscape(user)
+	},
+}
+
+const maxBioLength = 1000
+
+var (
+	slugPattern     = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	handlePattern   = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+	mastodonPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,100}@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
+)
+
+// NormalizeSlug lowercases a slug and trims surrounding space
+func NormalizeSlug(slug string) string {
+	return strings.ToLower(strings.TrimSpace(slug))
+}
+
+// ValidateSlug returns an error, fit to show the user, if the slug can't be used in a URL
+func ValidateSlug(slug string) error {
+	if len(slug) > 60 || !slugPattern.MatchString(slug) {
+		return errors.New("Profile URL may only contain lowercase letters, digits and single hyphens, up to 60 characters")
+	}
+	return nil
+}
+
+// ValidateBio returns an error, fit to show the user, if the bio is too long
+func ValidateBio(bio string) error {
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return fmt.Errorf("Bio must be at most %d characters", maxBioLength)
+	}
+	return nil
+}
+
+// NormalizeWebsite checks a website is an absolute http(s) URL, adding https:// when
+// the scheme is left out
+func NormalizeWebsite(website string) (string, error) {
+	website = strings.TrimSpace(website)
+	if website == "" {
+		return "", nil
+	}
+	if !strings.Contains(website, "://") {
+		website = "https://" + website
+	}
+	u, err := url.Parse(website)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("Website must be a http or https address")
+	}
+	return u.String(), nil
+}
+
+// NormalizeSocialHandles checks each network is one of ProfileNetworks and each handle
+// looks like a handle rather than a URL, dropping leading @s and empty handles
+func NormalizeSocialHandles(handles map[string]string) (map[string]string, error) {
+	normalized := map[string]string{}
+	for network, handle := range handles {
+		network = strings.ToLower(strings.TrimSpace(network))
+		handle = strings.TrimPrefix(strings.TrimSpace(handle), "@")
+		if handle == "" {
+			continue
+		}
+		if _, ok := ProfileNetworks[network]; !ok {
+			return nil, fmt.Errorf("Unknown social network %q", network)
+		}
+		pattern := handlePattern
+		if network == "mastodon" {
+			pattern = mastodonPattern
+		}
+		if !pattern.MatchString(handle) {
+			return nil, fmt.Errorf("Invalid %s handle %q", network, handle)
+		}
+		normalized[network] = handle
+	}
+	return normalized, nil
+}
+
+// AuthorProfile is the public view of a user, safe to return from public endpoints. It
+// carries no email address or credentials.
+type AuthorProfile struct {
+	ID          primitive.ObjectID `json:"id"`
+	Name        string             `json:"name"`
+	Slug        string             `json:"slug,omitempty"`
+	Bio         string             `json:"bio,omitempty"`
+	Website     string             `json:"website,omitempty"`
+	Avatar      *Avatar            `json:"avatar,omitempty"`
+	Social      map[string]string  `json:"social,omitempty"`
+	SocialLinks []SocialLink       `json:"socialLinks,omitempty"`
+}
+
+// SocialLink is a profile's link to one network
+type SocialLink struct {
+	Network string `json:"network"`
+	Handle  string `json:"handle"`
+	URL     string `json:"url"`
+}
+
+// PublicProfile returns the user's public view
+func (u *User) PublicProfile() *AuthorProfile {
+	author := &AuthorProfile{ID: u.ID, Name: u.Name}
+	if u.Profile == nil {
+		return author
+	}
+	author.Slug = u.Profile.Slug
+	author.Bio = u.Profile.Bio
+	author.Website = u.Profile.Website
+	author.Avatar = u.Profile.Avatar
+	author.Social = u.Profile.Social
+	for network, handle := range u.Profile.Social {
+		if link, ok := ProfileNetworks[network]; ok {
+			author.SocialLinks = append(author.SocialLinks, SocialLink{Network: network, Handle: handle, URL: link(handle)})
+		}
+	}
+	sort.Slice(author.SocialLinks, func(i, j int) bool {
+		return author.SocialLinks[i].Network < author.SocialLinks[j].Network
+	})
+	return author
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPublicProfileHasNoSecrets(t *testing.T) {
+	user := User{
+		Name:     "Ada",
+		Email:    "ada@example.com",
+		Password: "hash",
+		Social:   &SocialCredentials{Devto: &DevtoCredentials{APIKey: "devto-key"}},
+		Profile: &Profile{
+			Slug:   "ada",
+			Bio:    "Writes about engines",
+			Avatar: &Avatar{URL: "/uploads/avatars/a-256.png", UploadIDs: []primitive.ObjectID{primitive.NewObjectID()}},
+			Social: map[string]string{"mastodon": "ada@hachyderm.io", "github": "ada"},
+		},
+	}
+
+	profile := user.PublicProfile()
+	out, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"ada@example.com", "hash", "devto-key", "uploadIds"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("public profile contains %q: %s", secret, out)
+		}
+	}
+	want := []SocialLink{
+		{Network: "github", Handle: "ada", URL: "https://github.com/ada"},
+		{Network: "mastodon", Handle: "ada@hachyderm.io", URL: "https://hachyderm.io/@ada"},
+	}
+	if len(profile.SocialLinks) != len(want) {
+		t.Fatalf("links = %+v", profile.SocialLinks)
+	}
+	for i := range want {
+		if profile.SocialLinks[i] != want[i] {
+			t.Errorf("link %d = %+v, want %+v", i, profile.SocialLinks[i], want[i])
+		}
+	}
+}
+
+func TestProfileValidation(t *testing.T) {
+	for slug, valid := range map[string]bool{"ada-lovelace": true, "ada2": true, "Ada": false, "ada--x": false, "-ada": false, "ada/x": false} {
+		if err := ValidateSlug(slug); (err == nil) != valid {
+			t.Errorf("ValidateSlug(%q) = %v", slug, err)
+		}
+	}
+
+	for in, want := range map[string]string{"example.com": "https://example.com", "http://example.com/me": "http://example.com/me", "": ""} {
+		if got, err := NormalizeWebsite(in); err != nil || got != want {
+			t.Errorf("NormalizeWebsite(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := NormalizeWebsite("javascript:alert(1)"); err == nil {
+		t.Error("NormalizeWebsite accepted a javascript: URL")
+	}
+
+	handles, err := NormalizeSocialHandles(map[string]string{"GitHub": "@ada", "twitter": ""})
+	if err != nil || len(handles) != 1 || handles["github"] != "ada" {
+		t.Errorf("NormalizeSocialHandles = %v, %v", handles, err)
+	}
+	for _, bad := range []map[string]string{{"myspace": "ada"}, {"github": "https://github.com/ada"}, {"mastodon": "ada"}} {
+		if _, err := NormalizeSocialHandles(bad); err == nil {
+			t.Errorf("NormalizeSocialHandles(%v) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+// Roles a user can hold within a site
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleAuthor = "author"
+	RoleViewer = "viewer"
+
+	// RoleUser is the role accounts had before editors, authors and viewers existed.
+	// It grants the same permissions as RoleAuthor.
+	RoleUser = "user"
+)
+
+// Permission is something a role allows a user to do
+type Permission string
+
+const (
+	PermViewDrafts       Permission = "posts:view-drafts"
+	PermWritePosts       Permission = "posts:write"    // Create posts and edit your own
+	PermEditAnyPost      Permission = "posts:edit-any" // Edit and delete everyone's posts
+	PermUploadFiles      Permission = "uploads:write"
+	PermManageCategories Permission = "categories:manage"
+	PermPublishSocial    Permission = "social:publish"
+	PermManageUsers      Permission = "users:manage"
+	PermManageSettings   Permission = "settings:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleViewer: {PermViewDrafts},
+	RoleAuthor: {PermViewDrafts, PermWritePosts, PermUploadFiles, PermPublishSocial},
+	RoleEditor: {PermViewDrafts, PermWritePosts, PermUploadFiles, PermPublishSocial, PermEditAnyPost, PermManageCategories},
+	RoleAdmin: {PermViewDrafts, PermWritePosts, PermUploadFiles, PermPublishSocial, PermEditAnyPost, PermManageCategories,
+		PermManageUsers, PermManageSettings},
+}
+
+// Roles lists the roles that can be assigned, from most to least privileged
+var Roles = []string{RoleAdmin, RoleEditor, RoleAuthor, RoleViewer}
+
+// NormalizeRole maps legacy role names to their current equivalent
+func NormalizeRole(role string) string {
+	if role == RoleUser {
+		return RoleAuthor
+	}
+	return role
+}
+
+// ValidRole reports whether role can be assigned to a user. The legacy "user" role is
+// accepted so older clients keep working.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[NormalizeRole(role)]
+	return ok
+}
+
+// RoleHasPermission reports whether the role grants perm
+func RoleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[NormalizeRole(role)] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidPermission reports whether perm is a permission some role grants
+func ValidPermission(perm Permission) bool {
+	return RoleHasPermission(RoleAdmin, perm)
+}
+
+// Can reports whether the user's role grants perm. When the user is acting through an
+// API token, the token must also have been granted it.
+func (u *User) Can(perm Permission) bool {
+	if u == nil || !RoleHasPermission(u.Role, perm) {
+		return false
+	}
+	return u.APIToken == nil || u.APIToken.HasScope(perm)
+}
+
+// Permissions lists everything the user can currently do
+func (u *User) Permissions() []Permission {
+	permissions := []Permission{}
+	for _, perm := range rolePermissions[NormalizeRole(u.Role)] {
+		if u.Can(perm) {
+			permissions = append(permissions, perm)
+		}
+	}
+	return permissions
+}
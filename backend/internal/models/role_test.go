@@ -0,0 +1,64 @@
+package models
+
+import "testing"
+
+func TestRolePermissions(t *testing.T) {
+	cases := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleViewer, PermViewDrafts, true},
+		{RoleViewer, PermWritePosts, false},
+		{RoleAuthor, PermWritePosts, true},
+		{RoleAuthor, PermEditAnyPost, false},
+		{RoleUser, PermWritePosts, true}, // Legacy role behaves like author
+		{RoleUser, PermEditAnyPost, false},
+		{RoleEditor, PermEditAnyPost, true},
+		{RoleEditor, PermManageUsers, false},
+		{RoleAdmin, PermManageUsers, true},
+		{RoleAdmin, PermManageSettings, true},
+		{"", PermViewDrafts, false},
+		{"superuser", PermViewDrafts, false},
+	}
+	for _, c := range cases {
+		if got := (&User{Role: c.role}).Can(c.perm); got != c.want {
+			t.Errorf("role %q can %s = %v, want %v", c.role, c.perm, got, c.want)
+		}
+	}
+
+	var nobody *User
+	if nobody.Can(PermViewDrafts) {
+		t.Error("nil user should have no permissions")
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range append(Roles, RoleUser) {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%q) = false", role)
+		}
+	}
+	if ValidRole("owner") || ValidRole("") {
+		t.Error("unknown roles should be rejected")
+	}
+	if NormalizeRole(RoleUser) != RoleAuthor {
+		t.Errorf("NormalizeRole(user) = %q", NormalizeRole(RoleUser))
+	}
+}
+
+func TestAPITokenLimitsPermissions(t *testing.T) {
+	author := &User{Role: RoleAuthor, APIToken: &APIToken{Scopes: []Permission{PermWritePosts, PermManageUsers}}}
+	if !author.Can(PermWritePosts) {
+		t.Error("token scope granted by the role should be allowed")
+	}
+	if author.Can(PermUploadFiles) {
+		t.Error("permissions outside the token's scopes should be denied")
+	}
+	if author.Can(PermManageUsers) {
+		t.Error("a token scope can't exceed the owner's role")
+	}
+	if got := author.Permissions(); len(got) != 1 || got[0] != PermWritePosts {
+		t.Errorf("Permissions() = %v", got)
+	}
+}
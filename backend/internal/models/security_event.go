@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types of security events
+const (
+	EventLoginSucceeded  = "login_succeeded"
+	EventLoginFailed     = "login_failed"
+	EventLoginThrottled  = "login_throttled"
+	EventAccountLocked   = "account_locked"
+	EventAccountUnlocked = "account_unlocked"
+	EventMagicLinkSent   = "magic_link_sent"
+	EventSSOTicketIssued = "sso_ticket_issued" // A signed-in user switched to another site
+)
+
+// SecurityEvent records a sign-in attempt or lockout for admins to review
+type SecurityEvent struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Type           string              `bson:"type" json:"type"`
+	Email          string              `bson:"email,omitempty" json:"email,omitempty"`
+	UserID         *primitive.ObjectID `bson:"userId,omitempty" json:"userId,omitempty"`
+	ActorID        *primitive.ObjectID `bson:"actorId,omitempty" json:"actorId,omitempty"`               // Admin who acted, e.g. for unlocks
+	ImpersonatorID *primitive.ObjectID `bson:"impersonatorId,omitempty" json:"impersonatorId,omitempty"` // Admin impersonating the actor
+	IP             string              `bson:"ip" json:"ip"`
+	UserAgent      string              `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	Detail         string              `bson:"detail,omitempty" json:"detail,omitempty"`
+	CreatedAt      time.Time           `bson:"createdAt" json:"createdAt"`
+}
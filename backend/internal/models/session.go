@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login on one device. The refresh token rotates on every use; the
+// session document is the token family, so reuse of a rotated-out token revokes it.
+type Session struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"userId" json:"userId"`
+	TokenHash     string             `bson:"tokenHash" json:"-"`
+	UsedHashes    []string           `bson:"usedHashes,omitempty" json:"-"` // Recently rotated-out tokens
+	UserAgent     string             `bson:"userAgent" json:"userAgent"`
+	IP            string             `bson:"ip" json:"ip"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	LastUsedAt    time.Time          `bson:"lastUsedAt" json:"lastUsedAt"`
+	ExpiresAt     time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt     *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokedReason string             `bson:"revokedReason,omitempty" json:"revokedReason,omitempty"`
+
+	// Impersonation sessions let an admin act as the user. They can't be refreshed and
+	// end with the admin's own session (ParentSessionID).
+	ImpersonatorID  *primitive.ObjectID `bson:"impersonatorId,omitempty" json:"impersonatorId,omitempty"`
+	ParentSessionID *primitive.ObjectID `bson:"parentSessionId,omitempty" json:"-"`
+}
+
+// Active reports whether the session can still be used
+func (s *Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
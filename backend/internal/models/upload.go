@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Upload records a file stored by the upload handlers, so only its uploader (or an
+// editor) can manage it later
+type Upload struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID  `bson:"userId" json:"userId"`
+	PostID       *primitive.ObjectID `bson:"postId,omitempty" json:"postId,omitempty"`
+	URL          string              `bson:"url" json:"url"`
+	Path         string              `bson:"path" json:"-"` // Location on disk
+	OriginalName string              `bson:"originalName" json:"originalName"`
+	Size         int64               `bson:"size" json:"size"`
+	Kind         string              `bson:"kind,omitempty" json:"kind,omitempty"` // UploadKindAvatar, or empty for files in posts
+	CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+// UploadKindAvatar marks the stored sizes of a profile picture, which belong to the
+// user pictured rather than whoever uploaded them
+const UploadKindAvatar = "avatar"
@@ -3,20 +3,65 @@ package models
 import (
 	"time"
 
+	"github.com/coders-website/backend/internal/passwords"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name      string             `bson:"name" json:"name" validate:"required"`
-	Email     string             `bson:"email" json:"email" validate:"required,email"`
-	Password  string             `bson:"password" json:"-"`
-	Role      string             `bson:"role" json:"role" validate:"required,oneof=admin user"`
-	Approved  bool               `bson:"approved" json:"approved"`
-	Social    *SocialCredentials `bson:"social,omitempty" json:"social,omitempty"`
-	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name     string             `bson:"name" json:"name" validate:"required"`
+	Email    string             `bson:"email" json:"email" validate:"required,email"`
+	Password string             `bson:"password" json:"-"`
+	Role     string             `bson:"role" json:"role" validate:"required,oneof=admin editor author viewer user"`
+	Approved bool               `bson:"approved" json:"approved"`
+	// EmailVerified is set once the user follows the link sent on registration
+	EmailVerified   bool               `bson:"emailVerified" json:"emailVerified"`
+	EmailVerifiedAt *time.Time         `bson:"emailVerifiedAt,omitempty" json:"emailVerifiedAt,omitempty"`
+	Social          *SocialCredentials `bson:"social,omitempty" json:"social,omitempty"`
+	TwoFactor       *TwoFactor         `bson:"twoFactor,omitempty" json:"twoFactor,omitempty"`
+	Identities      []ExternalIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+	Profile         *Profile           `bson:"profile,omitempty" json:"profile,omitempty"`
+	// IdentityID links the user to their account in the global identity store, on sites
+	// that share one. Linked users sign in with the identity's password, not Password.
+	IdentityID *primitive.ObjectID `bson:"identityId,omitempty" json:"identityId,omitempty"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time          `bson:"updatedAt" json:"updatedAt"`
+	// DeletionScheduledAt is when a requested account deletion goes ahead; until then it
+	// can be cancelled. DeletedAt marks an account that was erased but kept, anonymized,
+	// as the author of its posts.
+	DeletionScheduledAt *time.Time `bson:"deletionScheduledAt,omitempty" json:"deletionScheduledAt,omitempty"`
+	DeletedAt           *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+
+	// APIToken is set when the request was authenticated with a personal access token,
+	// and limits the user's permissions to the token's scopes
+	APIToken *APIToken `bson:"-" json:"-"`
+}
+
+// TwoFactor holds a user's TOTP enrolment. Secrets and recovery code hashes never
+// leave the server.
+type TwoFactor struct {
+	Enabled       bool       `bson:"enabled" json:"enabled"`
+	Secret        string     `bson:"secret,omitempty" json:"-"`
+	PendingSecret string     `bson:"pendingSecret,omitempty" json:"-"` // Set during enrolment until the first code is verified
+	LastStep      int64      `bson:"lastStep" json:"-"`                // Last accepted TOTP time step, to block replays
+	RecoveryCodes []string   `bson:"recoveryCodes,omitempty" json:"-"` // Hashes of unused recovery codes
+	FailedCount   int        `bson:"failedCount,omitempty" json:"-"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty" json:"-"`
+	EnabledAt     *time.Time `bson:"enabledAt,omitempty" json:"enabledAt,omitempty"`
+}
+
+// HasTwoFactor reports whether the user must pass a second factor to log in
+func (u *User) HasTwoFactor() bool {
+	return u.TwoFactor != nil && u.TwoFactor.Enabled
+}
+
+// ExternalIdentity links the user to an account at a login provider such as Google
+type ExternalIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"` // The provider's user ID
+	Email    string    `bson:"email" json:"email"`
+	LinkedAt time.Time `bson:"linkedAt" json:"linkedAt"`
 }
 
 type SocialCredentials struct {
@@ -56,14 +101,50 @@ type TwitterCredentials struct {
 }
 
 func (u *User) HashPassword() error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(u.Password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
 func (u *User) ComparePassword(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-}
\ No newline at end of file
+}
+
+// UserSummary is the view of a user in admin listings. It carries no credentials of any
+// kind, so third-party secrets in Social can't leak through it.
+type UserSummary struct {
+	ID                  primitive.ObjectID `json:"id"`
+	Name                string             `json:"name"`
+	Email               string             `json:"email"`
+	Role                string             `json:"role"`
+	Approved            bool               `json:"approved"`
+	EmailVerified       bool               `json:"emailVerified"`
+	TwoFactorEnabled    bool               `json:"twoFactorEnabled"`
+	LoginProviders      []string           `json:"loginProviders,omitempty"`
+	CreatedAt           time.Time          `json:"createdAt"`
+	UpdatedAt           time.Time          `json:"updatedAt"`
+	DeletionScheduledAt *time.Time         `json:"deletionScheduledAt,omitempty"`
+}
+
+// Summary returns the user's listing view
+func (u *User) Summary() UserSummary {
+	summary := UserSummary{
+		ID:                  u.ID,
+		Name:                u.Name,
+		Email:               u.Email,
+		Role:                u.Role,
+		Approved:            u.Approved,
+		EmailVerified:       u.EmailVerified,
+		TwoFactorEnabled:    u.HasTwoFactor(),
+		CreatedAt:           u.CreatedAt,
+		UpdatedAt:           u.UpdatedAt,
+		DeletionScheduledAt: u.DeletionScheduledAt,
+	}
+	for _, identity := range u.Identities {
+		summary.LoginProviders = append(summary.LoginProviders, identity.Provider)
+	}
+	return summary
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUserSummaryHasNoSecrets(t *testing.T) {
+	user := User{
+		Name:      "Ada",
+		Email:     "ada@example.com",
+		Password:  "hash",
+		Role:      RoleAdmin,
+		TwoFactor: &TwoFactor{Enabled: true, Secret: "totp-secret"},
+		Social: &SocialCredentials{
+			Twitter: &TwitterCredentials{APISecret: "twitter-secret", AccessTokenSecret: "access-secret"},
+			Devto:   &DevtoCredentials{APIKey: "devto-key"},
+		},
+		Identities: []ExternalIdentity{{Provider: "github", Subject: "12345"}},
+	}
+
+	out, err := json.Marshal(user.Summary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hash", "totp-secret", "twitter-secret", "access-secret", "devto-key", "12345"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("summary contains %q: %s", secret, out)
+		}
+	}
+	if summary := user.Summary(); !summary.TwoFactorEnabled || len(summary.LoginProviders) != 1 || summary.LoginProviders[0] != "github" {
+		t.Errorf("summary = %+v", summary)
+	}
+}
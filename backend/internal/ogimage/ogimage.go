@@ -0,0 +1,162 @@
+package ogimage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Open Graph images are rendered at the size recommended by Facebook and LinkedIn
+const (
+	Width  = 1200
+	Height = 630
+
+	padding       = 80
+	titleSize     = 64
+	siteNameSize  = 32
+	titleMaxLines = 4
+	accentHeight  = 12
+)
+
+// Theme holds the colours used to render an image
+type Theme struct {
+	Background color.RGBA
+	Text       color.RGBA
+	Accent     color.RGBA
+	Muted      color.RGBA
+}
+
+// themes maps the theme names used in sites-config.json to image palettes
+var themes = map[string]Theme{
+	"light": {
+		Background: color.RGBA{0xff, 0xff, 0xff, 0xff},
+		Text:       color.RGBA{0x11, 0x18, 0x27, 0xff},
+		Accent:     color.RGBA{0x25, 0x63, 0xeb, 0xff},
+		Muted:      color.RGBA{0x6b, 0x72, 0x80, 0xff},
+	},
+	"dark": {
+		Background: color.RGBA{0x11, 0x18, 0x27, 0xff},
+		Text:       color.RGBA{0xf9, 0xfa, 0xfb, 0xff},
+		Accent:     color.RGBA{0x8b, 0x5c, 0xf6, 0xff},
+		Muted:      color.RGBA{0x9c, 0xa3, 0xaf, 0xff},
+	},
+	"dark-blue": {
+		Background: color.RGBA{0x0f, 0x17, 0x2a, 0xff},
+		Text:       color.RGBA{0xf8, 0xfa, 0xfc, 0xff},
+		Accent:     color.RGBA{0x38, 0xbd, 0xf8, 0xff},
+		Muted:      color.RGBA{0x94, 0xa3, 0xb8, 0xff},
+	},
+}
+
+// ThemeFor returns the palette for a tenant theme name, falling back to light
+func ThemeFor(name string) Theme {
+	if theme, ok := themes[name]; ok {
+		return theme
+	}
+	if strings.HasPrefix(name, "dark") {
+		return themes["dark"]
+	}
+	return themes["light"]
+}
+
+// Render draws a branded PNG with the post title and site name
+func Render(title, siteName string, theme Theme) ([]byte, error) {
+	titleFace, err := newFace(gobold.TTF, titleSize)
+	if err != nil {
+		return nil, err
+	}
+	defer titleFace.Close()
+
+	siteFace, err := newFace(goregular.TTF, siteNameSize)
+	if err != nil {
+		return nil, err
+	}
+	defer siteFace.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{theme.Background}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, Width, accentHeight), &image.Uniform{theme.Accent}, image.Point{}, draw.Src)
+
+	// Title, wrapped to the available width
+	lines := wrapText(titleFace, title, Width-2*padding, titleMaxLines)
+	lineHeight := titleFace.Metrics().Height.Ceil() + 8
+	y := padding + accentHeight + titleFace.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawText(img, titleFace, theme.Text, padding, y, line)
+		y += lineHeight
+	}
+
+	// Site name in the bottom-left corner, with an accent marker
+	baseline := Height - padding
+	marker := image.Rect(padding, baseline-siteNameSize+6, padding+8, baseline+6)
+	draw.Draw(img, marker, &image.Uniform{theme.Accent}, image.Point{}, draw.Src)
+	drawText(img, siteFace, theme.Muted, padding+24, baseline, siteName)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func newFace(ttf []byte, size float64) (font.Face, error) {
+	parsed, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse font: %v", err)
+	}
+	return opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+func drawText(img draw.Image, face font.Face, c color.Color, x, y int, text string) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// wrapText breaks text into lines no wider than maxWidth pixels. If the text needs
+// more than maxLines lines the last line is truncated with an ellipsis.
+func wrapText(face font.Face, text string, maxWidth, maxLines int) []string {
+	limit := fixed.I(maxWidth)
+	var lines []string
+	current := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if font.MeasureString(face, candidate) <= limit || current == "" {
+			current = candidate
+			continue
+		}
+		lines = append(lines, current)
+		current = word
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := lines[maxLines-1]
+		for last != "" && font.MeasureString(face, last+"…") > limit {
+			runes := []rune(last)
+			last = strings.TrimRight(string(runes[:len(runes)-1]), " ")
+		}
+		lines[maxLines-1] = last + "…"
+	}
+	return lines
+}
@@ -0,0 +1,59 @@
+package ogimage
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestRenderProducesOpenGraphSizedPNG(t *testing.T) {
+	data, err := Render("Building a multi-tenant blog engine in Go", "CodersInFlow", ThemeFor("dark-blue"))
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("output is not a valid PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+		t.Fatalf("image is %dx%d, want %dx%d", b.Dx(), b.Dy(), Width, Height)
+	}
+}
+
+func TestWrapTextTruncatesLongTitles(t *testing.T) {
+	face, err := newFace(gobold.TTF, titleSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+
+	title := strings.Repeat("extraordinarily verbose headline ", 20)
+	lines := wrapText(face, title, 600, 3)
+
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+	if !strings.HasSuffix(lines[2], "…") {
+		t.Errorf("last line %q should end with an ellipsis", lines[2])
+	}
+	for _, line := range lines {
+		if font.MeasureString(face, line) > fixed.I(600) {
+			t.Errorf("line %q is wider than the limit", line)
+		}
+	}
+}
+
+func TestThemeForFallsBack(t *testing.T) {
+	if ThemeFor("unknown") != themes["light"] {
+		t.Error("unknown themes should fall back to light")
+	}
+	if ThemeFor("dark-purple") != themes["dark"] {
+		t.Error("unknown dark themes should fall back to dark")
+	}
+}
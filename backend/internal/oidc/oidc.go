@@ -0,0 +1,419 @@
+// Package oidc implements sign-in with OpenID Connect providers (Google, Okta,
+// Keycloak, ...) and GitHub's OAuth2 flow, using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Provider types
+const (
+	TypeOIDC   = "oidc"
+	TypeGitHub = "github"
+)
+
+// discoveryTTL is how long a provider's discovery document is reused
+const discoveryTTL = time.Hour
+
+// Config describes one login provider of a site. Secrets are best kept out of
+// sites-config.json and set as TENANT_<ID>_OIDC_<PROVIDER>_SECRET instead.
+type Config struct {
+	ID           string   `json:"id"`             // Used in URLs, e.g. "google"
+	Type         string   `json:"type,omitempty"` // "oidc" (default) or "github"
+	Name         string   `json:"name,omitempty"` // Shown on the login button
+	Issuer       string   `json:"issuer,omitempty"`
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+
+	// Endpoint overrides, for GitHub Enterprise and for tests
+	AuthURL  string `json:"authUrl,omitempty"`
+	TokenURL string `json:"tokenUrl,omitempty"`
+	APIURL   string `json:"apiUrl,omitempty"`
+}
+
+// Resolve fills in defaults for well-known providers and reads credentials for the
+// tenant from the environment
+func (c Config) Resolve(tenantID string) Config {
+	switch c.ID {
+	case "google":
+		if c.Issuer == "" {
+			c.Issuer = "https://accounts.google.com"
+		}
+		if c.Name == "" {
+			c.Name = "Google"
+		}
+	case "github":
+		if c.Type == "" {
+			c.Type = TypeGitHub
+		}
+		if c.Name == "" {
+			c.Name = "GitHub"
+		}
+	}
+	if c.Type == "" {
+		c.Type = TypeOIDC
+	}
+	if c.Name == "" {
+		c.Name = c.ID
+	}
+
+	prefix := "TENANT_" + envName(tenantID) + "_OIDC_" + envName(c.ID) + "_"
+	if id := os.Getenv(prefix + "CLIENT_ID"); id != "" {
+		c.ClientID = id
+	}
+	if secret := os.Getenv(prefix + "SECRET"); secret != "" {
+		c.ClientSecret = secret
+	}
+
+	if len(c.Scopes) == 0 {
+		if c.Type == TypeGitHub {
+			c.Scopes = []string{"read:user", "user:email"}
+		} else {
+			c.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+	return c
+}
+
+func envName(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(s))
+}
+
+// Identity is the user a provider vouched for
+type Identity struct {
+	Provider      string
+	Subject       string // The provider's stable user ID
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+var (
+	ErrNotConfigured = errors.New("oidc: provider is missing a client ID or issuer")
+	ErrInvalidToken  = errors.New("oidc: ID token is invalid")
+)
+
+// Provider is a configured, discovered login provider
+type Provider struct {
+	Config
+
+	authURL      string
+	tokenURL     string
+	userinfoURL  string
+	issuer       string
+	basicAuth    bool // Send client credentials with HTTP basic auth instead of the form
+	keys         *keySet
+	client       *http.Client
+	discoveredAt time.Time
+}
+
+// HTTPClient is used for every request to providers
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]*Provider{}
+)
+
+// Get returns the provider for a site, discovering its endpoints the first time
+func Get(ctx context.Context, tenantID string, cfg Config) (*Provider, error) {
+	cfg = cfg.Resolve(tenantID)
+	cacheKey := tenantID + "/" + cfg.ID
+
+	providersMu.Lock()
+	cached, ok := providers[cacheKey]
+	providersMu.Unlock()
+	if ok && reflect.DeepEqual(cached.Config, cfg) && time.Since(cached.discoveredAt) < discoveryTTL {
+		return cached, nil
+	}
+
+	p, err := New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	providersMu.Lock()
+	providers[cacheKey] = p
+	providersMu.Unlock()
+	return p, nil
+}
+
+// New sets up a provider from a resolved config. OIDC providers are discovered from
+// <issuer>/.well-known/openid-configuration.
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.ClientID == "" {
+		return nil, ErrNotConfigured
+	}
+	p := &Provider{Config: cfg, client: HTTPClient, discoveredAt: time.Now()}
+
+	if cfg.Type == TypeGitHub {
+		p.authURL = firstNonEmpty(cfg.AuthURL, "https://github.com/login/oauth/authorize")
+		p.tokenURL = firstNonEmpty(cfg.TokenURL, "https://github.com/login/oauth/access_token")
+		return p, nil
+	}
+	if cfg.Type != TypeOIDC {
+		return nil, fmt.Errorf("oidc: unknown provider type %q", cfg.Type)
+	}
+	if cfg.Issuer == "" {
+		return nil, ErrNotConfigured
+	}
+
+	var doc struct {
+		Issuer                string   `json:"issuer"`
+		AuthorizationEndpoint string   `json:"authorization_endpoint"`
+		TokenEndpoint         string   `json:"token_endpoint"`
+		UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+		JWKSURI               string   `json:"jwks_uri"`
+		TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+	}
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, "", &doc); err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+	// The issuer in the document must be the one we trust, or tokens from another
+	// issuer could be accepted
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", doc.Issuer, cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	p.issuer = doc.Issuer
+	p.authURL = firstNonEmpty(cfg.AuthURL, doc.AuthorizationEndpoint)
+	p.tokenURL = firstNonEmpty(cfg.TokenURL, doc.TokenEndpoint)
+	p.userinfoURL = doc.UserinfoEndpoint
+	p.keys = &keySet{uri: doc.JWKSURI, client: p.client}
+	// client_secret_basic is the default when a provider doesn't list its methods
+	p.basicAuth = len(doc.TokenAuthMethods) == 0 || contains(doc.TokenAuthMethods, "client_secret_basic")
+	return p, nil
+}
+
+// NewVerifier returns a random PKCE code verifier, state or nonce
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the provider URL to send the browser to
+func (p *Provider) AuthCodeURL(redirectURI, state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if p.Type == TypeOIDC {
+		q.Set("nonce", nonce)
+	}
+	separator := "?"
+	if strings.Contains(p.authURL, "?") {
+		separator = "&"
+	}
+	return p.authURL + separator + q.Encode()
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the authorization code for the signed-in user's identity. For OIDC
+// providers the ID token's signature, issuer, audience, expiry and nonce are checked.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, verifier, nonce string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+		"client_id":     {p.ClientID},
+	}
+	if !p.basicAuth {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.basicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("oidc: token response: %w", err)
+	}
+	if token.Error != "" || resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token request rejected: %s %s", token.Error, token.ErrorDescription)
+	}
+
+	if p.Type == TypeGitHub {
+		return p.githubIdentity(ctx, token.AccessToken)
+	}
+	return p.oidcIdentity(ctx, token, nonce)
+}
+
+func (p *Provider) oidcIdentity(ctx context.Context, token tokenResponse, nonce string) (*Identity, error) {
+	if token.IDToken == "" {
+		return nil, ErrInvalidToken
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token.IDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.get(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	identity := identityFromClaims(p.ID, claims)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+
+	// Some providers only put the email in the userinfo response
+	if identity.Email == "" && p.userinfoURL != "" && token.AccessToken != "" {
+		info := map[string]interface{}{}
+		if err := p.getJSON(ctx, p.userinfoURL, token.AccessToken, &info); err == nil {
+			if sub, _ := info["sub"].(string); sub == identity.Subject {
+				extra := identityFromClaims(p.ID, info)
+				identity.Email, identity.EmailVerified = extra.Email, extra.EmailVerified
+				if identity.Name == "" {
+					identity.Name = extra.Name
+				}
+			}
+		}
+	}
+	return identity, nil
+}
+
+func identityFromClaims(provider string, claims map[string]interface{}) *Identity {
+	identity := &Identity{Provider: provider}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	return identity
+}
+
+func (p *Provider) githubIdentity(ctx context.Context, accessToken string) (*Identity, error) {
+	if accessToken == "" {
+		return nil, ErrInvalidToken
+	}
+	api := strings.TrimSuffix(firstNonEmpty(p.APIURL, "https://api.github.com"), "/")
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.getJSON(ctx, api+"/user", accessToken, &user); err != nil {
+		return nil, fmt.Errorf("oidc: github user: %w", err)
+	}
+	if user.ID == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	// The profile email may be unverified, so use the primary verified address
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, api+"/user/emails", accessToken, &emails); err != nil {
+		return nil, fmt.Errorf("oidc: github emails: %w", err)
+	}
+
+	identity := &Identity{Provider: p.ID, Subject: fmt.Sprint(user.ID), Name: firstNonEmpty(user.Name, user.Login)}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email, identity.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return identity, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
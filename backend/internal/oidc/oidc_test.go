@@ -0,0 +1,249 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS, a token endpoint that
+// enforces PKCE and client authentication, and userinfo
+type mockIssuer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string // code_challenge from the authorization request
+
+	// Knobs for tests
+	audience       string
+	nonce          string
+	issuer         string
+	expiresIn      time.Duration
+	emailInIDToken bool
+	signingKey     *rsa.PrivateKey
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, audience: "client-1", expiresIn: time.Minute, emailInIDToken: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"userinfo_endpoint":      m.URL + "/userinfo",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		switch {
+		case id != "client-1" || secret != "s3cret":
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		case r.Form.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   firstNonEmpty(m.issuer, m.URL),
+			"aud":   m.audience,
+			"sub":   "user-42",
+			"name":  "Ada Lovelace",
+			"nonce": m.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(m.expiresIn).Unix(),
+		}
+		if m.emailInIDToken {
+			claims["email"] = "ada@example.com"
+			claims["email_verified"] = true
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		signingKey := m.key
+		if m.signingKey != nil {
+			signingKey = m.signingKey
+		}
+		idToken, _ := token.SignedString(signingKey)
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at-1", "id_token": idToken, "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"sub": "user-42", "email": "ada@example.com", "email_verified": "true"})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// login runs the authorization code flow against the mock issuer
+func (m *mockIssuer) login(t *testing.T, p *Provider, code string) (*Identity, error) {
+	t.Helper()
+	state, _ := NewVerifier()
+	nonce, _ := NewVerifier()
+	verifier, _ := NewVerifier()
+	const redirectURI = "https://codersinflow.com/auth/callback/okta"
+
+	// What the browser would be sent to
+	authURL, err := url.Parse(p.AuthCodeURL(redirectURI, state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authURL.Query()
+	if q.Get("state") != state || q.Get("nonce") != nonce || q.Get("code_challenge_method") != "S256" ||
+		q.Get("redirect_uri") != redirectURI || !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	m.challenge = q.Get("code_challenge")
+	if m.nonce == "" {
+		m.nonce = nonce
+	}
+	return p.Exchange(context.Background(), redirectURI, code, verifier, nonce)
+}
+
+func (m *mockIssuer) provider(t *testing.T) *Provider {
+	t.Helper()
+	p, err := New(context.Background(), Config{ID: "okta", ClientID: "client-1", ClientSecret: "s3cret", Issuer: m.URL}.Resolve("codersinflow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestOIDCLogin(t *testing.T) {
+	m := newMockIssuer(t)
+	identity, err := m.login(t, m.provider(t), "good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "okta", Subject: "user-42", Email: "ada@example.com", EmailVerified: true, Name: "Ada Lovelace"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOIDCEmailFromUserinfo(t *testing.T) {
+	m := newMockIssuer(t)
+	m.emailInIDToken = false
+	identity, err := m.login(t, m.provider(t), "good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Email != "ada@example.com" || !identity.EmailVerified {
+		t.Errorf("identity = %+v", identity)
+	}
+}
+
+func TestOIDCRejectsBadTokens(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cases := map[string]func(m *mockIssuer){
+		"wrong nonce":    func(m *mockIssuer) { m.nonce = "replayed" },
+		"wrong audience": func(m *mockIssuer) { m.audience = "someone-else" },
+		"wrong issuer":   func(m *mockIssuer) { m.issuer = "https://evil.example.com" },
+		"expired":        func(m *mockIssuer) { m.expiresIn = -time.Hour },
+		"forged":         func(m *mockIssuer) { m.signingKey = otherKey },
+	}
+	for name, tweak := range cases {
+		t.Run(name, func(t *testing.T) {
+			m := newMockIssuer(t)
+			tweak(m)
+			if _, err := m.login(t, m.provider(t), "good-code"); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestOIDCTokenEndpointErrors(t *testing.T) {
+	m := newMockIssuer(t)
+	if _, err := m.login(t, m.provider(t), "stolen-code"); err == nil {
+		t.Error("expected an unknown code to be rejected")
+	}
+
+	p := m.provider(t)
+	p.ClientSecret = "wrong"
+	if _, err := m.login(t, p, "good-code"); err == nil {
+		t.Error("expected a wrong client secret to be rejected")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	_, err := New(context.Background(), Config{ID: "okta", ClientID: "client-1", Issuer: m.URL + "/other"}.Resolve("x"))
+	if err == nil {
+		t.Error("expected discovery to fail for a different issuer")
+	}
+}
+
+func TestGitHubLogin(t *testing.T) {
+	api := http.NewServeMux()
+	api.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_secret") != "gh-secret" || r.Form.Get("code") != "gh-code" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_1"})
+	})
+	api.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1234, "login": "octocat"})
+	})
+	api.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "octo@example.com", "primary": true, "verified": true},
+		})
+	})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	t.Setenv("TENANT_DARKFLOWS_OIDC_GITHUB_SECRET", "gh-secret")
+	cfg := Config{ID: "github", ClientID: "gh-client", TokenURL: server.URL + "/login/oauth/access_token", APIURL: server.URL}.Resolve("darkflows")
+	if cfg.Type != TypeGitHub || cfg.ClientSecret != "gh-secret" || cfg.Name != "GitHub" {
+		t.Fatalf("resolved config = %+v", cfg)
+	}
+	p, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := p.Exchange(context.Background(), "https://darkflows.com/auth/callback/github", "gh-code", "v", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "github", Subject: "1234", Email: "octo@example.com", EmailVerified: true, Name: "octocat"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
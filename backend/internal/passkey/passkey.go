@@ -0,0 +1,143 @@
+// Package passkey adapts the go-webauthn library to the site's users and tenants
+package passkey
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/middleware"
+	"github.com/coders-website/backend/internal/models"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// CeremonyTimeout is how long the browser and server allow for a registration or login
+const CeremonyTimeout = 5 * time.Minute
+
+// RPID returns the relying party ID for a tenant domain. The www prefix is dropped
+// so passkeys work on both forms of the domain.
+func RPID(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if host, _, found := strings.Cut(domain, ":"); found {
+		domain = host
+	}
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// New returns a WebAuthn relying party for a tenant. requestOrigin is the Origin
+// header of the current request; it is accepted when it belongs to the tenant domain,
+// which covers local development on other ports. Plain http origins are only accepted
+// outside production or on localhost, as anyone on the network can tamper with those pages.
+func New(domain, siteName, requestOrigin string) (*webauthn.WebAuthn, error) {
+	rpID := RPID(domain)
+	if siteName == "" {
+		siteName = rpID
+	}
+
+	origins := []string{"https://" + rpID, "https://www." + rpID}
+	if u, err := url.Parse(requestOrigin); err == nil && u.Host != "" {
+		host := strings.ToLower(u.Hostname())
+		allowedScheme := u.Scheme == "https" || !middleware.IsProduction() || middleware.IsLocalHost(host)
+		if allowedScheme && (host == rpID || strings.HasSuffix(host, "."+rpID)) {
+			origins = append(origins, u.Scheme+"://"+u.Host)
+		}
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: CeremonyTimeout, TimeoutUVD: CeremonyTimeout}
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: siteName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// User wraps a site user and their stored passkeys for the WebAuthn library
+type User struct {
+	User     *models.User
+	Passkeys []models.Passkey
+}
+
+// WebAuthnID is the user handle stored on the authenticator: the user's ObjectID
+func (u User) WebAuthnID() []byte {
+	return u.User.ID[:]
+}
+
+func (u User) WebAuthnName() string {
+	return u.User.Email
+}
+
+func (u User) WebAuthnDisplayName() string {
+	if u.User.Name != "" {
+		return u.User.Name
+	}
+	return u.User.Email
+}
+
+func (u User) WebAuthnIcon() string {
+	return ""
+}
+
+func (u User) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.Passkeys))
+	for i, p := range u.Passkeys {
+		credentials[i] = ToCredential(p)
+	}
+	return credentials
+}
+
+// Exclusions lists the user's existing credentials so an authenticator isn't registered twice
+func (u User) Exclusions() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, len(u.Passkeys))
+	for i, p := range u.Passkeys {
+		descriptors[i] = ToCredential(p).Descriptor()
+	}
+	return descriptors
+}
+
+// ToCredential converts a stored passkey into the library's credential type
+func ToCredential(p models.Passkey) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, len(p.Transports))
+	for i, t := range p.Transports {
+		transports[i] = protocol.AuthenticatorTransport(t)
+	}
+	return webauthn.Credential{
+		ID:              p.CredentialID,
+		PublicKey:       p.PublicKey,
+		AttestationType: p.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserVerified:   p.UserVerified,
+			BackupEligible: p.BackupEligible,
+			BackupState:    p.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    p.AAGUID,
+			SignCount: p.SignCount,
+		},
+	}
+}
+
+// FromCredential builds a passkey record from a newly registered credential
+func FromCredential(c *webauthn.Credential) models.Passkey {
+	transports := make([]string, len(c.Transport))
+	for i, t := range c.Transport {
+		transports[i] = string(t)
+	}
+	return models.Passkey{
+		CredentialID:    c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transports:      transports,
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		UserVerified:    c.Flags.UserVerified,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+	}
+}
@@ -0,0 +1,251 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/coders-website/backend/internal/models"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// softAuthenticator is a minimal platform authenticator: one P-256 key, "none"
+// attestation, user presence and verification always asserted
+type softAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{key: key, credID: credID}
+}
+
+const (
+	flagUP = 0x01
+	flagUV = 0x04
+	flagAT = 0x40
+)
+
+func (a *softAuthenticator) authData(rpID string, flags byte, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	a.signCount++
+	data := append([]byte{}, rpHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony, challenge, origin string) []byte {
+	data, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// register answers navigator.credentials.create() and returns the JSON the browser would post
+func (a *softAuthenticator) register(t *testing.T, creation *protocol.CredentialCreation, origin string) []byte {
+	opts := creation.Response
+	switch id := opts.User.ID.(type) {
+	case protocol.URLEncodedBase64:
+		a.userHandle = id
+	case []byte:
+		a.userHandle = id
+	default:
+		t.Fatalf("unexpected user ID type %T", id)
+	}
+
+	coseKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // Zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credID)))
+	attested = append(attested, a.credID...)
+	attested = append(attested, coseKey...)
+
+	attestation, err := webauthncbor.Marshal(struct {
+		Fmt      string                 `cbor:"fmt"`
+		AttStmt  map[string]interface{} `cbor:"attStmt"`
+		AuthData []byte                 `cbor:"authData"`
+	}{"none", map[string]interface{}{}, a.authData(opts.RelyingParty.ID, flagUP|flagUV|flagAT, attested)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"attestationObject": b64(attestation),
+			"clientDataJSON":    b64(clientData(t, "webauthn.create", opts.Challenge.String(), origin)),
+		},
+	})
+	return body
+}
+
+// login answers navigator.credentials.get()
+func (a *softAuthenticator) login(t *testing.T, assertion *protocol.CredentialAssertion, origin string) []byte {
+	opts := assertion.Response
+	authData := a.authData(opts.RelyingPartyID, flagUP|flagUV, nil)
+	client := clientData(t, "webauthn.get", opts.Challenge.String(), origin)
+
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"authenticatorData": b64(authData),
+			"clientDataJSON":    b64(client),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return body
+}
+
+func registerPasskey(t *testing.T, rp *webauthn.WebAuthn, user *User, authenticator *softAuthenticator, origin string) {
+	t.Helper()
+	creation, session, err := rp.BeginRegistration(user, webauthn.WithExclusions(user.Exclusions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(authenticator.register(t, creation, origin)))
+	if err != nil {
+		t.Fatalf("parse registration: %v", err)
+	}
+	credential, err := rp.CreateCredential(user, *session, parsed)
+	if err != nil {
+		t.Fatalf("registration rejected: %v", err)
+	}
+	user.Passkeys = append(user.Passkeys, FromCredential(credential))
+}
+
+func TestRegisterAndLoginWithDiscoverablePasskey(t *testing.T) {
+	rp, err := New("www.example.com", "Example", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Config.RPID != "example.com" {
+		t.Fatalf("RP ID = %q, want example.com", rp.Config.RPID)
+	}
+
+	user := &User{User: &models.User{ID: primitive.NewObjectID(), Email: "admin@example.com", Name: "Admin"}}
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, rp, user, authenticator, "https://www.example.com")
+
+	assertion, session, err := rp.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(authenticator.login(t, assertion, "https://example.com")))
+	if err != nil {
+		t.Fatalf("parse assertion: %v", err)
+	}
+
+	var lookedUp []byte
+	credential, err := rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		lookedUp = userHandle
+		return user, nil
+	}, *session, parsed)
+	if err != nil {
+		t.Fatalf("login rejected: %v", err)
+	}
+	if !bytes.Equal(lookedUp, user.User.ID[:]) {
+		t.Errorf("user handle = %x, want the user's ObjectID", lookedUp)
+	}
+	if credential.Authenticator.SignCount != 2 {
+		t.Errorf("sign count = %d, want 2", credential.Authenticator.SignCount)
+	}
+}
+
+func TestPasskeyIsBoundToItsTenant(t *testing.T) {
+	home, _ := New("codersinflow.com", "", "")
+	user := &User{User: &models.User{ID: primitive.NewObjectID(), Email: "a@b.c"}}
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, home, user, authenticator, "https://codersinflow.com")
+
+	// The same credential presented to another tenant fails the RP ID hash check
+	other, _ := New("darkflows.com", "", "")
+	assertion, session, err := other.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion.Response.RelyingPartyID = "codersinflow.com" // What the authenticator scoped the key to
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(authenticator.login(t, assertion, "https://darkflows.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.ValidateLogin(user, *session, parsed); err == nil {
+		t.Error("passkey for codersinflow.com accepted by darkflows.com")
+	}
+}
+
+func TestOriginsAcceptOnlyTenantHosts(t *testing.T) {
+	rp, _ := New("localhost", "", "http://localhost:4321")
+	if !contains(rp.Config.RPOrigins, "http://localhost:4321") {
+		t.Errorf("dev origin missing from %v", rp.Config.RPOrigins)
+	}
+	rp, _ = New("example.com", "", "https://evil.com")
+	if contains(rp.Config.RPOrigins, "https://evil.com") {
+		t.Errorf("foreign origin accepted: %v", rp.Config.RPOrigins)
+	}
+
+	t.Setenv("NODE_ENV", "production")
+	rp, _ = New("example.com", "", "http://example.com")
+	if contains(rp.Config.RPOrigins, "http://example.com") {
+		t.Errorf("http origin accepted in production: %v", rp.Config.RPOrigins)
+	}
+	rp, _ = New("localhost", "", "http://localhost:4321")
+	if !contains(rp.Config.RPOrigins, "http://localhost:4321") {
+		t.Errorf("localhost origin refused in production: %v", rp.Config.RPOrigins)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
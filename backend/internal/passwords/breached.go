@@ -0,0 +1,113 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// breachedList is bundled so the check works offline and never sends anything about a
+// password anywhere
+//
+//go:embed breached.txt
+var breachedList string
+
+// prefixLength is how much of a hash picks its range, as in the Pwned Passwords
+// k-anonymity API
+const prefixLength = 5
+
+// HashList holds SHA-1 password hashes grouped into ranges by prefix, so a lookup
+// only ever needs the first few characters of a hash to fetch candidates
+type HashList struct {
+	ranges map[string][]string // Prefix -> sorted suffixes
+}
+
+// ParseHashList reads one uppercase or lowercase SHA-1 hash per line, optionally
+// followed by ":count". Blank lines and lines starting with # are skipped.
+func ParseHashList(r io.Reader) (*HashList, error) {
+	list := &HashList{ranges: make(map[string][]string)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+		list.ranges[hash[:prefixLength]] = append(list.ranges[hash[:prefixLength]], hash[prefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, suffixes := range list.ranges {
+		sort.Strings(suffixes)
+	}
+	return list, nil
+}
+
+// Range returns the suffixes of every hash starting with the prefix
+func (l *HashList) Range(prefix string) []string {
+	return l.ranges[strings.ToUpper(prefix)]
+}
+
+// Contains reports whether the password's hash is on the list
+func (l *HashList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes := l.Range(hash[:prefixLength])
+	i := sort.SearchStrings(suffixes, hash[prefixLength:])
+	return i < len(suffixes) && suffixes[i] == hash[prefixLength:]
+}
+
+var (
+	breachedOnce sync.Once
+	breached     []*HashList
+)
+
+// Breached reports whether the password is on the bundled list of breached passwords,
+// or on the list in BREACHED_PASSWORDS_FILE if one is set
+func Breached(password string) bool {
+	breachedOnce.Do(loadBreached)
+	for _, list := range breached {
+		if list.Contains(password) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadBreached() {
+	bundled, err := ParseHashList(strings.NewReader(breachedList))
+	if err != nil {
+		log.Printf("Failed to load the bundled breached password list: %v", err)
+	} else {
+		breached = append(breached, bundled)
+	}
+
+	path := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open breached password list %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+	extra, err := ParseHashList(file)
+	if err != nil {
+		log.Printf("Failed to load breached password list %s: %v", path, err)
+		return
+	}
+	breached = append(breached, extra)
+}
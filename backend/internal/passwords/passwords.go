@@ -0,0 +1,152 @@
+// Package passwords checks new passwords against a site's password policy and hashes
+// them with bcrypt
+package passwords
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultMinLength applies when a site's policy doesn't set one
+const defaultMinLength = 8
+
+// maxBytes is as much of a password as bcrypt looks at; anything longer would be
+// silently cut short
+const maxBytes = 72
+
+// minBannedWordLength keeps short fragments, like "com" from a domain, from banning
+// half the dictionary
+const minBannedWordLength = 4
+
+// Policy is a site's password policy, set as "passwordPolicy" in the sites
+// configuration
+type Policy struct {
+	MinLength     int      `json:"minLength,omitempty"` // Default 8
+	RequireUpper  bool     `json:"requireUpper,omitempty"`
+	RequireLower  bool     `json:"requireLower,omitempty"`
+	RequireDigit  bool     `json:"requireDigit,omitempty"`
+	RequireSymbol bool     `json:"requireSymbol,omitempty"`
+	BannedWords   []string `json:"bannedWords,omitempty"`   // On top of the site's and the user's own names
+	AllowBreached bool     `json:"allowBreached,omitempty"` // Skip the breached password check
+}
+
+// ErrBreached is returned for passwords on the breached password list
+var ErrBreached = errors.New("This password has appeared in a data breach - please choose another")
+
+// MinimumLength is the shortest password the policy accepts
+func (p Policy) MinimumLength() int {
+	if p.MinLength > 0 {
+		return p.MinLength
+	}
+	return defaultMinLength
+}
+
+// Check returns an error, with a message fit to show the user, if the password breaks
+// the policy. Passwords can't contain the policy's banned words or any of the words
+// in context, which callers use for the site's and the user's names.
+func (p Policy) Check(password string, context ...string) error {
+	if n := p.MinimumLength(); utf8.RuneCountInString(password) < n {
+		return fmt.Errorf("Password must be at least %d characters", n)
+	}
+	if len(password) > maxBytes {
+		return fmt.Errorf("Password must be at most %d bytes", maxBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return errors.New("Password must contain an uppercase letter")
+	case p.RequireLower && !lower:
+		return errors.New("Password must contain a lowercase letter")
+	case p.RequireDigit && !digit:
+		return errors.New("Password must contain a digit")
+	case p.RequireSymbol && !symbol:
+		return errors.New("Password must contain a symbol")
+	}
+
+	folded := strings.ToLower(password)
+	for _, word := range bannedWords(p.BannedWords, context) {
+		if strings.Contains(folded, word) {
+			return fmt.Errorf("Password must not contain %q", word)
+		}
+	}
+
+	if !p.AllowBreached && Breached(password) {
+		return ErrBreached
+	}
+	return nil
+}
+
+// bannedWords lowercases the phrases and splits them into words, keeping each whole
+// phrase run together too, so "Preston Garrison" bans "preston", "garrison" and
+// "prestongarrison"
+func bannedWords(lists ...[]string) []string {
+	var words []string
+	for _, list := range lists {
+		for _, phrase := range list {
+			parts := strings.FieldsFunc(strings.ToLower(phrase), func(c rune) bool {
+				return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+			})
+			if len(parts) > 1 {
+				parts = append(parts, strings.Join(parts, ""))
+			}
+			for _, part := range parts {
+				if utf8.RuneCountInString(part) >= minBannedWordLength {
+					words = append(words, part)
+				}
+			}
+		}
+	}
+	return words
+}
+
+// DefaultCost is the bcrypt cost of new hashes unless BCRYPT_COST says otherwise
+const DefaultCost = 12
+
+// Cost is the bcrypt cost new hashes use. Hashes made at a lower cost are upgraded the
+// next time their user signs in.
+func Cost() int {
+	if cost, err := strconv.Atoi(os.Getenv("BCRYPT_COST")); err == nil && cost >= bcrypt.MinCost && cost <= bcrypt.MaxCost {
+		return cost
+	}
+	return DefaultCost
+}
+
+// Hash hashes the password at the current cost
+func Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), Cost())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Compare checks a password against its hash
+func Compare(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+// NeedsRehash reports whether the hash was made at a lower cost than new hashes use
+func NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < Cost()
+}
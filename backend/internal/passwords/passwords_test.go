@@ -0,0 +1,88 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCheck(t *testing.T) {
+	strict := Policy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		context  []string
+		ok       bool
+	}{
+		{"default accepts a long unusual password", Policy{}, "correct horse battery", nil, true},
+		{"default minimum length", Policy{}, "Xk3#pq7", nil, false},
+		{"length counts characters, not bytes", Policy{}, "ééééééée", nil, true},
+		{"too long for bcrypt", Policy{}, strings.Repeat("x", 73), nil, false},
+		{"strict accepts every class", strict, "Tr1cky-Pangolin", nil, true},
+		{"strict needs a symbol", strict, "Tr1ckyPangolin", nil, false},
+		{"strict needs a digit", strict, "Tricky-Pangolin", nil, false},
+		{"banned word", Policy{BannedWords: []string{"blog"}}, "my-blog-is-great", nil, false},
+		{"site name in any case", Policy{}, "PrestonRocks2024", []string{"Preston Garrison"}, false},
+		{"site name run together", Policy{}, "xprestongarrisonx", []string{"Preston Garrison"}, false},
+		{"short fragments are ignored", Policy{}, "comet-tails-at-dusk", []string{"example.com"}, true},
+		{"email local part", Policy{}, "jane.doe.rules", []string{"jane.doe@example.com"}, false},
+		{"breached", Policy{}, "password123", nil, false},
+		{"breached allowed", Policy{AllowBreached: true}, "password123", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.password, tt.context...)
+			if (err == nil) != tt.ok {
+				t.Errorf("Check(%q) = %v, want ok=%v", tt.password, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHashList(t *testing.T) {
+	// SHA-1 of "hunter2" is F3BBBD66A63D4BF1747940578EC3D0103530E21D
+	list, err := ParseHashList(strings.NewReader("# comment\nf3bbbd66a63d4bf1747940578ec3d0103530e21d:17\n\nnot-a-hash\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Contains("hunter2") {
+		t.Error("list should contain hunter2")
+	}
+	if list.Contains("hunter3") {
+		t.Error("list should not contain hunter3")
+	}
+	if got := list.Range("f3bbb"); len(got) != 1 || got[0] != "D66A63D4BF1747940578EC3D0103530E21D" {
+		t.Errorf("Range = %v", got)
+	}
+}
+
+func TestBundledList(t *testing.T) {
+	for _, password := range []string{"123456", "qwerty", "iloveyou", "P@ssw0rd"} {
+		if !Breached(password) {
+			t.Errorf("%q should be on the bundled list", password)
+		}
+	}
+	if Breached("a-long-passphrase-nobody-uses") {
+		t.Error("unexpected match")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "5")
+	old, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if !NeedsRehash(string(old)) {
+		t.Error("a cost 4 hash should need rehashing at cost 5")
+	}
+	current, err := Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(current) {
+		t.Error("a fresh hash should not need rehashing")
+	}
+	if NeedsRehash("") {
+		t.Error("an empty hash is not a bcrypt hash")
+	}
+}
@@ -0,0 +1,288 @@
+// Package privacy exports everything a site stores about a user and erases accounts,
+// for data protection requests
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coders-website/backend/internal/identity"
+	"github.com/coders-website/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// What happens to an erased user's posts
+const (
+	PostsAnonymize = "anonymize" // Posts stay, credited to an anonymous account
+	PostsReassign  = "reassign"  // Posts move to Policy.ReassignTo
+)
+
+// defaultGraceDays is how long a deletion request can be cancelled
+const defaultGraceDays = 14
+
+// Policy is a site's account deletion policy, set as "accountDeletion" in the sites
+// configuration
+type Policy struct {
+	GraceDays  *int   `json:"graceDays,omitempty"`  // Default 14; 0 erases straight away
+	Posts      string `json:"posts,omitempty"`      // anonymize (default) or reassign
+	ReassignTo string `json:"reassignTo,omitempty"` // Email of the user who receives reassigned posts
+}
+
+// GracePeriod is how long after a request the account is erased
+func (p Policy) GracePeriod() time.Duration {
+	days := defaultGraceDays
+	if p.GraceDays != nil && *p.GraceDays >= 0 {
+		days = *p.GraceDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ErrNotFound is returned for users that don't exist or were already erased
+var ErrNotFound = errors.New("user not found")
+
+// Export writes a zip of the user's profile, posts, uploads, sessions, sign-in events
+// and social publishing history. Credentials, including third-party secrets, are left
+// out.
+func Export(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, w io.Writer) error {
+	var user models.User
+	if err := db.Collection("users").FindOne(ctx, bson.M{"_id": userID, "deletedAt": bson.M{"$exists": false}}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+	social := PublicSocial(user.Social)
+	user.Social = nil
+
+	files := []struct {
+		name    string
+		content func() (interface{}, error)
+	}{
+		{"profile.json", func() (interface{}, error) {
+			return map[string]interface{}{"user": &user, "socialAccounts": social}, nil
+		}},
+		{"posts.json", func() (interface{}, error) {
+			return findAll[models.Post](ctx, db.Collection("posts"), bson.M{"author": userID})
+		}},
+		{"uploads.json", func() (interface{}, error) {
+			return findAll[models.Upload](ctx, db.Collection("uploads"), bson.M{"userId": userID})
+		}},
+		{"sessions.json", func() (interface{}, error) {
+			return findAll[models.Session](ctx, db.Collection("sessions"), bson.M{"userId": userID})
+		}},
+		{"security_events.json", func() (interface{}, error) {
+			return findAll[models.SecurityEvent](ctx, db.Collection("security_events"), bson.M{"userId": userID})
+		}},
+		{"social_publish_history.json", func() (interface{}, error) {
+			return findAll[models.AuditEntry](ctx, db.Collection("audit_log"), bson.M{"actorId": userID, "action": models.AuditSocialPublish})
+		}},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		content, err := file.content()
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.name, err)
+		}
+		out, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(content); err != nil {
+			return fmt.Errorf("%s: %v", file.name, err)
+		}
+	}
+	return archive.Close()
+}
+
+func findAll[T any](ctx context.Context, collection *mongo.Collection, filter bson.M) ([]T, error) {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	results := []T{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// PublicSocial lists the user's connected social accounts without their secrets
+func PublicSocial(social *models.SocialCredentials) map[string]interface{} {
+	accounts := map[string]interface{}{}
+	if social == nil {
+		return accounts
+	}
+	if social.Reddit != nil {
+		accounts["reddit"] = map[string]string{"username": social.Reddit.Username, "subreddits": social.Reddit.Subreddits}
+	}
+	if social.Devto != nil {
+		accounts["devto"] = map[string]string{}
+	}
+	if social.LinkedIn != nil {
+		accounts["linkedin"] = map[string]string{}
+	}
+	if social.Facebook != nil {
+		accounts["facebook"] = map[string]string{"page_id": social.Facebook.PageID}
+	}
+	if social.Twitter != nil {
+		accounts["twitter"] = map[string]string{}
+	}
+	return accounts
+}
+
+// Erase deletes the user's account and credentials. Under the anonymize policy the
+// user document is kept, stripped of personal data, so posts still have an author;
+// under reassign the posts and uploads move to another user first. The audit log is
+// kept as the site's record of who changed what.
+func Erase(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, policy Policy) error {
+	users := db.Collection("users")
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"_id": userID, "deletedAt": bson.M{"$exists": false}}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	// Avatars picture the user, so they go rather than move with the other uploads
+	if err := deleteAvatars(ctx, db.Collection("uploads"), userID); err != nil {
+		return fmt.Errorf("delete avatars: %v", err)
+	}
+
+	anonymize := true
+	if policy.Posts == PostsReassign {
+		owner, err := reassignTarget(ctx, users, policy, userID)
+		if err != nil {
+			// The account is erased either way; the posts just stay with it anonymously
+			log.Printf("[%s] can't reassign posts of %s (%v), anonymizing instead", db.Name(), userID.Hex(), err)
+		} else {
+			for collection, field := range map[string]string{"posts": "author", "uploads": "userId"} {
+				if _, err := db.Collection(collection).UpdateMany(ctx, bson.M{field: userID}, bson.M{"$set": bson.M{field: owner}}); err != nil {
+					return fmt.Errorf("reassign %s: %v", collection, err)
+				}
+			}
+			anonymize = false
+		}
+	}
+
+	// A shared identity stays for the other sites it belongs to, and goes with its last
+	if user.IdentityID != nil {
+		if err := identity.Leave(ctx, *user.IdentityID, db.Name()); err != nil {
+			return fmt.Errorf("leave identity: %v", err)
+		}
+	}
+
+	for collection, filter := range map[string]bson.M{
+		"sessions":        {"userId": userID},
+		"api_tokens":      {"userId": userID},
+		"passkeys":        {"userId": userID},
+		"auth_tokens":     {"userId": userID},
+		"invitations":     {"email": user.Email},
+		"security_events": {"$or": bson.A{bson.M{"userId": userID}, bson.M{"email": strings.ToLower(user.Email)}}},
+		"login_attempts":  {"_id": "account:" + strings.ToLower(strings.TrimSpace(user.Email))},
+	} {
+		if _, err := db.Collection(collection).DeleteMany(ctx, filter); err != nil {
+			return fmt.Errorf("delete %s: %v", collection, err)
+		}
+	}
+
+	if !anonymize {
+		_, err := users.DeleteOne(ctx, bson.M{"_id": userID})
+		return err
+	}
+	now := time.Now()
+	_, err := users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{
+			"name":          "Deleted user",
+			"email":         "deleted-" + userID.Hex() + "@deleted.invalid",
+			"password":      "",
+			"role":          models.RoleViewer,
+			"approved":      false,
+			"emailVerified": false,
+			"deletedAt":     now,
+			"updatedAt":     now,
+		},
+		"$unset": bson.M{
+			"emailVerifiedAt":     "",
+			"social":              "",
+			"twoFactor":           "",
+			"identities":          "",
+			"profile":             "",
+			"identityId":          "",
+			"deletionScheduledAt": "",
+		},
+	})
+	return err
+}
+
+// deleteAvatars removes the user's avatar files and their upload records
+func deleteAvatars(ctx context.Context, uploads *mongo.Collection, userID primitive.ObjectID) error {
+	filter := bson.M{"userId": userID, "kind": models.UploadKindAvatar}
+	avatars, err := findAll[models.Upload](ctx, uploads, filter)
+	if err != nil {
+		return err
+	}
+	for _, upload := range avatars {
+		if err := os.Remove(upload.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove avatar %s: %v", upload.Path, err)
+		}
+	}
+	_, err = uploads.DeleteMany(ctx, filter)
+	return err
+}
+
+// reassignTarget finds the user who receives an erased user's posts
+func reassignTarget(ctx context.Context, users *mongo.Collection, policy Policy, erased primitive.ObjectID) (primitive.ObjectID, error) {
+	if policy.ReassignTo == "" {
+		return primitive.NilObjectID, errors.New("no reassignTo user configured")
+	}
+	var owner models.User
+	err := users.FindOne(ctx, bson.M{"email": policy.ReassignTo, "deletedAt": bson.M{"$exists": false}}).Decode(&owner)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("reassignTo user %s: %v", policy.ReassignTo, err)
+	}
+	if owner.ID == erased {
+		return primitive.NilObjectID, errors.New("reassignTo user is the one being erased")
+	}
+	return owner.ID, nil
+}
+
+// PurgeDue erases every account whose deletion grace period has ended and returns the
+// IDs of those erased
+func PurgeDue(ctx context.Context, db *mongo.Database, policy Policy, now time.Time) ([]primitive.ObjectID, error) {
+	cursor, err := db.Collection("users").Find(ctx,
+		bson.M{"deletionScheduledAt": bson.M{"$lte": now}, "deletedAt": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var due []models.User
+	if err := cursor.All(ctx, &due); err != nil {
+		return nil, err
+	}
+
+	var erased []primitive.ObjectID
+	for _, user := range due {
+		if err := Erase(ctx, db, user.ID, policy); err != nil {
+			log.Printf("[%s] failed to erase user %s: %v", db.Name(), user.ID.Hex(), err)
+			continue
+		}
+		erased = append(erased, user.ID)
+	}
+	return erased, nil
+}
@@ -0,0 +1,49 @@
+package privacy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coders-website/backend/internal/models"
+)
+
+func TestGracePeriod(t *testing.T) {
+	zero, week := 0, 7
+	cases := []struct {
+		policy Policy
+		want   time.Duration
+	}{
+		{Policy{}, 14 * 24 * time.Hour},
+		{Policy{GraceDays: &week}, 7 * 24 * time.Hour},
+		{Policy{GraceDays: &zero}, 0},
+	}
+	for _, c := range cases {
+		if got := c.policy.GracePeriod(); got != c.want {
+			t.Errorf("GracePeriod(%+v) = %s, want %s", c.policy, got, c.want)
+		}
+	}
+}
+
+func TestPublicSocialLeavesOutSecrets(t *testing.T) {
+	social := &models.SocialCredentials{
+		Reddit:   &models.RedditCredentials{ClientID: "id", ClientSecret: "reddit-secret", Username: "ada", Password: "reddit-password"},
+		Facebook: &models.FacebookCredentials{PageID: "page-1", PageAccessToken: "fb-token"},
+		Twitter:  &models.TwitterCredentials{APISecret: "twitter-secret"},
+	}
+	out, _ := json.Marshal(PublicSocial(social))
+	for _, secret := range []string{"reddit-secret", "reddit-password", "fb-token", "twitter-secret"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("export contains %q: %s", secret, out)
+		}
+	}
+	for _, kept := range []string{"ada", "page-1", "twitter"} {
+		if !strings.Contains(string(out), kept) {
+			t.Errorf("export is missing %q: %s", kept, out)
+		}
+	}
+	if len(PublicSocial(nil)) != 0 {
+		t.Error("no social accounts should export as empty")
+	}
+}
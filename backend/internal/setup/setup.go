@@ -0,0 +1,121 @@
+// Package setup guards the one-time creation of a site's first admin. Until a site has
+// an admin, anyone holding its setup token can create one; afterwards setup is locked
+// for good, even if that admin is later deleted.
+package setup
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coders-website/backend/internal/auth"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrCompleted is returned when a site already has its first admin
+var ErrCompleted = errors.New("setup already completed")
+
+// lockID is the _id of the document that records a site's setup as done
+const lockID = "first-admin"
+
+// lock is stored in the site's "setup" collection once the first admin exists
+type lock struct {
+	ID          string             `bson:"_id"`
+	AdminID     primitive.ObjectID `bson:"adminId,omitempty"`
+	CompletedAt time.Time          `bson:"completedAt"`
+}
+
+// Completed reports whether the site using db already has its first admin. Sites that
+// had admins before setup locks existed are locked on first check.
+func Completed(ctx context.Context, db *mongo.Database) (bool, error) {
+	err := db.Collection("setup").FindOne(ctx, bson.M{"_id": lockID}).Err()
+	if err == nil {
+		return true, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return false, err
+	}
+
+	var admin struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err = db.Collection("users").FindOne(ctx, bson.M{"role": "admin"}).Decode(&admin)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, MarkCompleted(ctx, db, admin.ID)
+}
+
+// Claim reserves setup for a new admin. Only one caller can claim a site; the rest get
+// ErrCompleted. Call Release if the admin can't be created after all.
+func Claim(ctx context.Context, db *mongo.Database, adminID primitive.ObjectID) error {
+	if done, err := Completed(ctx, db); err != nil {
+		return err
+	} else if done {
+		return ErrCompleted
+	}
+	_, err := db.Collection("setup").InsertOne(ctx, lock{ID: lockID, AdminID: adminID, CompletedAt: time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCompleted
+	}
+	return err
+}
+
+// Release undoes a Claim whose admin wasn't created
+func Release(ctx context.Context, db *mongo.Database, adminID primitive.ObjectID) error {
+	_, err := db.Collection("setup").DeleteOne(ctx, bson.M{"_id": lockID, "adminId": adminID})
+	return err
+}
+
+// MarkCompleted locks setup for a site whose admin was provisioned another way, such as
+// with cmd/init-admin
+func MarkCompleted(ctx context.Context, db *mongo.Database, adminID primitive.ObjectID) error {
+	_, err := db.Collection("setup").UpdateOne(ctx,
+		bson.M{"_id": lockID},
+		bson.M{"$setOnInsert": lock{ID: lockID, AdminID: adminID, CompletedAt: time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+var (
+	tokensMu sync.RWMutex
+	tokens   = map[string]string{} // Database name to token hash
+)
+
+// NewToken creates the setup token for a site's database, replacing any earlier one.
+// Tokens only live as long as the process, so a restart prints new ones.
+func NewToken(database string) (string, error) {
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	tokensMu.Lock()
+	tokens[database] = auth.HashToken(token)
+	tokensMu.Unlock()
+	return token, nil
+}
+
+// CheckToken reports whether token is the setup token for the database. SETUP_TOKEN,
+// when set, is accepted for every site instead of generated tokens.
+func CheckToken(database, token string) bool {
+	if token == "" {
+		return false
+	}
+	if fixed := os.Getenv("SETUP_TOKEN"); fixed != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(fixed)) == 1
+	}
+	tokensMu.RLock()
+	want, ok := tokens[database]
+	tokensMu.RUnlock()
+	return ok && subtle.ConstantTimeCompare([]byte(auth.HashToken(token)), []byte(want)) == 1
+}
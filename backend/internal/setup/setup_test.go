@@ -0,0 +1,38 @@
+package setup
+
+import "testing"
+
+func TestSetupTokens(t *testing.T) {
+	t.Setenv("SETUP_TOKEN", "")
+
+	token, err := NewToken("site_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckToken("site_a", token) {
+		t.Error("token rejected for its own site")
+	}
+	if CheckToken("site_b", token) {
+		t.Error("token accepted for another site")
+	}
+	if CheckToken("site_a", "") || CheckToken("site_a", token+"x") {
+		t.Error("wrong token accepted")
+	}
+
+	replacement, _ := NewToken("site_a")
+	if CheckToken("site_a", token) || !CheckToken("site_a", replacement) {
+		t.Error("a new token should replace the old one")
+	}
+}
+
+func TestFixedSetupToken(t *testing.T) {
+	t.Setenv("SETUP_TOKEN", "from-env")
+	generated, _ := NewToken("site_a")
+
+	if !CheckToken("site_a", "from-env") || !CheckToken("site_c", "from-env") {
+		t.Error("SETUP_TOKEN should be accepted for every site")
+	}
+	if CheckToken("site_a", generated) {
+		t.Error("generated tokens should be ignored when SETUP_TOKEN is set")
+	}
+}